@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// tagAliasesKey holds the alias table: a map from an alias to the canonical
+// tag it stands for, both as canonical keys. Aliases are applied when reading,
+// so editing the table never requires re-indexing.
+const (
+	tagAliasesKey     = "tag_aliases"
+	tagAliasesLockKey = "tag_aliases_lock"
+)
+
+type TagAlias struct {
+	Alias string `json:"alias"`
+	Tag   string `json:"tag"`
+}
+
+type TagAliasesResponse struct {
+	Aliases map[string]string `json:"aliases"`
+}
+
+func (p *Plugin) getTagAliases() (map[string]string, error) {
+	aliases := map[string]string{}
+	if _, err := p.kvGetJSON(tagAliasesKey, &aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// resolveTag returns the canonical tag a key stands for.
+func resolveTag(key string, aliases map[string]string) string {
+	if tag, ok := aliases[key]; ok {
+		return tag
+	}
+	return key
+}
+
+// expandTag returns every key that counts as the tag a key stands for: the
+// canonical tag first, then its aliases in order.
+func expandTag(key string, aliases map[string]string) []string {
+	tag := resolveTag(key, aliases)
+	var expanded []string
+	for alias, target := range aliases {
+		if target == tag {
+			expanded = append(expanded, alias)
+		}
+	}
+	sort.Strings(expanded)
+	return append([]string{tag}, expanded...)
+}
+
+// addPostTags counts a post under the canonical tag of each of its tags, once
+// per tag even when the post spells it through several aliases, and returns
+// the number of tags it counted.
+func addPostTags(counts map[string]*hashtagInfo, e indexedPost, aliases map[string]string) int {
+	n := 0
+	seen := map[string]bool{}
+	for _, t := range e.Tags {
+		key := canonicalTag(t)
+		tag := resolveTag(key, aliases)
+		info := hashtagInfoFor(counts, tag)
+		info.variants[t]++
+		if key != tag {
+			info.addAlias(key)
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		info.add(1, e.CreateAt, e.CreateAt)
+		n++
+	}
+	return n
+}
+
+// validateTagAlias checks that adding an alias keeps the table flat: an alias
+// never points at another alias, and a canonical tag is never itself aliased.
+func validateTagAlias(alias TagAlias, aliases map[string]string) error {
+	if alias.Alias == "" || alias.Tag == "" {
+		return errors.New("alias and tag are required")
+	}
+	for _, tag := range []string{alias.Alias, alias.Tag} {
+		if !isHashtag(tag) {
+			return fmt.Errorf("%s is not a valid hashtag", tag)
+		}
+	}
+	if alias.Alias == alias.Tag {
+		return errors.New("a tag cannot be an alias of itself")
+	}
+	if _, ok := aliases[alias.Tag]; ok {
+		return fmt.Errorf("%s is itself an alias of %s", alias.Tag, aliases[alias.Tag])
+	}
+	for a, target := range aliases {
+		if target == alias.Alias {
+			return fmt.Errorf("%s is the canonical tag of alias %s", alias.Alias, a)
+		}
+	}
+	return nil
+}
+
+// updateTagAliases applies fn to the alias table under the alias lock and
+// saves the result, unless fn fails. It returns the table as saved.
+func (p *Plugin) updateTagAliases(fn func(aliases map[string]string) error) (map[string]string, error) {
+	var aliases map[string]string
+	err := p.withLock(tagAliasesLockKey, func() error {
+		current, err := p.getTagAliases()
+		if err != nil {
+			return err
+		}
+		if err := fn(current); err != nil {
+			return err
+		}
+		aliases = current
+		return p.kvSetJSON(tagAliasesKey, aliases)
+	})
+	return aliases, err
+}
+
+// GET /api/aliases
+// POST /api/aliases {"alias": "k8s", "tag": "kubernetes"}
+// DELETE /api/aliases?alias=k8s
+func (p *Plugin) handleTagAliases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var aliases map[string]string
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		aliases, err = p.getTagAliases()
+	case http.MethodPost:
+		var alias TagAlias
+		if err := json.NewDecoder(r.Body).Decode(&alias); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		alias.Alias = normalizeTagQuery(alias.Alias)
+		alias.Tag = normalizeTagQuery(alias.Tag)
+		var invalid error
+		aliases, err = p.updateTagAliases(func(aliases map[string]string) error {
+			if invalid = validateTagAlias(alias, aliases); invalid != nil {
+				return invalid
+			}
+			aliases[alias.Alias] = alias.Tag
+			return nil
+		})
+		if invalid != nil {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		aliases, err = p.updateTagAliases(func(aliases map[string]string) error {
+			delete(aliases, normalizeTagQuery(r.URL.Query().Get("alias")))
+			return nil
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(TagAliasesResponse{Aliases: aliases}); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagAliases(t *testing.T) {
+	aliases := map[string]string{"k8s": "kubernetes", "kube": "kubernetes"}
+
+	assert.Equal(t, []string{"kubernetes", "k8s", "kube"}, expandTag("kubernetes", aliases))
+	assert.Equal(t, []string{"kubernetes", "k8s", "kube"}, expandTag("k8s", aliases))
+	assert.Equal(t, []string{"docker"}, expandTag("docker", aliases))
+}
+
+// TestTagAliasCounts checks that a post using a tag and its alias is counted
+// once, on every counting path.
+func TestTagAliasCounts(t *testing.T) {
+	api := newTestAPI()
+	api.corpusAPI = newMessagesAPI([]string{"#K8s", "#k8s and #kubernetes", "#Kubernetes"})
+	p := &Plugin{}
+	p.SetAPI(api)
+	require.NoError(t, p.kvSetJSON(tagAliasesKey, map[string]string{"k8s": "kubernetes"}))
+
+	// channel1 is scanned; channel2 holds the same posts in the index
+	require.NoError(t, p.withChannelIndex("channel2", func(b *indexBatch) error {
+		for _, id := range api.order {
+			entry := p.indexEntryForPost(api.posts[id], map[string]*model.User{})
+			entry.ChannelID = "channel2"
+			if err := b.put(entry); err != nil {
+				return err
+			}
+		}
+		b.idx.Indexed = true
+		b.idx.Series = true
+		return nil
+	}))
+
+	for _, tc := range []struct {
+		name      string
+		channelID string
+		window    timeRange
+	}{
+		{"scanned", "channel1", timeRange{}},
+		{"indexed", "channel2", timeRange{}},
+		{"indexed in range", "channel2", timeRange{Since: 1000, Until: 1002}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tags, err := p.computeHashtags(tc.channelID, 0, tc.window)
+			require.NoError(t, err)
+			require.Len(t, tags, 1)
+			assert.Equal(t, "kubernetes", tags[0].Tag)
+			assert.Equal(t, "Kubernetes", tags[0].Display)
+			assert.Equal(t, []string{"K8s", "Kubernetes", "k8s", "kubernetes"}, tags[0].Variants)
+			assert.Equal(t, []string{"k8s"}, tags[0].Aliases)
+			assert.Equal(t, 3, tags[0].Count)
+			assert.Equal(t, int64(1000), tags[0].CreateAt)
+			assert.Equal(t, int64(1002), tags[0].LastUsed)
+		})
+	}
+
+	// The post using both spellings counts once in the timeseries too
+	for _, channelID := range []string{"channel1", "channel2"} {
+		series, err := p.tagTimeseries("k8s", []string{channelID}, bucketMonth, time.UTC, timeRange{Since: 0, Until: 1002})
+		require.NoError(t, err)
+		require.Len(t, series.Points, 1, channelID)
+		assert.Equal(t, 3, series.Points[0].Posts, channelID)
+		assert.Equal(t, 1, series.Points[0].Authors, channelID)
+	}
+
+	page, err := p.pageTagPosts(tagAnyOf(expandTag("k8s", map[string]string{"k8s": "kubernetes"})), []string{"channel2"}, timeRange{}, nil, 0, 2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	assert.Len(t, page.Entries, 2)
+}
+
+func TestValidateTagAlias(t *testing.T) {
+	aliases := map[string]string{"k8s": "kubernetes"}
+
+	assert.NoError(t, validateTagAlias(TagAlias{Alias: "kube", Tag: "kubernetes"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "kube", Tag: "kube"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "kube", Tag: "k8s"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "kubernetes", Tag: "kube"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "", Tag: "kube"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "3d", Tag: "printing"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "k8s cluster", Tag: "kubernetes"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "kube!", Tag: "kubernetes"}, aliases))
+	assert.Error(t, validateTagAlias(TagAlias{Alias: "ku", Tag: "kubernetes"}, aliases))
+}
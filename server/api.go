@@ -2,31 +2,56 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
+// HashtagCount is the usage of one tag. Tag is the canonical, lower-case key
+// used for lookups; Display is the most common casing and Variants every
+// spelling seen, most common first, including those of Aliases. Facet is set
+// for key:value tags, and Trend when the tags were sorted by trending score.
 type HashtagCount struct {
-	Tag      string `json:"tag"`
-	Count    int    `json:"count"`
-	CreateAt int64  `json:"createAt"`
-	LastUsed int64  `json:"lastUsed"`
+	Tag      string    `json:"tag"`
+	Display  string    `json:"display"`
+	Variants []string  `json:"variants"`
+	Aliases  []string  `json:"aliases,omitempty"`
+	Facet    *TagFacet `json:"facet,omitempty"`
+	Count    int       `json:"count"`
+	CreateAt int64     `json:"createAt"`
+	LastUsed int64     `json:"lastUsed"`
+	Trend    *float64  `json:"trend,omitempty"`
 }
 
+// HashtagPost is a post returned by a tag search. MatchedTag is the tag as
+// the post wrote it, which may be an alias of the tag searched for.
 type HashtagPost struct {
-	ID        string `json:"id"`
-	Message   string `json:"message"`
-	CreateAt  int64  `json:"create_at"`
-	Username  string `json:"username"`
-	ChannelID string `json:"channel_id"`
+	ID         string `json:"id"`
+	Message    string `json:"message"`
+	CreateAt   int64  `json:"create_at"`
+	Username   string `json:"username"`
+	ChannelID  string `json:"channel_id"`
+	MatchedTag string `json:"matched_tag,omitempty"`
 }
 
+// HashtagResponse lists the tags of a channel or team. Groups is the flat
+// one-level grouping on "-" kept for older clients; Tree nests the tags on
+// every configured separator.
 type HashtagResponse struct {
-	Hashtags []HashtagCount `json:"hashtags"`
-	Groups   []HashtagGroup `json:"groups"`
+	Hashtags []HashtagCount     `json:"hashtags"`
+	Groups   []HashtagGroup     `json:"groups"`
+	Tree     []*HashtagTreeNode `json:"tree"`
+}
+
+// QueryErrorResponse is returned with status 400 when a tag query cannot be parsed.
+type QueryErrorResponse struct {
+	Error *QueryError `json:"error"`
 }
 
 type PaginatedHashtagResponse struct {
@@ -35,26 +60,50 @@ type PaginatedHashtagResponse struct {
 	Page       int           `json:"page"`
 	PerPage    int           `json:"per_page"`
 	HasMore    bool          `json:"has_more"`
+	NextCursor string        `json:"next_cursor,omitempty"`
 }
 
 // GET /api/hashtags?channel_id=XXX&limit=200
+// GET /api/hashtags?channel_id=XXX&since=7d&until=1700000000000
+// GET /api/hashtags?channel_id=XXX&sort=trending&window=7d
 func (p *Plugin) handleHashtags(w http.ResponseWriter, r *http.Request) {
 	channelID := r.URL.Query().Get("channel_id")
 	if channelID == "" {
 		http.Error(w, "channel_id required", http.StatusBadRequest)
 		return
 	}
+	if !p.canReadChannel(r.Header.Get("Mattermost-User-ID"), channelID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	window, err := parseTimeRange(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	hashtags, err := p.computeHashtags(channelID, 5000) // scan up to N recent posts; tune as needed
+	hashtags, err := p.computeHashtags(channelID, 5000, window) // scan up to N recent posts; tune as needed
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	channelIDs := func() ([]string, error) { return []string{channelID}, nil }
+	if status, err := p.sortHashtags(r, hashtags, channelIDs); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
 	
+	tree, err := p.hashtagTree(hashtags, channelIDs, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	groups := groupHashtagsByPrefix(hashtags)
 	response := HashtagResponse{
 		Hashtags: hashtags,
 		Groups:   groups,
+		Tree:     tree,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -64,26 +113,45 @@ func (p *Plugin) handleHashtags(w http.ResponseWriter, r *http.Request) {
 }
 
 // GET /api/posts?tag=XXX&page=1&per_page=20
+// GET /api/posts?tag=XXX&cursor=YYY&per_page=20
+// GET /api/posts?facet=status=blocked&facet=prio=high
+// GET /api/posts?q=%23incident+AND+%23database+NOT+%23resolved&since=30d
 func (p *Plugin) handleGetTagPosts(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
-	tag := r.URL.Query().Get("tag")
-	if tag == "" {
-		http.Error(w, "tag is required", http.StatusBadRequest)
+	tag := normalizeTagQuery(r.URL.Query().Get("tag"))
+	query := r.URL.Query().Get("q")
+	facets, err := parseFacetFilters(r.URL.Query()["facet"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tag == "" && query == "" && len(facets) == 0 {
+		http.Error(w, "tag, q or facet is required", http.StatusBadRequest)
+		return
+	}
+	window, err := parseTimeRange(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	userID := r.Header.Get("Mattermost-User-ID")
 	channelID := r.URL.Query().Get("channel_id")
-	
+	if channelID != "" && !p.canReadChannel(userID, channelID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	// Parse pagination parameters
 	page := r.URL.Query().Get("page")
 	perPage := r.URL.Query().Get("per_page")
-	
+
 	pageNum := 1
 	if page != "" {
 		if p, err := strconv.Atoi(page); err == nil && p > 0 {
 			pageNum = p
 		}
 	}
-	
+
 	perPageNum := 20 // default
 	if perPage != "" {
 		if pp, err := strconv.Atoi(perPage); err == nil && pp > 0 && pp <= 100 {
@@ -91,66 +159,80 @@ func (p *Plugin) handleGetTagPosts(c *plugin.Context, w http.ResponseWriter, r *
 		}
 	}
 
-	p.API.LogDebug("Getting posts for tag", "tag", tag, "channel_id", channelID, "page", pageNum, "per_page", perPageNum)
-
-	// Get channel info for logging
-	if channelID != "" {
-		if channel, err := p.API.GetChannel(channelID); err == nil {
-			p.API.LogDebug("Channel info", "name", channel.Name, "team_id", channel.TeamId)
-		} else {
-			p.API.LogError("Failed to get channel info", "error", err.Error())
+	// A cursor from a previous response takes precedence over the page number,
+	// which has to walk every post of the earlier pages
+	var cursor *tagPostCursor
+	offset := (pageNum - 1) * perPageNum
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = decodeTagPostCursor(cursorStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		offset = 0
 	}
-	
-	// Get all posts first, then paginate
-	// TODO: Optimize this to paginate at database level in the future
-	allPosts, err := p.getPostsWithHashtag(tag, channelID)
+
+	aliases, err := p.getTagAliases()
 	if err != nil {
-		p.API.LogError("Failed to get posts", "error", err.Error(), "tag", tag, "channel_id", channelID)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	if allPosts == nil {
-		allPosts = []HashtagPost{} // Return empty array instead of null
+	matcher := facetMatcher(facets, aliases)
+	if tag != "" {
+		matcher = append(tagAllOf{tagAnyOf(expandTag(tag, aliases))}, matcher...)
+	}
+	if query != "" {
+		parsed, qerr := parseTagQuery(query, aliases)
+		if qerr != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			if err := json.NewEncoder(w).Encode(QueryErrorResponse{Error: qerr}); err != nil {
+				p.API.LogError("Failed to write response", "error", err.Error())
+			}
+			return
+		}
+		matcher = append(tagAllOf{parsed}, matcher...)
 	}
 
-	totalCount := len(allPosts)
-	startIndex := (pageNum - 1) * perPageNum
-	endIndex := startIndex + perPageNum
+	p.API.LogDebug("Getting posts for tag", "tag", tag, "q", query, "channel_id", channelID, "page", pageNum, "per_page", perPageNum)
 
-	// Ensure we don't exceed array bounds
-	if startIndex >= totalCount {
-		startIndex = totalCount
-	}
-	if endIndex > totalCount {
-		endIndex = totalCount
+	channelIDs, err := p.tagSearchChannelIDs(userID, channelID)
+	if err != nil {
+		p.API.LogError("Failed to get channels", "error", err.Error(), "tag", tag, "channel_id", channelID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	var paginatedPosts []HashtagPost
-	if startIndex < endIndex {
-		paginatedPosts = allPosts[startIndex:endIndex]
-	} else {
-		paginatedPosts = []HashtagPost{}
+	users := map[string]*model.User{}
+	result, err := p.pageTagPosts(matcher, channelIDs, window, cursor, offset, perPageNum, users)
+	if err != nil {
+		p.API.LogError("Failed to get posts", "error", err.Error(), "tag", tag, "channel_id", channelID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	hasMore := endIndex < totalCount
-	
 	response := PaginatedHashtagResponse{
-		Posts:      paginatedPosts,
-		TotalCount: totalCount,
+		Posts:      p.hashtagPosts(result.Entries, matcher, users),
+		TotalCount: result.Total,
 		Page:       pageNum,
 		PerPage:    perPageNum,
-		HasMore:    hasMore,
+		HasMore:    result.HasMore,
+	}
+	if result.HasMore {
+		last := result.Entries[len(result.Entries)-1]
+		response.NextCursor = tagPostCursor{CreateAt: last.CreateAt, PostID: last.ID}.encode()
 	}
 
-	p.API.LogDebug("Returning paginated posts", "total", totalCount, "page", pageNum, "per_page", perPageNum, "returned", len(paginatedPosts), "has_more", hasMore)
+	p.API.LogDebug("Returning paginated posts", "total", response.TotalCount, "page", pageNum, "per_page", perPageNum, "returned", len(response.Posts), "has_more", response.HasMore)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
 }
 
-// GET /api/team_hashtags?team_id=XXX&max=1000
+// GET /api/team_hashtags?team_id=XXX&max=1000&since=30d&sort=recent
 func (p *Plugin) handleTeamHashtags(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -164,6 +246,10 @@ func (p *Plugin) handleTeamHashtags(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing team_id parameter", http.StatusBadRequest)
 		return
 	}
+	if !p.API.HasPermissionToTeam(r.Header.Get("Mattermost-User-ID"), teamID, model.PermissionViewTeam) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	maxStr := r.URL.Query().Get("max")
 	max := 1000
@@ -176,17 +262,35 @@ func (p *Plugin) handleTeamHashtags(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	hashtags, err := p.computeTeamHashtags(teamID, max)
+	window, err := parseTimeRange(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashtags, err := p.computeTeamHashtags(teamID, max, window)
 	if err != nil {
 		p.API.LogError("Failed to compute team hashtags", "error", err.Error(), "team_id", teamID)
 		http.Error(w, fmt.Sprintf("Internal error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	channelIDs := func() ([]string, error) { return p.teamChannelIDs(teamID) }
+	if status, err := p.sortHashtags(r, hashtags, channelIDs); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	tree, err := p.hashtagTree(hashtags, channelIDs, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	groups := groupHashtagsByPrefix(hashtags)
 	response := HashtagResponse{
 		Hashtags: hashtags,
 		Groups:   groups,
+		Tree:     tree,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -195,16 +299,120 @@ func (p *Plugin) handleTeamHashtags(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// ServeHTTP handles HTTP requests to the plugin
-func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/api/hashtags":
-		p.handleHashtags(w, r)
-	case "/api/team_hashtags":
-		p.handleTeamHashtags(w, r)
-	case "/api/posts":
-		p.handleGetTagPosts(c, w, r)
+// sortHashtags orders counted tags by the sort parameter of a request: count
+// (the default), recent for the most recently used first, or trending, which
+// scores the tags of the channels listed by channelIDs. On failure it also
+// returns the HTTP status to answer with.
+func (p *Plugin) sortHashtags(r *http.Request, hashtags []HashtagCount, channelIDs func() ([]string, error)) (int, error) {
+	switch r.URL.Query().Get("sort") {
+	case "", "count":
+	case "recent":
+		sort.SliceStable(hashtags, func(i, j int) bool { return hashtags[i].LastUsed > hashtags[j].LastUsed })
+	case "trending":
+		opts, err := p.parseTrendingOptions(r)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		ids, err := channelIDs()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		trending, err := p.trendingTags(ids, time.Now(), opts)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		sortHashtagsByTrend(hashtags, trending)
 	default:
-		http.NotFound(w, r)
+		return http.StatusBadRequest, errors.New("sort must be count, recent or trending")
 	}
+	return http.StatusOK, nil
+}
+
+// GET /api/admin/backfill
+func (p *Plugin) handleBackfillStatus(w http.ResponseWriter, r *http.Request) {
+	if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	status, err := p.getBackfillStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
+
+// MattermostAuthorizationRequired rejects requests that do not come from a
+// logged-in user. The server sets Mattermost-User-ID for authenticated requests.
+func (p *Plugin) MattermostAuthorizationRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-ID")
+		if userID == "" {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canReadChannel reports whether a user may read the posts of a channel.
+func (p *Plugin) canReadChannel(userID, channelID string) bool {
+	return p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel)
+}
+
+// ServeHTTP handles HTTP requests to the plugin
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/hashtags":
+			p.handleHashtags(w, r)
+		case "/api/team_hashtags":
+			p.handleTeamHashtags(w, r)
+		case "/api/posts":
+			p.handleGetTagPosts(c, w, r)
+		case "/api/admin/backfill":
+			p.handleBackfillStatus(w, r)
+		case "/api/admin/tag_report":
+			p.handleTagReport(w, r)
+		case "/api/admin/archived_tags":
+			p.handleArchivedTags(w, r)
+		case "/api/admin/merge_tag":
+			p.handleMergeTag(w, r)
+		case "/api/admin/routing":
+			p.handleRoutingRules(w, r)
+		case "/api/admin/webhooks":
+			p.handleWebhooks(w, r)
+		case "/api/aliases":
+			p.handleTagAliases(w, r)
+		case "/api/facets":
+			p.handleFacets(w, r)
+		case "/api/related":
+			p.handleRelatedTags(w, r)
+		case "/api/tag_timeseries":
+			p.handleTagTimeseries(w, r)
+		case "/api/trending":
+			p.handleTrending(w, r)
+		case "/api/contributors":
+			p.handleTagContributors(w, r)
+		case "/api/experts":
+			p.handleTagExperts(w, r)
+		case "/api/expertise":
+			p.handleUserExpertise(w, r)
+		case "/api/follows":
+			p.handleFollows(w, r)
+		case "/api/autocomplete":
+			p.handleAutocomplete(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// Middleware to require that the user is logged in
+	p.MattermostAuthorizationRequired(router).ServeHTTP(w, r)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+// authAPI grants or denies every channel, team and system permission.
+type authAPI struct {
+	*testAPI
+	channel bool
+	team    bool
+	system  bool
+}
+
+func (a *authAPI) HasPermissionToChannel(userID, channelID string, permission *model.Permission) bool {
+	return a.channel
+}
+
+func (a *authAPI) HasPermissionToTeam(userID, teamID string, permission *model.Permission) bool {
+	return a.team
+}
+
+func (a *authAPI) HasPermissionTo(userID string, permission *model.Permission) bool {
+	return a.system
+}
+
+func TestServeHTTPAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		userID string
+		// denied names the permission the user lacks: channel, team or system
+		denied string
+		code   int
+	}{
+		{name: "no user", url: "/api/hashtags?channel_id=channel1", code: http.StatusUnauthorized},
+		{name: "no user on an admin endpoint", url: "/api/admin/backfill", code: http.StatusUnauthorized},
+		{name: "channel tags", url: "/api/hashtags?channel_id=channel1", userID: "user1", code: http.StatusOK},
+
+		{name: "channel tags of an unreadable channel", url: "/api/hashtags?channel_id=channel1", userID: "user1", denied: "channel", code: http.StatusForbidden},
+		{name: "posts of an unreadable channel", url: "/api/posts?tag=alpha&channel_id=channel1", userID: "user1", denied: "channel", code: http.StatusForbidden},
+		{name: "facets of an unreadable channel", url: "/api/facets?channel_id=channel1", userID: "user1", denied: "channel", code: http.StatusForbidden},
+		{name: "trending in an unreadable channel", url: "/api/trending?scope=channel&channel_id=channel1", userID: "user1", denied: "channel", code: http.StatusForbidden},
+
+		{name: "team tags", url: "/api/team_hashtags?team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team facets", url: "/api/facets?team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team trending", url: "/api/trending?scope=team&team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team related tags", url: "/api/related?tag=alpha&team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team timeseries", url: "/api/tag_timeseries?tag=alpha&team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team contributors", url: "/api/contributors?tag=alpha&team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team experts", url: "/api/experts?tag=alpha&team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team expertise", url: "/api/expertise?team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+		{name: "team autocomplete", url: "/api/autocomplete?prefix=al&team_id=team1", userID: "user1", denied: "team", code: http.StatusForbidden},
+
+		{name: "backfill status", url: "/api/admin/backfill", userID: "user1", code: http.StatusOK},
+		{name: "backfill status without admin", url: "/api/admin/backfill", userID: "user1", denied: "system", code: http.StatusForbidden},
+		{name: "tag report without admin", url: "/api/admin/tag_report", userID: "user1", denied: "system", code: http.StatusForbidden},
+		{name: "archived tags without admin", url: "/api/admin/archived_tags", userID: "user1", denied: "system", code: http.StatusForbidden},
+		{name: "merge tag without admin", method: http.MethodPost, url: "/api/admin/merge_tag", userID: "user1", denied: "system", code: http.StatusForbidden},
+		{name: "routing rules without admin", url: "/api/admin/routing", userID: "user1", denied: "system", code: http.StatusForbidden},
+		{name: "webhooks without admin", url: "/api/admin/webhooks", userID: "user1", denied: "system", code: http.StatusForbidden},
+		{name: "alias edit without admin", method: http.MethodPost, url: "/api/aliases", userID: "user1", denied: "system", code: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &authAPI{
+				testAPI: newTestAPI(),
+				channel: tc.denied != "channel",
+				team:    tc.denied != "team",
+				system:  tc.denied != "system",
+			}
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tc.url, nil)
+			if tc.userID != "" {
+				r.Header.Set("Mattermost-User-ID", tc.userID)
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(&plugin.Context{}, w, r)
+			assert.Equal(t, tc.code, w.Code, w.Body.String())
+		})
+	}
+}
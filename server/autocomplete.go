@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// autocompleteURL is where the dynamic tag arguments of /hashtags fetch
+	// their suggestions, relative to the plugin.
+	autocompleteURL = "api/autocomplete"
+
+	autocompleteLimit = 20
+
+	// autocompleteHalfLife is the age at which a post counts half as much
+	// towards the ranking of its tags.
+	autocompleteHalfLife = 30 * 24 * time.Hour
+)
+
+// tagSuggestion is a tag matching what was typed, with the score it is
+// ranked by. Alias is set when only an alias of the tag matched.
+type tagSuggestion struct {
+	Tag   string
+	Alias string
+	Count int
+	Score float64
+}
+
+// recencyWeightedCount scores a tag in one channel from its monthly counters:
+// each post counts 1, halved for every autocompleteHalfLife of age. Posts are
+// dated by their month, except in the month the tag was last used.
+func recencyWeightedCount(stats *tagStats, now time.Time) float64 {
+	lastMonth := indexMonth(stats.LastUsed)
+	score := 0.0
+	for month, n := range stats.Months {
+		at := stats.LastUsed
+		if month != lastMonth {
+			t, err := time.Parse(indexMonthLayout, month)
+			if err != nil {
+				continue
+			}
+			at = t.UnixMilli()
+		}
+		age := max(now.UnixMilli()-at, 0)
+		score += float64(n) * math.Exp2(-float64(age)/float64(autocompleteHalfLife.Milliseconds()))
+	}
+	return score
+}
+
+// autocompleteTags ranks the tags of a set of channels starting with prefix,
+// a canonical key, by recency-weighted frequency. A tag also matches when one
+// of its aliases starts with prefix, so typing k8 suggests #kubernetes.
+// Aliases count towards their tag and archived tags are left out until they
+// are used again. It reads the
+// per-channel counters only, so channels still being backfilled contribute
+// the posts indexed so far.
+func (p *Plugin) autocompleteTags(channelIDs []string, prefix string, now time.Time, limit int) ([]tagSuggestion, error) {
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := p.getArchivedTags()
+	if err != nil {
+		return nil, err
+	}
+
+	// The tags reached through an alias starting with prefix, and the first
+	// such alias of each
+	viaAlias := map[string]string{}
+	for alias, tag := range aliases {
+		if strings.HasPrefix(alias, prefix) && (viaAlias[tag] == "" || alias < viaAlias[tag]) {
+			viaAlias[tag] = alias
+		}
+	}
+
+	counts := map[string]*hashtagInfo{}
+	scores := map[string]float64{}
+	for _, channelID := range channelIDs {
+		idx, err := p.getChannelIndex(channelID)
+		if err != nil {
+			return nil, err
+		}
+		if idx == nil {
+			continue
+		}
+		for key, stats := range idx.Tags {
+			tag := resolveTag(key, aliases)
+			if !strings.HasPrefix(tag, prefix) && viaAlias[tag] == "" {
+				continue
+			}
+			hashtagInfoFor(counts, tag).add(stats.Count, stats.CreateAt, stats.LastUsed)
+			scores[tag] += recencyWeightedCount(stats, now)
+		}
+	}
+	hideArchivedTags(counts, archived)
+
+	suggestions := make([]tagSuggestion, 0, len(counts))
+	for tag, info := range counts {
+		suggestion := tagSuggestion{Tag: tag, Count: info.count, Score: scores[tag]}
+		if !strings.HasPrefix(tag, prefix) {
+			suggestion.Alias = viaAlias[tag]
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Tag < suggestions[j].Tag
+	})
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// autocompleteTyped returns the argument being typed at the end of a slash
+// command, "" when a new one is about to start.
+func autocompleteTyped(userInput string) string {
+	fields := strings.Fields(userInput)
+	if len(fields) < 2 || strings.HasSuffix(userInput, " ") {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// GET /api/autocomplete?user_input=/hashtags%20stats%20kub&team_id=XXX
+// GET /api/autocomplete?prefix=kub&team_id=XXX
+func (p *Plugin) handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	query := r.URL.Query()
+	teamID := query.Get("team_id")
+	if teamID != "" && !p.API.HasPermissionToTeam(userID, teamID, model.PermissionViewTeam) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	typed := query.Get("prefix")
+	if !query.Has("prefix") {
+		typed = autocompleteTyped(query.Get("user_input"))
+	}
+
+	channelIDs, err := p.visibleChannelIDs(userID, teamID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash, bare := "", typed
+	if strings.HasPrefix(typed, "#") {
+		hash, bare = "#", typed[1:]
+	}
+	suggestions, err := p.autocompleteTags(channelIDs, canonicalTag(bare), time.Now(), autocompleteLimit)
+	if err != nil {
+		p.API.LogError("Failed to autocomplete tags", "error", err.Error(), "prefix", typed)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The server only keeps the items starting with what was typed, so the
+	// typed text is kept as is and only completed. A tag found through an
+	// alias completes the alias and names the tag in its help text.
+	items := make([]model.AutocompleteListItem, 0, len(suggestions))
+	for _, s := range suggestions {
+		item, helpText := s.Tag, fmt.Sprintf("Used in %d posts", s.Count)
+		if s.Alias != "" {
+			item, helpText = s.Alias, fmt.Sprintf("Alias of #%s, used in %d posts", s.Tag, s.Count)
+		}
+		if rest, ok := strings.CutPrefix(item, canonicalTag(bare)); ok {
+			item = bare + rest
+		}
+		items = append(items, model.AutocompleteListItem{
+			Item:     hash + item,
+			HelpText: helpText,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// autocompleteAPI puts every user in channel1 and channel2 of team1.
+type autocompleteAPI struct {
+	*testAPI
+}
+
+func (a *autocompleteAPI) HasPermissionToTeam(userID, teamID string, permission *model.Permission) bool {
+	return teamID == "team1"
+}
+
+func (a *autocompleteAPI) GetChannelsForTeamForUser(teamID, userID string, includeDeleted bool) ([]*model.Channel, *model.AppError) {
+	return []*model.Channel{{Id: "channel1", TeamId: teamID}, {Id: "channel2", TeamId: teamID}}, nil
+}
+
+func setupAutocompleteIndex(t *testing.T, p *Plugin, now time.Time) {
+	t.Helper()
+	at := func(days int) int64 { return now.AddDate(0, 0, -days).UnixMilli() }
+	stats := func(lastUsed int64, months map[string]int) *tagStats {
+		count := 0
+		for _, n := range months {
+			count += n
+		}
+		return &tagStats{Count: count, CreateAt: lastUsed, LastUsed: lastUsed, Months: months}
+	}
+
+	// #kubernetes was used a lot last year, #kube-proxy a little this week
+	require.NoError(t, p.kvSetJSON(indexChannelKeyPrefix+"channel1", &channelIndex{
+		ChannelID: "channel1",
+		TeamID:    "team1",
+		Indexed:   true,
+		Tags: map[string]*tagStats{
+			"kubernetes": stats(at(300), map[string]int{indexMonth(at(300)): 12}),
+			"kube-proxy": stats(at(1), map[string]int{indexMonth(at(1)): 2}),
+			"helm":       stats(at(1), map[string]int{indexMonth(at(1)): 5}),
+		},
+	}))
+	require.NoError(t, p.kvSetJSON(indexChannelKeyPrefix+"channel2", &channelIndex{
+		ChannelID: "channel2",
+		TeamID:    "team1",
+		Tags: map[string]*tagStats{
+			"k8s":       stats(at(2), map[string]int{indexMonth(at(2)): 1}),
+			"kubectl":   stats(at(200), map[string]int{indexMonth(at(200)): 1}),
+			"kubeflow":  stats(at(100), map[string]int{indexMonth(at(100)): 1}),
+			"kubecon24": stats(at(5), map[string]int{indexMonth(at(5)): 1}),
+		},
+	}))
+	require.NoError(t, p.kvSetJSON(tagAliasesKey, map[string]string{"k8s": "kubernetes"}))
+	require.NoError(t, p.kvSetJSON(archivedTagsKey, map[string]int64{"kubeflow": at(50), "kubecon24": at(50)}))
+}
+
+func TestRecencyWeightedCount(t *testing.T) {
+	now := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	stats := &tagStats{
+		LastUsed: now.UnixMilli(),
+		Months:   map[string]int{"202406": 2, "202405": 4},
+	}
+	// The current month counts in full, May from its first day, 45 days ago
+	assert.InDelta(t, 2+4/(2*math.Sqrt2), recencyWeightedCount(stats, now), 1e-9)
+}
+
+func TestAutocompleteTags(t *testing.T) {
+	now := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	p := &Plugin{}
+	p.SetAPI(newTestAPI())
+	setupAutocompleteIndex(t, p, now)
+
+	suggestions, err := p.autocompleteTags([]string{"channel1", "channel2"}, "kub", now, 10)
+	require.NoError(t, err)
+	var tags []string
+	for _, s := range suggestions {
+		tags = append(tags, s.Tag)
+	}
+	// #kubernetes ranks on its recent alias rather than last year's posts;
+	// #kubeflow stays archived, #kubecon24 was used since it was archived
+	assert.Equal(t, []string{"kube-proxy", "kubernetes", "kubecon24", "kubectl"}, tags)
+	assert.Equal(t, 13, suggestions[1].Count)
+
+	suggestions, err = p.autocompleteTags([]string{"channel1", "channel2"}, "", now, 2)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 2)
+	assert.Equal(t, "helm", suggestions[0].Tag)
+
+	suggestions, err = p.autocompleteTags([]string{"channel2"}, "kub", now, 10)
+	require.NoError(t, err)
+	assert.Len(t, suggestions, 3)
+
+	// An alias being typed suggests its tag, even where only the tag is used
+	for _, channelIDs := range [][]string{{"channel1", "channel2"}, {"channel1"}} {
+		suggestions, err = p.autocompleteTags(channelIDs, "k8", now, 10)
+		require.NoError(t, err)
+		require.Len(t, suggestions, 1, channelIDs)
+		assert.Equal(t, "kubernetes", suggestions[0].Tag)
+		assert.Equal(t, "k8s", suggestions[0].Alias)
+	}
+}
+
+func TestAutocompleteTyped(t *testing.T) {
+	assert.Equal(t, "", autocompleteTyped("/hashtags"))
+	assert.Equal(t, "", autocompleteTyped("/hashtags stats "))
+	assert.Equal(t, "kub", autocompleteTyped("/hashtags stats kub"))
+	assert.Equal(t, "#Kub", autocompleteTyped("/hashtags follow #Kub"))
+}
+
+func TestHandleAutocomplete(t *testing.T) {
+	now := time.Now()
+	api := &autocompleteAPI{testAPI: newTestAPI()}
+	p := &Plugin{}
+	p.SetAPI(api)
+	setupAutocompleteIndex(t, p, now)
+
+	get := func(params url.Values) (int, []model.AutocompleteListItem) {
+		r := httptest.NewRequest(http.MethodGet, "/api/autocomplete?"+params.Encode(), nil)
+		r.Header.Set("Mattermost-User-ID", "user1")
+		w := httptest.NewRecorder()
+		p.handleAutocomplete(w, r)
+		var items []model.AutocompleteListItem
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+		}
+		return w.Code, items
+	}
+
+	code, items := get(url.Values{"user_input": {"/hashtags stats #Kube-"}, "team_id": {"team1"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, items, 1)
+	assert.Equal(t, "#Kube-proxy", items[0].Item)
+	assert.Equal(t, "Used in 2 posts", items[0].HelpText)
+
+	_, items = get(url.Values{"prefix": {"he"}, "team_id": {"team1"}})
+	require.Len(t, items, 1)
+	assert.Equal(t, "helm", items[0].Item)
+
+	// An alias completes as typed and names its tag
+	_, items = get(url.Values{"user_input": {"/hashtags stats #K8"}, "team_id": {"team1"}})
+	require.Len(t, items, 1)
+	assert.Equal(t, "#K8s", items[0].Item)
+	assert.Equal(t, "Alias of #kubernetes, used in 13 posts", items[0].HelpText)
+
+	code, _ = get(url.Values{"prefix": {"he"}, "team_id": {"team2"}})
+	assert.Equal(t, http.StatusForbidden, code)
+}
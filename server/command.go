@@ -0,0 +1,581 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	commandTrigger = "hashtags"
+
+	// commandListLimit is the number of rows the list commands show.
+	commandListLimit = 10
+)
+
+// commandHandler serves the /hashtags slash command. Every subcommand answers
+// with an ephemeral post.
+type commandHandler struct {
+	p *Plugin
+}
+
+// newCommandHandler registers /hashtags and its autocomplete.
+func newCommandHandler(p *Plugin) (*commandHandler, error) {
+	err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Explore the hashtags of your channels",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: commandAutocompleteData(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register command: %w", err)
+	}
+	return &commandHandler{p: p}, nil
+}
+
+func commandAutocompleteData() *model.AutocompleteData {
+	root := model.NewAutocompleteData(commandTrigger, "[command]", "Explore the hashtags of your channels")
+
+	top := model.NewAutocompleteData("top", "[channel|team] [--since 7d]", "List the most used tags of this channel or team")
+	top.AddStaticListArgument("Where to count tags", false, []model.AutocompleteListItem{
+		{Item: "channel", HelpText: "This channel"},
+		{Item: "team", HelpText: "The public channels of this team"},
+	})
+	top.AddNamedTextArgument("since", "Only count posts since, such as 12h, 7d or 4w", "7d", "", false)
+	root.AddCommand(top)
+
+	search := model.NewAutocompleteData("search", "<query>", "Find the latest posts matching a tag query")
+	search.AddTextArgument("Tag query such as kubernetes AND NOT helm", "<query>", "")
+	root.AddCommand(search)
+
+	stats := model.NewAutocompleteData("stats", "<tag>", "Show how much a tag is used")
+	stats.AddDynamicListArgument("Tag to show", autocompleteURL, true)
+	root.AddCommand(stats)
+
+	follow := model.NewAutocompleteData("follow", "<tag> [channel|team]", "Get a digest of new posts using a tag")
+	follow.AddDynamicListArgument("Tag to follow", autocompleteURL, true)
+	follow.AddStaticListArgument("Where to follow it, everywhere by default", false, []model.AutocompleteListItem{
+		{Item: "channel", HelpText: "Only in this channel"},
+		{Item: "team", HelpText: "Only in this team"},
+	})
+	root.AddCommand(follow)
+
+	unfollow := model.NewAutocompleteData("unfollow", "<tag> [channel|team]", "Stop following a tag")
+	unfollow.AddDynamicListArgument("Tag to stop following", autocompleteURL, true)
+	unfollow.AddStaticListArgument("Where it was followed, everywhere by default", false, []model.AutocompleteListItem{
+		{Item: "channel", HelpText: "In this channel"},
+		{Item: "team", HelpText: "In this team"},
+	})
+	root.AddCommand(unfollow)
+
+	related := model.NewAutocompleteData("related", "<tag>", "List the tags used together with a tag")
+	related.AddDynamicListArgument("Tag to find related tags for", autocompleteURL, true)
+	root.AddCommand(related)
+
+	experts := model.NewAutocompleteData("experts", "<tag>", "List the people who know most about a tag")
+	experts.AddDynamicListArgument("Tag to find experts for", autocompleteURL, true)
+	root.AddCommand(experts)
+
+	expertise := model.NewAutocompleteData("expertise", "[@username]", "List the tags someone knows most about")
+	expertise.AddTextArgument("User whose expertise to show, yourself by default", "[@username]", "")
+	root.AddCommand(expertise)
+
+	root.AddCommand(model.NewAutocompleteData("help", "", "Show the available commands"))
+	return root
+}
+
+const commandHelpText = "###### Hashtags commands\n" +
+	"* `/hashtags top [channel|team] [--since 7d]` - the most used tags of this channel or team\n" +
+	"* `/hashtags search <query>` - the latest posts matching a tag query, such as `kubernetes AND NOT helm`\n" +
+	"* `/hashtags stats <tag>` - how much a tag is used in this team\n" +
+	"* `/hashtags follow <tag> [channel|team]` - get a digest of new posts using a tag\n" +
+	"* `/hashtags unfollow <tag> [channel|team]` - stop following a tag\n" +
+	"* `/hashtags related <tag>` - the tags used together with a tag\n" +
+	"* `/hashtags experts <tag>` - the people who know most about a tag\n" +
+	"* `/hashtags expertise [@username]` - the tags someone knows most about\n" +
+	"* `/hashtags help` - this help"
+
+// Handle runs the subcommand named in args.
+func (c *commandHandler) Handle(args *model.CommandArgs) (*model.CommandResponse, error) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || strings.TrimPrefix(fields[0], "/") != commandTrigger {
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s", args.Command)), nil
+	}
+	if len(fields) < 2 {
+		return ephemeralResponse(commandHelpText), nil
+	}
+
+	switch fields[1] {
+	case "top":
+		return c.executeTop(args, fields[2:])
+	case "search":
+		return c.executeSearch(args, fields[2:])
+	case "stats":
+		return c.executeStats(args, fields[2:])
+	case "follow":
+		return c.executeFollow(args, fields[2:], true)
+	case "unfollow":
+		return c.executeFollow(args, fields[2:], false)
+	case "related":
+		return c.executeRelated(args, fields[2:])
+	case "experts":
+		return c.executeExperts(args, fields[2:])
+	case "expertise":
+		return c.executeExpertise(args, fields[2:])
+	case "help":
+		return ephemeralResponse(commandHelpText), nil
+	default:
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s\n\n%s", fields[1], commandHelpText)), nil
+	}
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+// markdownTable formats rows as a Markdown table under the given headers.
+func markdownTable(headers []string, rows [][]string) string {
+	escape := func(cell string) string {
+		return strings.ReplaceAll(cell, "|", "\\|")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| " + strings.Join(headers, " | ") + " |\n")
+	sb.WriteString("|" + strings.Repeat(" --- |", len(headers)) + "\n")
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escape(cell)
+		}
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	return sb.String()
+}
+
+// userLocation returns the timezone of the user running a command, in which
+// dates are shown.
+func (c *commandHandler) userLocation(userID string) *time.Location {
+	if user, appErr := c.p.API.GetUser(userID); appErr == nil {
+		return user.GetTimezoneLocation()
+	}
+	return time.UTC
+}
+
+// executeExperts answers /hashtags experts <tag> over the channels of the
+// current team the user belongs to.
+func (c *commandHandler) executeExperts(args *model.CommandArgs, params []string) (*model.CommandResponse, error) {
+	if len(params) != 1 || normalizeTagQuery(params[0]) == "" {
+		return ephemeralResponse("Usage: `/hashtags experts <tag>`"), nil
+	}
+	tag := normalizeTagQuery(params[0])
+
+	channelIDs, err := c.p.visibleChannelIDs(args.UserId, args.TeamId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	window := timeRange{Since: now.Add(-defaultExpertiseRange).UnixMilli()}
+	response, err := c.p.tagExperts(tag, channelIDs, window, now, defaultExpertsLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Experts) == 0 {
+		return ephemeralResponse(fmt.Sprintf("Nobody has written about #%s in your channels yet.", response.Tag)), nil
+	}
+
+	loc := c.userLocation(args.UserId)
+	rows := make([][]string, 0, len(response.Experts))
+	for i, expert := range response.Experts {
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i+1),
+			"@" + expert.Username,
+			fmt.Sprintf("%.1f", expert.Score),
+			fmt.Sprintf("%d", expert.Posts),
+			time.UnixMilli(expert.LastUsed).In(loc).Format("2006-01-02"),
+		})
+	}
+	text := fmt.Sprintf("#### Who knows about #%s\n", response.Tag) +
+		markdownTable([]string{"#", "User", "Score", "Posts", "Last used"}, rows)
+	return ephemeralResponse(text), nil
+}
+
+// executeExpertise answers /hashtags expertise [@username] over the channels
+// of the current team the user belongs to.
+func (c *commandHandler) executeExpertise(args *model.CommandArgs, params []string) (*model.CommandResponse, error) {
+	if len(params) > 1 {
+		return ephemeralResponse("Usage: `/hashtags expertise [@username]`"), nil
+	}
+	userID := args.UserId
+	if len(params) == 1 {
+		user, appErr := c.p.API.GetUserByUsername(strings.TrimPrefix(params[0], "@"))
+		if appErr != nil {
+			return ephemeralResponse(fmt.Sprintf("User %s not found.", params[0])), nil
+		}
+		userID = user.Id
+	}
+	user, appErr := c.p.API.GetUser(userID)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get user: %w", appErr)
+	}
+
+	channelIDs, err := c.p.visibleChannelIDs(args.UserId, args.TeamId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	window := timeRange{Since: now.Add(-defaultExpertiseRange).UnixMilli()}
+	tags, err := c.p.userExpertise(userID, channelIDs, window, now, defaultExpertsLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return ephemeralResponse(fmt.Sprintf("@%s has not used any hashtags in your channels in the past year.", user.Username)), nil
+	}
+
+	loc := c.userLocation(args.UserId)
+	rows := make([][]string, 0, len(tags))
+	for i, tag := range tags {
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i+1),
+			"#" + tag.Tag,
+			fmt.Sprintf("%.1f", tag.Score),
+			fmt.Sprintf("%d", tag.Posts),
+			time.UnixMilli(tag.LastUsed).In(loc).Format("2006-01-02"),
+		})
+	}
+	text := fmt.Sprintf("#### What @%s knows about\n", user.Username) +
+		markdownTable([]string{"#", "Tag", "Score", "Posts", "Last used"}, rows)
+	return ephemeralResponse(text), nil
+}
+
+// parseCommandSince takes --since out of the parameters of a command. It
+// accepts the relative times of the API's since parameter, such as 7d.
+func parseCommandSince(params []string, now time.Time) ([]string, timeRange, error) {
+	var rest []string
+	var window timeRange
+	for i := 0; i < len(params); i++ {
+		value, ok := strings.CutPrefix(params[i], "--since=")
+		if !ok {
+			if params[i] != "--since" {
+				rest = append(rest, params[i])
+				continue
+			}
+			if i+1 == len(params) {
+				return nil, timeRange{}, errors.New("--since needs a value such as 7d")
+			}
+			i++
+			value = params[i]
+		}
+		since, err := parseTimeParam(value, now)
+		if err != nil {
+			return nil, timeRange{}, err
+		}
+		window.Since = since
+	}
+	return rest, window, nil
+}
+
+// executeTop answers /hashtags top [channel|team] [--since 7d] from the same
+// counts as the hashtag sidebar.
+func (c *commandHandler) executeTop(args *model.CommandArgs, params []string) (*model.CommandResponse, error) {
+	const usage = "Usage: `/hashtags top [channel|team] [--since 7d]`"
+	now := time.Now()
+	params, window, err := parseCommandSince(params, now)
+	if err != nil {
+		return ephemeralResponse(fmt.Sprintf("%s\n\n%s", err.Error(), usage)), nil
+	}
+	scope := "channel"
+	if len(params) == 1 {
+		scope = params[0]
+	}
+	if len(params) > 1 || (scope != "channel" && scope != "team") {
+		return ephemeralResponse(usage), nil
+	}
+
+	var hashtags []HashtagCount
+	where := "this channel"
+	if scope == "team" {
+		where = "this team"
+		hashtags, err = c.p.computeTeamHashtags(args.TeamId, 1000, window)
+	} else {
+		hashtags, err = c.p.computeHashtags(args.ChannelId, 5000, window)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if window.Since > 0 {
+		where += " since " + time.UnixMilli(window.Since).In(c.userLocation(args.UserId)).Format("2006-01-02")
+	}
+	if len(hashtags) == 0 {
+		return ephemeralResponse(fmt.Sprintf("No hashtags used in %s.", where)), nil
+	}
+
+	loc := c.userLocation(args.UserId)
+	rows := make([][]string, 0, commandListLimit)
+	for i, tag := range hashtags[:min(len(hashtags), commandListLimit)] {
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i+1),
+			"#" + tag.Display,
+			fmt.Sprintf("%d", tag.Count),
+			time.UnixMilli(tag.LastUsed).In(loc).Format("2006-01-02"),
+		})
+	}
+	text := fmt.Sprintf("#### Top hashtags in %s\n", where) +
+		markdownTable([]string{"#", "Tag", "Posts", "Last used"}, rows)
+	return ephemeralResponse(text), nil
+}
+
+// executeSearch answers /hashtags search <query> with the latest matching
+// posts in the channels the user belongs to.
+func (c *commandHandler) executeSearch(args *model.CommandArgs, params []string) (*model.CommandResponse, error) {
+	query := strings.Join(params, " ")
+	if query == "" {
+		return ephemeralResponse("Usage: `/hashtags search <query>`"), nil
+	}
+	aliases, err := c.p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	matcher, qerr := parseTagQuery(query, aliases)
+	if qerr != nil {
+		return ephemeralResponse(fmt.Sprintf("Invalid query: %s", qerr.Error())), nil
+	}
+	channelIDs, err := c.p.tagSearchChannelIDs(args.UserId, "")
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]*model.User{}
+	page, err := c.p.pageTagPosts(matcher, channelIDs, timeRange{}, nil, 0, commandListLimit, users)
+	if err != nil {
+		return nil, err
+	}
+	posts := c.p.hashtagPosts(page.Entries, matcher, users)
+	if len(posts) == 0 {
+		return ephemeralResponse(fmt.Sprintf("No posts match `%s` in your channels.", query)), nil
+	}
+
+	loc := c.userLocation(args.UserId)
+	channels := map[string]string{}
+	rows := make([][]string, 0, len(posts))
+	for _, post := range posts {
+		name, ok := channels[post.ChannelID]
+		if !ok {
+			if channel, appErr := c.p.API.GetChannel(post.ChannelID); appErr == nil {
+				name = "~" + channel.Name
+			}
+			channels[post.ChannelID] = name
+		}
+		rows = append(rows, []string{
+			time.UnixMilli(post.CreateAt).In(loc).Format("2006-01-02"),
+			"@" + post.Username,
+			name,
+			fmt.Sprintf("[%s](%s)", postExcerpt(post.Message), c.p.permalink(post.ID)),
+		})
+	}
+	text := fmt.Sprintf("#### Posts matching `%s`\n", query) +
+		markdownTable([]string{"Date", "Author", "Channel", "Post"}, rows)
+	if page.Total > len(posts) {
+		text += fmt.Sprintf("\nShowing the latest %d of %d posts.", len(posts), page.Total)
+	}
+	return ephemeralResponse(text), nil
+}
+
+// tagSummary sums up the posts using a tag.
+type tagSummary struct {
+	Posts      int
+	Channels   int
+	Authors    int
+	FirstUsed  int64
+	LastUsed   int64
+	PastWeek   int
+	PastMonth  int
+	TopAuthors []string
+}
+
+// summarizeTagPosts computes the stats of a tag from the index entries of its
+// posts, without loading them. TopAuthors lists the IDs of up to three authors
+// with the most posts.
+func summarizeTagPosts(entries []indexedPost, now time.Time) tagSummary {
+	stats := tagSummary{Posts: len(entries)}
+	week := now.AddDate(0, 0, -7).UnixMilli()
+	month := now.AddDate(0, 0, -30).UnixMilli()
+	channels := map[string]bool{}
+	authors := map[string]int{}
+	for _, e := range entries {
+		channels[e.ChannelID] = true
+		authors[e.UserID]++
+		if stats.FirstUsed == 0 || e.CreateAt < stats.FirstUsed {
+			stats.FirstUsed = e.CreateAt
+		}
+		stats.LastUsed = max(stats.LastUsed, e.CreateAt)
+		if e.CreateAt >= week {
+			stats.PastWeek++
+		}
+		if e.CreateAt >= month {
+			stats.PastMonth++
+		}
+	}
+	stats.Channels = len(channels)
+	stats.Authors = len(authors)
+
+	for userID := range authors {
+		stats.TopAuthors = append(stats.TopAuthors, userID)
+	}
+	sort.Slice(stats.TopAuthors, func(i, j int) bool {
+		a, b := stats.TopAuthors[i], stats.TopAuthors[j]
+		if authors[a] != authors[b] {
+			return authors[a] > authors[b]
+		}
+		return a < b
+	})
+	if len(stats.TopAuthors) > 3 {
+		stats.TopAuthors = stats.TopAuthors[:3]
+	}
+	return stats
+}
+
+// executeStats answers /hashtags stats <tag> over the channels of the current
+// team the user belongs to.
+func (c *commandHandler) executeStats(args *model.CommandArgs, params []string) (*model.CommandResponse, error) {
+	if len(params) != 1 || normalizeTagQuery(params[0]) == "" {
+		return ephemeralResponse("Usage: `/hashtags stats <tag>`"), nil
+	}
+	tag := normalizeTagQuery(params[0])
+
+	channelIDs, err := c.p.visibleChannelIDs(args.UserId, args.TeamId)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := c.p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]*model.User{}
+	page, err := c.p.pageTagPosts(tagAnyOf(expandTag(tag, aliases)), channelIDs, timeRange{}, nil, 0, 0, users)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Entries) == 0 {
+		return ephemeralResponse(fmt.Sprintf("Nobody has used #%s in your channels yet.", tag)), nil
+	}
+
+	stats := summarizeTagPosts(page.Entries, time.Now())
+	loc := c.userLocation(args.UserId)
+	topAuthors := make([]string, 0, len(stats.TopAuthors))
+	for _, userID := range stats.TopAuthors {
+		if user := c.p.cachedUser(users, userID); user != nil {
+			topAuthors = append(topAuthors, "@"+user.Username)
+		}
+	}
+	rows := [][]string{
+		{"Posts", fmt.Sprintf("%d", stats.Posts)},
+		{"Past 7 days", fmt.Sprintf("%d", stats.PastWeek)},
+		{"Past 30 days", fmt.Sprintf("%d", stats.PastMonth)},
+		{"Channels", fmt.Sprintf("%d", stats.Channels)},
+		{"Authors", fmt.Sprintf("%d", stats.Authors)},
+		{"Top authors", strings.Join(topAuthors, ", ")},
+		{"First used", time.UnixMilli(stats.FirstUsed).In(loc).Format("2006-01-02")},
+		{"Last used", time.UnixMilli(stats.LastUsed).In(loc).Format("2006-01-02")},
+	}
+	if keys := expandTag(tag, aliases); len(keys) > 1 {
+		rows = append(rows, []string{"Aliases", "#" + strings.Join(keys[1:], ", #")})
+	}
+	text := fmt.Sprintf("#### #%s\n", tag) + markdownTable([]string{"Stat", "Value"}, rows)
+	return ephemeralResponse(text), nil
+}
+
+// executeFollow answers /hashtags follow|unfollow <tag> [channel|team], for
+// the current channel or team when a scope is given and everywhere otherwise.
+func (c *commandHandler) executeFollow(args *model.CommandArgs, params []string, follow bool) (*model.CommandResponse, error) {
+	verb := "follow"
+	if !follow {
+		verb = "unfollow"
+	}
+	if len(params) < 1 || len(params) > 2 {
+		return ephemeralResponse(fmt.Sprintf("Usage: `/hashtags %s <tag> [channel|team]`", verb)), nil
+	}
+	request := FollowRequest{Tag: params[0], ChannelID: args.ChannelId, TeamID: args.TeamId}
+	if len(params) == 2 {
+		request.Scope = params[1]
+	}
+	f, err := parseTagFollow(args.UserId, request)
+	if err != nil {
+		return ephemeralResponse(fmt.Sprintf("%s\n\nUsage: `/hashtags %s <tag> [channel|team]`", err.Error(), verb)), nil
+	}
+	where := "everywhere"
+	if f.Scope != followScopeGlobal {
+		where = "in this " + f.Scope
+	}
+
+	if !follow {
+		removed, err := c.p.unfollowTag(f)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			return ephemeralResponse(fmt.Sprintf("You are not following #%s %s.", f.Tag, where)), nil
+		}
+		return ephemeralResponse(fmt.Sprintf("You no longer follow #%s %s.", f.Tag, where)), nil
+	}
+
+	if !c.p.canFollow(f) {
+		return ephemeralResponse(fmt.Sprintf("You cannot follow #%s %s.", f.Tag, where)), nil
+	}
+	f.CreateAt = model.GetMillis()
+	if err := c.p.followTag(f); err != nil {
+		return nil, err
+	}
+	return ephemeralResponse(fmt.Sprintf("You now follow #%s %s. New posts using it will be sent to you in a digest every few minutes.", f.Tag, where)), nil
+}
+
+// executeRelated answers /hashtags related <tag> over the channels of the
+// current team the user belongs to.
+func (c *commandHandler) executeRelated(args *model.CommandArgs, params []string) (*model.CommandResponse, error) {
+	if len(params) != 1 || normalizeTagQuery(params[0]) == "" {
+		return ephemeralResponse("Usage: `/hashtags related <tag>`"), nil
+	}
+	channelIDs, err := c.p.visibleChannelIDs(args.UserId, args.TeamId)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.p.relatedTags(params[0], channelIDs, timeRange{}, defaultRelatedMinCount, commandListLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Related) == 0 {
+		return ephemeralResponse(fmt.Sprintf("No tags are used together with #%s often enough yet.", response.Tag)), nil
+	}
+
+	rows := make([][]string, 0, len(response.Related))
+	for i, related := range response.Related {
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i+1),
+			"#" + related.Tag,
+			fmt.Sprintf("%d", related.Count),
+			fmt.Sprintf("%.1f", related.Lift),
+		})
+	}
+	text := fmt.Sprintf("#### Tags used with #%s\n", response.Tag) +
+		markdownTable([]string{"#", "Tag", "Posts together", "Lift"}, rows)
+	return ephemeralResponse(text), nil
+}
+
+// ExecuteCommand runs the /hashtags slash command.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	response, err := p.commandHandler.Handle(args)
+	if err != nil {
+		p.API.LogError("Failed to execute command", "error", err.Error(), "command", args.Command)
+		return nil, model.NewAppError("ExecuteCommand", "plugin.command.execute_command.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return response, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommandSince(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	rest, window, err := parseCommandSince([]string{"team", "--since", "7d"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team"}, rest)
+	assert.Equal(t, now.AddDate(0, 0, -7).UnixMilli(), window.Since)
+
+	rest, window, err = parseCommandSince([]string{"--since=12h"}, now)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, now.Add(-12*time.Hour).UnixMilli(), window.Since)
+
+	_, _, err = parseCommandSince([]string{"--since"}, now)
+	assert.Error(t, err)
+	_, _, err = parseCommandSince([]string{"--since", "soon"}, now)
+	assert.Error(t, err)
+}
+
+func TestSummarizeTagPosts(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) int64 { return now.AddDate(0, 0, -n).UnixMilli() }
+
+	stats := summarizeTagPosts([]indexedPost{
+		{UserID: "bob", ChannelID: "channel1", CreateAt: daysAgo(1)},
+		{UserID: "alice", ChannelID: "channel2", CreateAt: daysAgo(10)},
+		{UserID: "bob", ChannelID: "channel1", CreateAt: daysAgo(40)},
+		{UserID: "carol", ChannelID: "channel1", CreateAt: daysAgo(50)},
+		{UserID: "dave", ChannelID: "channel1", CreateAt: daysAgo(60)},
+	}, now)
+	assert.Equal(t, tagSummary{
+		Posts:      5,
+		Channels:   2,
+		Authors:    4,
+		FirstUsed:  daysAgo(60),
+		LastUsed:   daysAgo(1),
+		PastWeek:   1,
+		PastMonth:  2,
+		TopAuthors: []string{"bob", "alice", "carol"},
+	}, stats)
+}
+
+func TestCommandTop(t *testing.T) {
+	api := newTestAPI()
+	api.corpusAPI = newMessagesAPI([]string{"#kubernetes upgrade", "#Kubernetes and #helm", "#kubernetes"})
+	p := &Plugin{}
+	p.SetAPI(api)
+	c := &commandHandler{p: p}
+
+	response, err := c.Handle(&model.CommandArgs{Command: "/hashtags top", UserId: "user1", ChannelId: "channel1", TeamId: "team1"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "#### Top hashtags in this channel\n")
+	assert.Contains(t, response.Text, "| 1 | #kubernetes | 3 |")
+	assert.Contains(t, response.Text, "| 2 | #helm | 1 |")
+
+	response, err = c.Handle(&model.CommandArgs{Command: "/hashtags top team --since", UserId: "user1", TeamId: "team1"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "Usage")
+	response, err = c.Handle(&model.CommandArgs{Command: "/hashtags top everywhere", UserId: "user1"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "Usage")
+}
+
+func TestCommandSearchStats(t *testing.T) {
+	messages := []string{"#helm chart"}
+	for i := 0; i < commandListLimit+2; i++ {
+		messages = append(messages, "#kubernetes upgrade")
+	}
+	api := newTestAPI()
+	api.corpusAPI = newMessagesAPI(messages)
+	p := &Plugin{}
+	p.SetAPI(api)
+	c := &commandHandler{p: p}
+	args := func(command string) *model.CommandArgs {
+		return &model.CommandArgs{Command: command, UserId: "user2", ChannelId: "channel1", TeamId: "team1"}
+	}
+
+	response, err := c.Handle(args("/hashtags search kubernetes"))
+	require.NoError(t, err)
+	assert.Equal(t, commandListLimit, strings.Count(response.Text, "@user1"))
+	assert.Contains(t, response.Text, fmt.Sprintf("Showing the latest %d of %d posts.", commandListLimit, commandListLimit+2))
+
+	response, err = c.Handle(args("/hashtags search helm"))
+	require.NoError(t, err)
+	assert.NotContains(t, response.Text, "Showing the latest")
+
+	response, err = c.Handle(args("/hashtags stats kubernetes"))
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, fmt.Sprintf("| Posts | %d |", commandListLimit+2))
+	assert.Contains(t, response.Text, "| Top authors | @user1 |")
+}
+
+func TestCommandFollow(t *testing.T) {
+	api := newTestAPI()
+	api.outsiders["dave"] = true
+	p := &Plugin{}
+	p.SetAPI(api)
+	c := &commandHandler{p: p}
+	args := func(command, userID string) *model.CommandArgs {
+		return &model.CommandArgs{Command: command, UserId: userID, ChannelId: "channel1", TeamId: "team1"}
+	}
+
+	response, err := c.Handle(args("/hashtags follow #Incident channel", "alice"))
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "You now follow #incident in this channel.")
+	follows, err := p.getUserFollows("alice")
+	require.NoError(t, err)
+	require.Len(t, follows, 1)
+	assert.Equal(t, "channel1", follows[0].ScopeID)
+
+	response, err = c.Handle(args("/hashtags follow incident channel", "dave"))
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "You cannot follow")
+
+	response, err = c.Handle(args("/hashtags unfollow incident", "alice"))
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "You are not following #incident everywhere.")
+	response, err = c.Handle(args("/hashtags unfollow incident channel", "alice"))
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "You no longer follow #incident in this channel.")
+
+	response, err = c.Handle(args("/hashtags follow incident somewhere", "alice"))
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "Usage")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// configuration captures the plugin's external configuration as exposed in the Mattermost server
+// configuration. Any public fields will be deserialized from the Mattermost server configuration
+// in OnConfigurationChange.
+//
+// As plugins are inherently concurrent (hooks being called asynchronously), and the plugin
+// configuration can change at any time, access to the configuration must be synchronized. The
+// strategy used in this plugin is to guard a pointer to the configuration, and clone the entire
+// struct whenever it changes.
+type configuration struct {
+	// CountTagsInQuotes makes tags inside block quotes count like any other tag.
+	CountTagsInQuotes bool
+
+	// TagTreeSeparators are the characters that split a tag into the levels of
+	// the tag tree, such as - and / in #proj/web-auth.
+	TagTreeSeparators string
+
+	// TrendingWindowDays is the window trending scores compare, and
+	// TrendingMinPosts the posts a tag needs in it to trend.
+	TrendingWindowDays int
+	TrendingMinPosts   int
+}
+
+// Clone shallow copies the configuration.
+func (c *configuration) Clone() *configuration {
+	var clone = *c
+	return &clone
+}
+
+// extractOptions returns the parts of tag extraction that depend on configuration.
+func (c *configuration) extractOptions() extractOptions {
+	return extractOptions{includeQuotes: c.CountTagsInQuotes}
+}
+
+// tagTreeSeparators returns the configured tag tree separators, falling back
+// to defaultTagTreeSeparators.
+func (c *configuration) tagTreeSeparators() string {
+	if c.TagTreeSeparators == "" {
+		return defaultTagTreeSeparators
+	}
+	return c.TagTreeSeparators
+}
+
+// getConfiguration retrieves the active configuration under lock, making it safe to use
+// concurrently. The active configuration may change underneath the client of this method, but
+// the struct returned by this API call is considered immutable.
+func (p *Plugin) getConfiguration() *configuration {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	if p.configuration == nil {
+		return &configuration{}
+	}
+
+	return p.configuration
+}
+
+// setConfiguration replaces the active configuration under lock.
+//
+// Do not call setConfiguration while holding the configurationLock, as sync.Mutex is not
+// reentrant.
+func (p *Plugin) setConfiguration(configuration *configuration) {
+	p.configurationLock.Lock()
+	defer p.configurationLock.Unlock()
+
+	if configuration != nil && p.configuration == configuration {
+		// Ignore assignment if the configuration struct is empty. Go will optimize the
+		// allocation for same to point at the same memory address, breaking the check
+		// above.
+		if reflect.ValueOf(*configuration).NumField() == 0 {
+			return
+		}
+
+		panic("setConfiguration called with the existing configuration")
+	}
+
+	p.configuration = configuration
+}
+
+// OnConfigurationChange is invoked when configuration changes may have been made.
+func (p *Plugin) OnConfigurationChange() error {
+	var configuration = new(configuration)
+
+	// Load the public configuration fields from the Mattermost server configuration.
+	if err := p.API.LoadPluginConfiguration(configuration); err != nil {
+		return fmt.Errorf("failed to load plugin configuration: %w", err)
+	}
+
+	previous := p.getConfiguration()
+	p.setConfiguration(configuration)
+
+	if p.backfillJob != nil && previous.extractOptions() != configuration.extractOptions() {
+		p.API.LogInfo("Hashtag extraction settings changed, the backfill job will re-index every channel")
+	}
+
+	return nil
+}
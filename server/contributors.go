@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultContributorsLimit = 10
+	maxContributorsLimit     = 100
+)
+
+// TagContributor is an author of posts using a tag. Share is the fraction of
+// the tag's posts written by them.
+type TagContributor struct {
+	UserID    string  `json:"user_id"`
+	Username  string  `json:"username"`
+	Posts     int     `json:"posts"`
+	FirstUsed int64   `json:"first_used"`
+	LastUsed  int64   `json:"last_used"`
+	Share     float64 `json:"share"`
+}
+
+// TagContributorsResponse lists the top authors of a tag. Total is the number
+// of posts using it and Authors the number of distinct authors.
+type TagContributorsResponse struct {
+	Tag          string           `json:"tag"`
+	Total        int              `json:"total"`
+	Authors      int              `json:"authors"`
+	Contributors []TagContributor `json:"contributors"`
+}
+
+// tagContributors ranks the authors of the posts using a tag over a set of
+// channels and a time range. Bot posts are never indexed, so bots are never
+// listed.
+func (p *Plugin) tagContributors(tag string, channelIDs []string, window timeRange, limit int) (*TagContributorsResponse, error) {
+	tag = normalizeTagQuery(tag)
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	keys := tagAnyOf(expandTag(tag, aliases))
+
+	users := map[string]*model.User{}
+	page, err := p.pageTagPosts(keys, channelIDs, window, nil, 0, 0, users)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := map[string]*TagContributor{}
+	for _, e := range page.Entries {
+		c, ok := byUser[e.UserID]
+		if !ok {
+			c = &TagContributor{UserID: e.UserID, FirstUsed: e.CreateAt}
+			byUser[e.UserID] = c
+		}
+		c.Posts++
+		if e.CreateAt < c.FirstUsed {
+			c.FirstUsed = e.CreateAt
+		}
+		if e.CreateAt > c.LastUsed {
+			c.LastUsed = e.CreateAt
+		}
+	}
+
+	contributors := make([]TagContributor, 0, len(byUser))
+	for _, c := range byUser {
+		c.Share = float64(c.Posts) / float64(len(page.Entries))
+		contributors = append(contributors, *c)
+	}
+	sort.Slice(contributors, func(i, j int) bool {
+		if contributors[i].Posts != contributors[j].Posts {
+			return contributors[i].Posts > contributors[j].Posts
+		}
+		if contributors[i].LastUsed != contributors[j].LastUsed {
+			return contributors[i].LastUsed > contributors[j].LastUsed
+		}
+		return contributors[i].UserID < contributors[j].UserID
+	})
+	if limit > 0 && len(contributors) > limit {
+		contributors = contributors[:limit]
+	}
+	for i := range contributors {
+		if user := p.cachedUser(users, contributors[i].UserID); user != nil {
+			contributors[i].Username = user.Username
+		}
+	}
+
+	return &TagContributorsResponse{
+		Tag:          keys[0],
+		Total:        len(page.Entries),
+		Authors:      len(byUser),
+		Contributors: contributors,
+	}, nil
+}
+
+// GET /api/contributors?tag=XXX&scope=channel&channel_id=YYY&limit=10
+// GET /api/contributors?tag=XXX&scope=team&team_id=YYY&since=90d
+func (p *Plugin) handleTagContributors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tag := normalizeTagQuery(query.Get("tag"))
+	if tag == "" {
+		http.Error(w, "tag is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultContributorsLimit
+	if s := query.Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= maxContributorsLimit {
+			limit = n
+		}
+	}
+	window, err := parseTimeRange(query, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	channelIDs, status, err := p.scopeChannelIDs(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	response, err := p.tagContributors(tag, channelIDs, window, limit)
+	if err != nil {
+		p.API.LogError("Failed to compute tag contributors", "error", err.Error(), "tag", tag)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagContributors(t *testing.T) {
+	api := newMessagesAPI(nil)
+	add := func(userID, message string, createAt int64) {
+		post := &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: userID, CreateAt: createAt, Message: message}
+		api.posts[post.Id] = post
+		api.order = append([]string{post.Id}, api.order...)
+	}
+	add("alice", "#release notes", 100)
+	add("bob", "#Release is out", 200)
+	add("alice", "#release again", 300)
+	add("alice", "#k8s and #release", 400)
+	add("carol", "#other", 500)
+	add("bot1", "#release", 600)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	result, err := p.tagContributors("#release", []string{"channel1"}, timeRange{}, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, "release", result.Tag)
+	assert.Equal(t, 4, result.Total)
+	assert.Equal(t, 2, result.Authors)
+	assert.Equal(t, []TagContributor{
+		{UserID: "alice", Username: "alice", Posts: 3, FirstUsed: 100, LastUsed: 400, Share: 0.75},
+		{UserID: "bob", Username: "bob", Posts: 1, FirstUsed: 200, LastUsed: 200, Share: 0.25},
+	}, result.Contributors)
+
+	result, err = p.tagContributors("release", []string{"channel1"}, timeRange{Since: 250}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Total)
+	require.Len(t, result.Contributors, 1)
+	assert.Equal(t, 1.0, result.Contributors[0].Share)
+}
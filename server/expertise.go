@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultExpertsLimit = 10
+	maxExpertsLimit     = 100
+
+	// expertiseHalfLife is the age at which a post counts half as much
+	// towards its author's expertise as a post written now.
+	expertiseHalfLife = 90 * 24 * time.Hour
+
+	// defaultExpertiseRange is how far back experts and a user's profile go
+	// without since. Older posts weigh little after the recency decay anyway.
+	defaultExpertiseRange = 365 * 24 * time.Hour
+)
+
+// TagExpert is a user who writes about a tag. Score sums the weight of their
+// posts using it, see expertiseWeight.
+type TagExpert struct {
+	UserID   string  `json:"user_id"`
+	Username string  `json:"username"`
+	Score    float64 `json:"score"`
+	Posts    int     `json:"posts"`
+	LastUsed int64   `json:"last_used"`
+}
+
+type TagExpertsResponse struct {
+	Tag     string      `json:"tag"`
+	Experts []TagExpert `json:"experts"`
+}
+
+// ExpertiseTag is one tag of a user's expertise profile.
+type ExpertiseTag struct {
+	Tag      string  `json:"tag"`
+	Score    float64 `json:"score"`
+	Posts    int     `json:"posts"`
+	LastUsed int64   `json:"last_used"`
+}
+
+type UserExpertiseResponse struct {
+	UserID   string         `json:"user_id"`
+	Username string         `json:"username"`
+	Tags     []ExpertiseTag `json:"tags"`
+}
+
+// expertiseWeight is what a post counts towards its author's expertise in
+// each of its tags. It halves every expertiseHalfLife, and grows with the
+// logarithm of the reactions and replies the post received so a popular post
+// counts more than a plain one without drowning everything else.
+func expertiseWeight(e indexedPost, now int64) float64 {
+	age := math.Max(float64(now-e.CreateAt), 0) / float64(expertiseHalfLife.Milliseconds())
+	return math.Exp2(-age) * (1 + math.Log2(1+float64(e.Reactions+e.Replies)))
+}
+
+// expertiseScore accumulates the weight of posts under one key, a user or a tag.
+type expertiseScore struct {
+	score    float64
+	posts    int
+	lastUsed int64
+}
+
+func (s *expertiseScore) add(e indexedPost, now int64) {
+	s.score += expertiseWeight(e, now)
+	s.posts++
+	if e.CreateAt > s.lastUsed {
+		s.lastUsed = e.CreateAt
+	}
+}
+
+// rankExpertise returns the keys of scores by decreasing score.
+func rankExpertise(scores map[string]*expertiseScore) []string {
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := scores[keys[i]], scores[keys[j]]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.posts != b.posts {
+			return a.posts > b.posts
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// visibleChannelIDs returns the channels whose posts an expertise query by
+// userID may count: those of one team the user belongs to, or all of their
+// channels without a team.
+func (p *Plugin) visibleChannelIDs(userID, teamID string) ([]string, error) {
+	if teamID == "" {
+		return p.userChannelIDs(userID)
+	}
+	channels, appErr := p.API.GetChannelsForTeamForUser(teamID, userID, false)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", appErr)
+	}
+	channelIDs := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		channelIDs = append(channelIDs, channel.Id)
+	}
+	return channelIDs, nil
+}
+
+// tagExperts ranks the users who write about a tag in a set of channels.
+// Deactivated users are left out since nobody can ask them anymore.
+func (p *Plugin) tagExperts(tag string, channelIDs []string, window timeRange, now time.Time, limit int) (*TagExpertsResponse, error) {
+	tag = normalizeTagQuery(tag)
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	keys := tagAnyOf(expandTag(tag, aliases))
+
+	users := map[string]*model.User{}
+	page, err := p.pageTagPosts(keys, channelIDs, window, nil, 0, 0, users)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := map[string]*expertiseScore{}
+	for _, e := range page.Entries {
+		if scores[e.UserID] == nil {
+			scores[e.UserID] = &expertiseScore{}
+		}
+		scores[e.UserID].add(e, now.UnixMilli())
+	}
+
+	experts := []TagExpert{}
+	for _, userID := range rankExpertise(scores) {
+		user := p.cachedUser(users, userID)
+		if user == nil || user.DeleteAt != 0 {
+			continue
+		}
+		s := scores[userID]
+		experts = append(experts, TagExpert{
+			UserID:   userID,
+			Username: user.Username,
+			Score:    s.score,
+			Posts:    s.posts,
+			LastUsed: s.lastUsed,
+		})
+		if len(experts) == limit {
+			break
+		}
+	}
+
+	return &TagExpertsResponse{Tag: keys[0], Experts: experts}, nil
+}
+
+// userExpertise ranks the tags a user writes about in a set of channels.
+// Aliases are counted under their canonical tag.
+func (p *Plugin) userExpertise(userID string, channelIDs []string, window timeRange, now time.Time, limit int) ([]ExpertiseTag, error) {
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	scores := map[string]*expertiseScore{}
+	users := map[string]*model.User{}
+	for _, channelID := range channelIDs {
+		err := p.walkChannelEntries(channelID, window, users, func(e indexedPost) bool {
+			if e.UserID != userID {
+				return true
+			}
+			seen := map[string]bool{}
+			for _, t := range e.Tags {
+				key := resolveTag(canonicalTag(t), aliases)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if scores[key] == nil {
+					scores[key] = &expertiseScore{}
+				}
+				scores[key].add(e, now.UnixMilli())
+			}
+			return true
+		})
+		if err != nil {
+			if len(channelIDs) == 1 {
+				return nil, err
+			}
+			p.API.LogError("Failed to compute expertise for channel", "error", err.Error(), "channel_id", channelID)
+		}
+	}
+
+	tags := []ExpertiseTag{}
+	for _, key := range rankExpertise(scores) {
+		s := scores[key]
+		tags = append(tags, ExpertiseTag{Tag: key, Score: s.score, Posts: s.posts, LastUsed: s.lastUsed})
+		if len(tags) == limit {
+			break
+		}
+	}
+	return tags, nil
+}
+
+// expertsLimit reads the limit parameter of an expertise request.
+func expertsLimit(r *http.Request) int {
+	limit := defaultExpertsLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= maxExpertsLimit {
+			limit = n
+		}
+	}
+	return limit
+}
+
+// GET /api/experts?tag=XXX&team_id=YYY&limit=10&since=180d
+func (p *Plugin) handleTagExperts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tag := normalizeTagQuery(query.Get("tag"))
+	if tag == "" {
+		http.Error(w, "tag is required", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+	teamID := query.Get("team_id")
+	if teamID != "" && !p.API.HasPermissionToTeam(userID, teamID, model.PermissionViewTeam) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	now := time.Now()
+	window, err := parseTimeRange(query, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if window.Since == 0 {
+		window.Since = now.Add(-defaultExpertiseRange).UnixMilli()
+	}
+
+	channelIDs, err := p.visibleChannelIDs(userID, teamID)
+	if err != nil {
+		p.API.LogError("Failed to get channels for experts", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := p.tagExperts(tag, channelIDs, window, now, expertsLimit(r))
+	if err != nil {
+		p.API.LogError("Failed to compute tag experts", "error", err.Error(), "tag", tag)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
+
+// GET /api/expertise?user_id=XXX&team_id=YYY&limit=10
+// GET /api/expertise?username=XXX&since=90d
+func (p *Plugin) handleUserExpertise(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	requesterID := r.Header.Get("Mattermost-User-ID")
+	teamID := query.Get("team_id")
+	if teamID != "" && !p.API.HasPermissionToTeam(requesterID, teamID, model.PermissionViewTeam) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var user *model.User
+	var appErr *model.AppError
+	switch {
+	case query.Get("user_id") != "":
+		user, appErr = p.API.GetUser(query.Get("user_id"))
+	case query.Get("username") != "":
+		user, appErr = p.API.GetUserByUsername(query.Get("username"))
+	default:
+		user, appErr = p.API.GetUser(requesterID)
+	}
+	if appErr != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	window, err := parseTimeRange(query, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if window.Since == 0 {
+		window.Since = now.Add(-defaultExpertiseRange).UnixMilli()
+	}
+
+	channelIDs, err := p.visibleChannelIDs(requesterID, teamID)
+	if err != nil {
+		p.API.LogError("Failed to get channels for expertise", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tags, err := p.userExpertise(user.Id, channelIDs, window, now, expertsLimit(r))
+	if err != nil {
+		p.API.LogError("Failed to compute user expertise", "error", err.Error(), "user_id", user.Id)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := UserExpertiseResponse{UserID: user.Id, Username: user.Username, Tags: tags}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpertiseWeight(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	halfLife := expertiseHalfLife.Milliseconds()
+
+	assert.InDelta(t, 1.0, expertiseWeight(indexedPost{CreateAt: now}, now), 1e-9)
+	assert.InDelta(t, 0.5, expertiseWeight(indexedPost{CreateAt: now - halfLife}, now), 1e-9)
+	assert.InDelta(t, 0.25, expertiseWeight(indexedPost{CreateAt: now - 2*halfLife}, now), 1e-9)
+
+	// Three reactions and replies make a post count three times as much
+	assert.InDelta(t, 3.0, expertiseWeight(indexedPost{CreateAt: now, Reactions: 1, Replies: 2}, now), 1e-9)
+}
+
+func TestTagExperts(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := int64(24 * time.Hour / time.Millisecond)
+
+	api := newTestAPI()
+	api.deactivated["gone"] = true
+	add := func(userID, message string, age int64, replies int64) {
+		post := &model.Post{
+			Id:         model.NewId(),
+			ChannelId:  "channel1",
+			UserId:     userID,
+			CreateAt:   now.UnixMilli() - age*day,
+			Message:    message,
+			ReplyCount: replies,
+		}
+		api.posts[post.Id] = post
+		api.order = append([]string{post.Id}, api.order...)
+	}
+	// alice wrote the most, but long ago; bob recently, with a lively thread
+	add("alice", "#kubernetes upgrade", 400, 0)
+	add("alice", "#kubernetes again", 390, 0)
+	add("alice", "#kubernetes notes", 380, 0)
+	add("bob", "#Kubernetes tip", 5, 7)
+	add("carol", "#kubernetes and #helm", 30, 0)
+	add("gone", "#kubernetes ", 1, 0)
+	add("carol", "#helm #helm-charts", 10, 0)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	result, err := p.tagExperts("#kubernetes", []string{"channel1"}, timeRange{}, now, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "kubernetes", result.Tag)
+	require.Len(t, result.Experts, 3)
+	assert.Equal(t, "bob", result.Experts[0].Username)
+	assert.Equal(t, "carol", result.Experts[1].Username)
+	assert.Equal(t, "alice", result.Experts[2].Username)
+	assert.Equal(t, 3, result.Experts[2].Posts)
+	assert.Equal(t, now.UnixMilli()-380*day, result.Experts[2].LastUsed)
+
+	result, err = p.tagExperts("kubernetes", []string{"channel1"}, timeRange{}, now, 1)
+	require.NoError(t, err)
+	require.Len(t, result.Experts, 1)
+
+	tags, err := p.userExpertise("carol", []string{"channel1"}, timeRange{}, now, 10)
+	require.NoError(t, err)
+	require.Len(t, tags, 3)
+	assert.Equal(t, "helm", tags[0].Tag)
+	assert.Equal(t, 2, tags[0].Posts)
+	assert.ElementsMatch(t, []string{"helm-charts", "kubernetes"}, []string{tags[1].Tag, tags[2].Tag})
+
+	tags, err = p.userExpertise("alice", []string{"channel1"}, timeRange{Since: now.UnixMilli() - 365*day}, now, 10)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func TestTagExpertsDefaultRange(t *testing.T) {
+	now := time.Now()
+	api := newTestAPI()
+	for _, post := range []*model.Post{
+		{Id: model.NewId(), ChannelId: "channel1", UserId: "alice", CreateAt: now.AddDate(-2, 0, 0).UnixMilli(), Message: "#kubernetes upgrade"},
+		{Id: model.NewId(), ChannelId: "channel1", UserId: "bob", CreateAt: now.AddDate(0, 0, -5).UnixMilli(), Message: "#kubernetes tip"},
+	} {
+		api.posts[post.Id] = post
+		api.order = append([]string{post.Id}, api.order...)
+	}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// Without since, only the past year counts
+	r := httptest.NewRequest(http.MethodGet, "/api/experts?tag=kubernetes", nil)
+	r.Header.Set("Mattermost-User-ID", "user1")
+	w := httptest.NewRecorder()
+	p.handleTagExperts(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response TagExpertsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Experts, 1)
+	assert.Equal(t, "bob", response.Experts[0].Username)
+
+	c := &commandHandler{p: p}
+	result, err := c.Handle(&model.CommandArgs{Command: "/hashtags experts kubernetes", UserId: "user1", TeamId: "team1"})
+	require.NoError(t, err)
+	assert.Contains(t, result.Text, "@bob")
+	assert.NotContains(t, result.Text, "@alice")
+}
+
+func TestMarkdownTable(t *testing.T) {
+	table := markdownTable([]string{"Tag", "Posts"}, [][]string{{"#a|b", "2"}, {"#c", "1"}})
+	assert.Equal(t, "| Tag | Posts |\n| --- | --- |\n| #a\\|b | 2 |\n| #c | 1 |\n", table)
+}
+
+func TestCommandHelp(t *testing.T) {
+	c := &commandHandler{p: &Plugin{}}
+	for _, command := range []string{"/hashtags", "/hashtags help", "/hashtags nope"} {
+		response, err := c.Handle(&model.CommandArgs{Command: command})
+		require.NoError(t, err)
+		assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+		assert.Contains(t, response.Text, "/hashtags experts <tag>", command)
+	}
+
+	response, err := c.Handle(&model.CommandArgs{Command: "/hashtags experts"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Text, "Usage")
+}
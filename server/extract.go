@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/shared/markdown"
+)
+
+// minHashtagLength is the shortest tag counted, in characters after the #.
+// It matches the default minimum hashtag length of Mattermost.
+const minHashtagLength = 3
+
+// extractOptions are the configurable parts of tag extraction.
+type extractOptions struct {
+	// includeQuotes counts tags inside block quotes.
+	includeQuotes bool
+}
+
+// extractHashtags returns the distinct hashtags of a message in the order they
+// first appear. A post counts once per tag no matter how often or in which
+// casing it repeats it; the first form used is the one returned. Every
+// counting and search path goes through here so they agree on what a tag is.
+func extractHashtags(message string, opts extractOptions) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, text := range markdownText(message, opts) {
+		for _, tag := range scanHashtags(text) {
+			key := canonicalTag(tag)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// markdownText returns the pieces of prose in a message. Code blocks, code
+// spans, link destinations and autolinked URLs are left out, and so are block
+// quotes unless opts.includeQuotes is set. The text of a link is kept.
+func markdownText(message string, opts extractOptions) []string {
+	var texts []string
+	markdown.Inspect(message, func(node any) bool {
+		switch v := node.(type) {
+		case *markdown.BlockQuote:
+			return opts.includeQuotes
+		case *markdown.FencedCode, *markdown.IndentedCode, *markdown.CodeSpan, *markdown.Autolink:
+			return false
+		case *markdown.Text:
+			texts = append(texts, v.Text)
+		}
+		return true
+	})
+	return texts
+}
+
+// scanHashtags tokenizes text following Mattermost's hashtag rules: a # at the
+// start of a word followed by Unicode letters, digits, combining marks and the
+// separators in hashtagSeparators. Separators are only kept inside a tag, so
+// "#release." and "#foo-" give release and foo while #v1.2, #team-a and
+// #proj/web/auth are kept whole. The tag must be at least minHashtagLength
+// long and start with a letter, as Mattermost requires, so #2024, #3d-printing
+// and #_internal are not tags.
+func scanHashtags(text string) []string {
+	var tags []string
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || !isHashtagStart(runes, i) {
+			continue
+		}
+
+		j := i + 1
+		for j < len(runes) && isHashtagRune(runes[j]) {
+			j++
+		}
+		if tag := trimHashtag(string(runes[i+1 : j])); isValidHashtag(tag) {
+			tags = append(tags, tag)
+		}
+		i = j - 1
+	}
+	return tags
+}
+
+// isHashtagStart reports whether the # at runes[i] starts a word. Besides
+// whitespace, a tag may follow opening brackets, quotes or emphasis markers.
+func isHashtagStart(runes []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev := runes[i-1]
+	return unicode.IsSpace(prev) || strings.ContainsRune(`([{"'*_~`, prev)
+}
+
+func isHashtagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Mc) ||
+		strings.ContainsRune(hashtagSeparators, r)
+}
+
+// hashtagSeparators may appear inside a tag but never at its end. Besides
+// Mattermost's own _ - and . the plugin accepts / and : so hierarchical tags
+// such as #proj/web/auth stay whole.
+const hashtagSeparators = "._-/:"
+
+// trimHashtag drops dangling separators, such as the full stop ending a sentence.
+func trimHashtag(tag string) string {
+	return strings.TrimRight(tag, hashtagSeparators)
+}
+
+// canonicalTag is the key a tag is counted and looked up under, so #Release,
+// #release and #RELEASE are the same tag.
+func canonicalTag(tag string) string {
+	return strings.ToLower(tag)
+}
+
+// normalizeTagQuery turns a tag given by a client into its canonical key, so
+// "#Release." finds the posts counted under release.
+func normalizeTagQuery(tag string) string {
+	return canonicalTag(trimHashtag(strings.TrimPrefix(strings.TrimSpace(tag), "#")))
+}
+
+// isHashtag reports whether tag, without its #, reads as one whole hashtag
+// under the extraction rules, so a name given by an admin can match posts.
+func isHashtag(tag string) bool {
+	tags := scanHashtags("#" + tag)
+	return len(tags) == 1 && tags[0] == tag
+}
+
+// facetSeparator splits a key:value tag such as #status:blocked.
+const facetSeparator = ":"
+
+// parseTagFacet splits a key:value tag at its first colon. Both sides must be
+// non-empty, so #status: or #:blocked are plain tags.
+func parseTagFacet(tag string) (TagFacet, bool) {
+	key, value, ok := strings.Cut(tag, facetSeparator)
+	if !ok || key == "" || value == "" {
+		return TagFacet{}, false
+	}
+	return TagFacet{Key: key, Value: value}, true
+}
+
+func isValidHashtag(tag string) bool {
+	if utf8.RuneCountInString(tag) < minHashtagLength {
+		return false
+	}
+	first, _ := utf8.DecodeRuneInString(tag)
+	return unicode.IsLetter(first)
+}
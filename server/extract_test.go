@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hashtagCorpus is shared by the extraction and the counting tests so every
+// path is held to the same definition of a tag.
+var hashtagCorpus = []struct {
+	name    string
+	message string
+	tags    []string
+}{
+	{"plain", "#release is out", []string{"release"}},
+	{"several", "#alpha and #beta", []string{"alpha", "beta"}},
+	{"repeated once", "#alpha #alpha", []string{"alpha"}},
+	{"accented", "rendez-vous au #café", []string{"café"}},
+	{"japanese", "#日本語 のテスト", []string{"日本語"}},
+	{"umlaut", "siehe #übersicht", []string{"übersicht"}},
+	{"combining mark", "#नमस्ते दुनिया", []string{"नमस्ते"}},
+	{"digits inside", "#v1.2 and #team-a_b", []string{"v1.2", "team-a_b"}},
+	{"casing", "#Release then #release and #RELEASE", []string{"Release"}},
+	{"full stop", "we shipped #release.", []string{"release"}},
+	{"sentence punctuation", "#release! #release? #release, #release...", []string{"release"}},
+	{"dangling separators", "#foo- #bar_ #baz.-", []string{"foo", "bar", "baz"}},
+	{"interior kept at end of sentence", "upgrade to #v1.2.", []string{"v1.2"}},
+	{"too short once trimmed", "#ab-.", nil},
+	{"hierarchy", "#proj/web/auth and #area.backend.db", []string{"proj/web/auth", "area.backend.db"}},
+	{"colon inside", "#status:blocked:", []string{"status:blocked"}},
+	{"too short", "#go #ab", nil},
+	{"short unicode ok", "#日本語", []string{"日本語"}},
+	{"numeric", "issue #1234 and #2024", nil},
+	{"numeric with separators", "#1.2.3", nil},
+	{"leading digit", "#3d-printing and #2fa", nil},
+	{"leading separator", "#_internal #.hidden #-flag", nil},
+	{"leading combining mark", "#\u093eabc", nil},
+	{"inside word", "foo#bar", nil},
+	{"after bracket", "(#infra) [#ops]", []string{"infra", "ops"}},
+	{"emphasis", "**#urgent** ~~#later~~", []string{"urgent", "later"}},
+	{"double hash", "##heading", nil},
+	{"empty", "#", nil},
+	{"multiline", "first\n#second\tthird #fourth", []string{"second", "fourth"}},
+	{"fenced code", "#deploy steps:\n```sh\n# install deps\nnpm ci #ci-only\n```", []string{"deploy"}},
+	{"indented code", "#snippet\n\n    #define MAX 10", []string{"snippet"}},
+	{"code span", "run `git log #head` for #history", []string{"history"}},
+	{"link target", "see [#docs](https://example.com/page#section)", []string{"docs"}},
+	{"autolink fragment", "https://example.com/wiki#anchor-text and #wiki", []string{"wiki"}},
+	{"block quote", "> #quoted text\n\n#reply", []string{"reply"}},
+	{"heading marker", "# Notes for #standup", []string{"standup"}},
+}
+
+func TestExtractHashtags(t *testing.T) {
+	for _, tc := range hashtagCorpus {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.tags, extractHashtags(tc.message, extractOptions{}))
+		})
+	}
+}
+
+func TestExtractHashtagsIncludeQuotes(t *testing.T) {
+	message := "> #quoted text\n\n#reply"
+	assert.Equal(t, []string{"reply"}, extractHashtags(message, extractOptions{}))
+	assert.Equal(t, []string{"quoted", "reply"}, extractHashtags(message, extractOptions{includeQuotes: true}))
+}
+
+func TestNormalizeTagQuery(t *testing.T) {
+	for query, tag := range map[string]string{
+		"release":  "release",
+		"#release": "release",
+		"release.": "release",
+		" #foo- ":  "foo",
+		"v1.2":     "v1.2",
+		"#team-a.": "team-a",
+		"#Release": "release",
+		"":         "",
+	} {
+		assert.Equal(t, tag, normalizeTagQuery(query), query)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// TagFacet is the key and value of a key:value tag such as #status:blocked.
+type TagFacet struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// tag returns the canonical tag a facet is written as.
+func (f TagFacet) tag() string {
+	return canonicalTag(f.Key + facetSeparator + f.Value)
+}
+
+// FacetValueCount is the number of posts using one value of a facet key.
+type FacetValueCount struct {
+	Value    string `json:"value"`
+	Display  string `json:"display"`
+	Count    int    `json:"count"`
+	LastUsed int64  `json:"lastUsed"`
+}
+
+// FacetCount is the value distribution of one facet key. Count is the sum over
+// its values, so a post using two values of a key is counted twice.
+type FacetCount struct {
+	Key    string            `json:"key"`
+	Count  int               `json:"count"`
+	Values []FacetValueCount `json:"values"`
+}
+
+type FacetsResponse struct {
+	Facets []FacetCount `json:"facets"`
+}
+
+// groupFacets collects the key:value tags among counted tags by key. Keys are
+// ordered by count and values by count within a key.
+func groupFacets(tags []HashtagCount) []FacetCount {
+	byKey := map[string]*FacetCount{}
+	var keys []string
+	for _, tag := range tags {
+		facet, ok := parseTagFacet(tag.Tag)
+		if !ok {
+			continue
+		}
+		fc, ok := byKey[facet.Key]
+		if !ok {
+			fc = &FacetCount{Key: facet.Key}
+			byKey[facet.Key] = fc
+			keys = append(keys, facet.Key)
+		}
+		display := facet.Value
+		if d, ok := parseTagFacet(tag.Display); ok {
+			display = d.Value
+		}
+		fc.Count += tag.Count
+		fc.Values = append(fc.Values, FacetValueCount{
+			Value:    facet.Value,
+			Display:  display,
+			Count:    tag.Count,
+			LastUsed: tag.LastUsed,
+		})
+	}
+
+	facets := make([]FacetCount, 0, len(keys))
+	for _, key := range keys {
+		fc := byKey[key]
+		sort.Slice(fc.Values, func(i, j int) bool {
+			if fc.Values[i].Count != fc.Values[j].Count {
+				return fc.Values[i].Count > fc.Values[j].Count
+			}
+			return fc.Values[i].Value < fc.Values[j].Value
+		})
+		facets = append(facets, *fc)
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Key < facets[j].Key
+	})
+	return facets
+}
+
+// parseFacetFilters reads facet filters written as key=value or key:value.
+func parseFacetFilters(filters []string) ([]TagFacet, error) {
+	var facets []TagFacet
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok {
+			key, value, ok = strings.Cut(filter, facetSeparator)
+		}
+		key = normalizeTagQuery(key)
+		value = canonicalTag(trimHashtag(strings.TrimSpace(value)))
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid facet %q, expected key=value", filter)
+		}
+		facets = append(facets, TagFacet{Key: key, Value: value})
+	}
+	return facets, nil
+}
+
+// facetMatcher matches the posts that use every one of the facets, each
+// through its tag or an alias of it.
+func facetMatcher(facets []TagFacet, aliases map[string]string) tagAllOf {
+	var matcher tagAllOf
+	for _, facet := range facets {
+		matcher = append(matcher, tagAnyOf(expandTag(facet.tag(), aliases)))
+	}
+	return matcher
+}
+
+// GET /api/facets?channel_id=XXX
+// GET /api/facets?team_id=XXX&since=30d
+func (p *Plugin) handleFacets(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := r.URL.Query().Get("channel_id")
+	teamID := r.URL.Query().Get("team_id")
+	window, err := parseTimeRange(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var hashtags []HashtagCount
+	switch {
+	case channelID != "":
+		if !p.canReadChannel(userID, channelID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		hashtags, err = p.computeHashtags(channelID, 5000, window)
+	case teamID != "":
+		if !p.API.HasPermissionToTeam(userID, teamID, model.PermissionViewTeam) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		hashtags, err = p.computeTeamHashtags(teamID, 1000, window)
+	default:
+		http.Error(w, "channel_id or team_id required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		p.API.LogError("Failed to compute facets", "error", err.Error(), "channel_id", channelID, "team_id", teamID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := FacetsResponse{Facets: groupFacets(hashtags)}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
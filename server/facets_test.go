@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTagFacet(t *testing.T) {
+	facet, ok := parseTagFacet("status:blocked")
+	require.True(t, ok)
+	assert.Equal(t, TagFacet{Key: "status", Value: "blocked"}, facet)
+
+	facet, ok = parseTagFacet("time:10:30")
+	require.True(t, ok)
+	assert.Equal(t, TagFacet{Key: "time", Value: "10:30"}, facet)
+
+	_, ok = parseTagFacet("release")
+	assert.False(t, ok)
+	_, ok = parseTagFacet(":blocked")
+	assert.False(t, ok)
+}
+
+func TestParseFacetFilters(t *testing.T) {
+	facets, err := parseFacetFilters([]string{"Status=Blocked", "#prio:high"})
+	require.NoError(t, err)
+	assert.Equal(t, []TagFacet{{Key: "status", Value: "blocked"}, {Key: "prio", Value: "high"}}, facets)
+
+	_, err = parseFacetFilters([]string{"status"})
+	assert.Error(t, err)
+	_, err = parseFacetFilters([]string{"status="})
+	assert.Error(t, err)
+}
+
+func TestGroupFacets(t *testing.T) {
+	facets := groupFacets([]HashtagCount{
+		{Tag: "status:blocked", Display: "status:Blocked", Count: 3},
+		{Tag: "release", Display: "release", Count: 10},
+		{Tag: "status:open", Display: "status:open", Count: 5},
+		{Tag: "prio:high", Display: "prio:high", Count: 2},
+	})
+
+	require.Len(t, facets, 2)
+	assert.Equal(t, "status", facets[0].Key)
+	assert.Equal(t, 8, facets[0].Count)
+	assert.Equal(t, []FacetValueCount{
+		{Value: "open", Display: "open", Count: 5},
+		{Value: "blocked", Display: "Blocked", Count: 3},
+	}, facets[0].Values)
+	assert.Equal(t, "prio", facets[1].Key)
+}
+
+func TestFacetMatcher(t *testing.T) {
+	matcher := facetMatcher([]TagFacet{{Key: "status", Value: "blocked"}, {Key: "prio", Value: "high"}},
+		map[string]string{"prio:urgent": "prio:high"})
+
+	matched, ok := matcher.match(indexedPost{Tags: []string{"status:Blocked", "prio:urgent"}})
+	assert.True(t, ok)
+	assert.Equal(t, "status:Blocked", matched)
+	_, ok = matcher.match(indexedPost{Tags: []string{"status:blocked", "prio:low"}})
+	assert.False(t, ok)
+
+	idx := &channelIndex{Tags: map[string]*tagStats{
+		"status:blocked": {Count: 2, Months: map[string]int{"202401": 1, "202402": 1}},
+		"prio:high":      {Count: 1, Months: map[string]int{"202402": 1}},
+		"prio:urgent":    {Count: 1, Months: map[string]int{"202403": 1}},
+	}}
+	assert.Equal(t, map[string]bool{"202402": true}, matcher.months(idx))
+	_, ok = matcher.count(idx)
+	assert.False(t, ok)
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// Tag follows live in the plugin KV store under these keys:
+//
+//	follow_user_<user id>          the TagFollows of a user
+//	follow_tag_<tag hash>          the TagFollows of a tag, as it was followed
+//	follow_queue_<user id>         the followNotices waiting for a user's next digest
+//	follow_pending                 the users whose queue was filled since the last digest
+//	follow_lock                    cluster mutex guarding the follow lists
+//	follow_queue_lock_<user id>    cluster mutex guarding the queue of a user
+//	follow_pending_lock            cluster mutex guarding follow_pending
+//
+// A user is added to follow_pending only when their queue goes from empty to
+// non-empty, so queueing a post mostly touches the queues of its followers.
+//
+// Tags are hashed to keep keys within the KV store's length limit.
+const (
+	followUserKeyPrefix      = "follow_user_"
+	followTagKeyPrefix       = "follow_tag_"
+	followQueueKeyPrefix     = "follow_queue_"
+	followPendingKey         = "follow_pending"
+	followLockKey            = "follow_lock"
+	followQueueLockKeyPrefix = "follow_queue_lock_"
+	followPendingLockKey     = "follow_pending_lock"
+
+	followDigestJobKey   = "HashtagFollowDigest"
+	followDigestInterval = 5 * time.Minute
+
+	// maxDigestPostsPerTag bounds the posts listed for one tag in a digest.
+	maxDigestPostsPerTag = 10
+	postExcerptLength    = 200
+
+	followScopeChannel = "channel"
+	followScopeTeam    = "team"
+	followScopeGlobal  = "global"
+)
+
+// TagFollow subscribes a user to the posts using a tag in one channel, in the
+// channels of one team, or everywhere. ScopeID is the channel or team ID.
+type TagFollow struct {
+	UserID   string `json:"user_id"`
+	Tag      string `json:"tag"`
+	Scope    string `json:"scope"`
+	ScopeID  string `json:"scope_id,omitempty"`
+	CreateAt int64  `json:"create_at"`
+}
+
+// FollowRequest is the body of a request to follow or unfollow a tag.
+type FollowRequest struct {
+	Tag       string `json:"tag"`
+	Scope     string `json:"scope"`
+	ChannelID string `json:"channel_id"`
+	TeamID    string `json:"team_id"`
+}
+
+type FollowsResponse struct {
+	Follows []TagFollow `json:"follows"`
+}
+
+// followNotice is a post waiting to be listed in a user's next digest.
+type followNotice struct {
+	PostID    string `json:"post_id"`
+	ChannelID string `json:"channel_id"`
+	Tag       string `json:"tag"`
+	CreateAt  int64  `json:"create_at"`
+}
+
+func followTagKey(tag string) string {
+	sum := sha256.Sum256([]byte(tag))
+	return followTagKeyPrefix + hex.EncodeToString(sum[:16])
+}
+
+// sameFollow reports whether two follows subscribe the same user to the same
+// tag in the same scope.
+func sameFollow(a, b TagFollow) bool {
+	return a.UserID == b.UserID && a.Tag == b.Tag && a.Scope == b.Scope && a.ScopeID == b.ScopeID
+}
+
+// matches reports whether a follow covers a post in a channel of a team.
+func (f TagFollow) matches(channelID, teamID string) bool {
+	switch f.Scope {
+	case followScopeChannel:
+		return f.ScopeID == channelID
+	case followScopeTeam:
+		return f.ScopeID == teamID
+	default:
+		return true
+	}
+}
+
+// withLock runs fn under a cluster mutex.
+func (p *Plugin) withLock(key string, fn func() error) error {
+	mutex, err := cluster.NewMutex(p.API, key)
+	if err != nil {
+		return fmt.Errorf("failed to create lock: %w", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return fn()
+}
+
+func (p *Plugin) getFollows(key string) ([]TagFollow, error) {
+	var follows []TagFollow
+	if _, err := p.kvGetJSON(key, &follows); err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
+
+func (p *Plugin) setFollows(key string, follows []TagFollow) error {
+	if len(follows) == 0 {
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, appErr)
+		}
+		return nil
+	}
+	return p.kvSetJSON(key, follows)
+}
+
+// getUserFollows returns the follows of a user, oldest first.
+func (p *Plugin) getUserFollows(userID string) ([]TagFollow, error) {
+	return p.getFollows(followUserKeyPrefix + userID)
+}
+
+// parseTagFollow reads the follow a request is about.
+func parseTagFollow(userID string, request FollowRequest) (TagFollow, error) {
+	follow := TagFollow{UserID: userID, Tag: normalizeTagQuery(request.Tag), Scope: request.Scope}
+	if follow.Tag == "" {
+		return follow, errors.New("tag is required")
+	}
+	switch follow.Scope {
+	case followScopeChannel:
+		follow.ScopeID = request.ChannelID
+	case followScopeTeam:
+		follow.ScopeID = request.TeamID
+	case "", followScopeGlobal:
+		follow.Scope = followScopeGlobal
+	default:
+		return follow, fmt.Errorf("invalid scope %q, expected channel, team or global", follow.Scope)
+	}
+	if follow.Scope != followScopeGlobal && follow.ScopeID == "" {
+		return follow, fmt.Errorf("%s_id required", follow.Scope)
+	}
+	return follow, nil
+}
+
+// canFollow reports whether a user may see the posts a follow covers: those
+// of a channel they can read or of a team they belong to.
+func (p *Plugin) canFollow(follow TagFollow) bool {
+	switch follow.Scope {
+	case followScopeChannel:
+		return p.canReadChannel(follow.UserID, follow.ScopeID)
+	case followScopeTeam:
+		return p.API.HasPermissionToTeam(follow.UserID, follow.ScopeID, model.PermissionViewTeam)
+	default:
+		return true
+	}
+}
+
+// followTag records a follow in the lists of its user and its tag. Following
+// the same tag in the same scope twice keeps the first follow.
+func (p *Plugin) followTag(follow TagFollow) error {
+	return p.withLock(followLockKey, func() error {
+		userKey := followUserKeyPrefix + follow.UserID
+		userFollows, err := p.getFollows(userKey)
+		if err != nil {
+			return err
+		}
+		for _, f := range userFollows {
+			if sameFollow(f, follow) {
+				return nil
+			}
+		}
+
+		tagKey := followTagKey(follow.Tag)
+		tagFollows, err := p.getFollows(tagKey)
+		if err != nil {
+			return err
+		}
+		follow.CreateAt = model.GetMillis()
+		if err := p.setFollows(tagKey, append(tagFollows, follow)); err != nil {
+			return err
+		}
+		return p.setFollows(userKey, append(userFollows, follow))
+	})
+}
+
+// unfollowTag removes a follow and reports whether there was one.
+func (p *Plugin) unfollowTag(follow TagFollow) (bool, error) {
+	removed := false
+	err := p.withLock(followLockKey, func() error {
+		without := func(follows []TagFollow) []TagFollow {
+			kept := follows[:0]
+			for _, f := range follows {
+				if sameFollow(f, follow) {
+					removed = true
+					continue
+				}
+				kept = append(kept, f)
+			}
+			return kept
+		}
+
+		userKey := followUserKeyPrefix + follow.UserID
+		userFollows, err := p.getFollows(userKey)
+		if err != nil {
+			return err
+		}
+		tagKey := followTagKey(follow.Tag)
+		tagFollows, err := p.getFollows(tagKey)
+		if err != nil {
+			return err
+		}
+		if err := p.setFollows(tagKey, without(tagFollows)); err != nil {
+			return err
+		}
+		return p.setFollows(userKey, without(userFollows))
+	})
+	return removed, err
+}
+
+// queueFollowNotices queues a new post for the next digest of every user
+// following one of its tags, as postHashtags returns them, other than its
+// author. A user following several of its tags gets the post once, under the
+// first tag.
+func (p *Plugin) queueFollowNotices(post *model.Post, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return err
+	}
+
+	var follows []TagFollow
+	keys := map[string]string{}
+	for _, t := range tags {
+		key := resolveTag(canonicalTag(t), aliases)
+		for _, k := range expandTag(key, aliases) {
+			if _, ok := keys[k]; ok {
+				continue
+			}
+			keys[k] = key
+			tagFollows, err := p.getFollows(followTagKey(k))
+			if err != nil {
+				return err
+			}
+			follows = append(follows, tagFollows...)
+		}
+	}
+	if len(follows) == 0 {
+		return nil
+	}
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		return fmt.Errorf("failed to get channel: %w", appErr)
+	}
+
+	notices := map[string]followNotice{}
+	for _, f := range follows {
+		if _, ok := notices[f.UserID]; ok || f.UserID == post.UserId || !f.matches(channel.Id, channel.TeamId) {
+			continue
+		}
+		notices[f.UserID] = followNotice{PostID: post.Id, ChannelID: post.ChannelId, Tag: keys[f.Tag], CreateAt: post.CreateAt}
+	}
+	for userID, notice := range notices {
+		if err := p.addFollowNotices(userID, []followNotice{notice}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFollowNotices adds notices to the queue of a user, keeping it oldest
+// first, and marks the user pending if the queue was empty.
+func (p *Plugin) addFollowNotices(userID string, notices []followNotice) error {
+	if len(notices) == 0 {
+		return nil
+	}
+	return p.withLock(followQueueLockKeyPrefix+userID, func() error {
+		var queue []followNotice
+		if _, err := p.kvGetJSON(followQueueKeyPrefix+userID, &queue); err != nil {
+			return err
+		}
+		wasEmpty := len(queue) == 0
+		queue = append(queue, notices...)
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].CreateAt < queue[j].CreateAt })
+		if err := p.kvSetJSON(followQueueKeyPrefix+userID, queue); err != nil {
+			return err
+		}
+		if !wasEmpty {
+			return nil
+		}
+		return p.markFollowPending(userID)
+	})
+}
+
+// markFollowPending adds users to follow_pending.
+func (p *Plugin) markFollowPending(userIDs ...string) error {
+	return p.withLock(followPendingLockKey, func() error {
+		pending := map[string]bool{}
+		if _, err := p.kvGetJSON(followPendingKey, &pending); err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			pending[userID] = true
+		}
+		return p.kvSetJSON(followPendingKey, pending)
+	})
+}
+
+// takeFollowNotices empties every queue and returns what they held by user.
+// The pending users are cleared first, so a queue filled again while the
+// others are taken marks its user pending for the next digest.
+func (p *Plugin) takeFollowNotices() (map[string][]followNotice, error) {
+	pending := map[string]bool{}
+	err := p.withLock(followPendingLockKey, func() error {
+		if _, err := p.kvGetJSON(followPendingKey, &pending); err != nil {
+			return err
+		}
+		if appErr := p.API.KVDelete(followPendingKey); appErr != nil {
+			return fmt.Errorf("failed to delete pending follows: %w", appErr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	taken := map[string][]followNotice{}
+	for userID := range pending {
+		err := p.withLock(followQueueLockKeyPrefix+userID, func() error {
+			var queue []followNotice
+			if _, err := p.kvGetJSON(followQueueKeyPrefix+userID, &queue); err != nil {
+				return err
+			}
+			if appErr := p.API.KVDelete(followQueueKeyPrefix + userID); appErr != nil {
+				return fmt.Errorf("failed to delete follow queue: %w", appErr)
+			}
+			taken[userID] = queue
+			return nil
+		})
+		if err != nil {
+			// Put back what was taken so far and leave the other queues
+			// pending for the next digest
+			var left []string
+			for pendingID := range pending {
+				queue, ok := taken[pendingID]
+				if !ok {
+					left = append(left, pendingID)
+					continue
+				}
+				if requeueErr := p.addFollowNotices(pendingID, queue); requeueErr != nil {
+					p.API.LogError("Failed to requeue follow notices", "error", requeueErr.Error(), "user_id", pendingID)
+				}
+			}
+			if markErr := p.markFollowPending(left...); markErr != nil {
+				p.API.LogError("Failed to mark follow queues pending", "error", markErr.Error())
+			}
+			return nil, err
+		}
+	}
+	return taken, nil
+}
+
+// runFollowDigestJob sends every user with queued posts one digest. It is
+// scheduled through cluster.Schedule so digests are sent once per cluster.
+// The posts of a digest that could not be sent are queued again.
+func (p *Plugin) runFollowDigestJob() {
+	taken, err := p.takeFollowNotices()
+	if err != nil {
+		p.API.LogError("Failed to read follow queues", "error", err.Error())
+		return
+	}
+	for userID, notices := range taken {
+		if err := p.sendFollowDigest(userID, notices); err != nil {
+			p.API.LogError("Failed to send follow digest", "error", err.Error(), "user_id", userID)
+			if err := p.addFollowNotices(userID, notices); err != nil {
+				p.API.LogError("Failed to requeue follow notices", "error", err.Error(), "user_id", userID)
+			}
+		}
+	}
+}
+
+// postExcerpt returns the start of a message on a single line.
+func postExcerpt(message string) string {
+	excerpt := strings.Join(strings.Fields(message), " ")
+	if runes := []rune(excerpt); len(runes) > postExcerptLength {
+		excerpt = string(runes[:postExcerptLength]) + "…"
+	}
+	return excerpt
+}
+
+// permalink links to a post through the server's redirect, which works for
+// posts in any team as well as in direct messages.
+func (p *Plugin) permalink(postID string) string {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+	return siteURL + "/_redirect/pl/" + postID
+}
+
+// formatFollowDigest writes the digest of a user's queued posts, grouped by
+// tag. Posts the user can no longer read and deleted posts are left out, and
+// an empty string is returned if nothing is left.
+func (p *Plugin) formatFollowDigest(userID string, notices []followNotice) string {
+	byTag := map[string][]followNotice{}
+	var tags []string
+	for _, n := range notices {
+		if !p.canReadChannel(userID, n.ChannelID) {
+			continue
+		}
+		if byTag[n.Tag] == nil {
+			tags = append(tags, n.Tag)
+		}
+		byTag[n.Tag] = append(byTag[n.Tag], n)
+	}
+	sort.Strings(tags)
+
+	channels := map[string]*model.Channel{}
+	users := map[string]*model.User{}
+	var sb strings.Builder
+	for _, tag := range tags {
+		var lines []string
+		for _, n := range byTag[tag] {
+			post, appErr := p.API.GetPost(n.PostID)
+			if appErr != nil || post.DeleteAt != 0 {
+				continue
+			}
+			channel, ok := channels[n.ChannelID]
+			if !ok {
+				channel, _ = p.API.GetChannel(n.ChannelID)
+				channels[n.ChannelID] = channel
+			}
+			where := "a direct message"
+			if channel != nil && channel.Type != model.ChannelTypeDirect && channel.Type != model.ChannelTypeGroup {
+				where = "~" + channel.Name
+			}
+			author := "someone"
+			if user := p.cachedUser(users, post.UserId); user != nil {
+				author = "@" + user.Username
+			}
+			lines = append(lines, fmt.Sprintf("* %s in %s: %s [Jump](%s)", author, where, postExcerpt(post.Message), p.permalink(post.Id)))
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		noun := "posts"
+		if len(lines) == 1 {
+			noun = "post"
+		}
+		fmt.Fprintf(&sb, "**#%s** - %d new %s\n", tag, len(lines), noun)
+		if len(lines) > maxDigestPostsPerTag {
+			more := len(lines) - maxDigestPostsPerTag
+			lines = append(lines[:maxDigestPostsPerTag], fmt.Sprintf("* and %d more", more))
+		}
+		sb.WriteString(strings.Join(lines, "\n") + "\n\n")
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "#### New posts in the hashtags you follow\n" + strings.TrimSpace(sb.String())
+}
+
+// sendFollowDigest sends a user the digest of their queued posts as a direct
+// message from the plugin bot.
+func (p *Plugin) sendFollowDigest(userID string, notices []followNotice) error {
+	message := p.formatFollowDigest(userID, notices)
+	if message == "" {
+		return nil
+	}
+	channel, appErr := p.API.GetDirectChannel(userID, p.botUserID)
+	if appErr != nil {
+		return fmt.Errorf("failed to get direct channel: %w", appErr)
+	}
+	if _, appErr := p.API.CreatePost(&model.Post{UserId: p.botUserID, ChannelId: channel.Id, Message: message}); appErr != nil {
+		return fmt.Errorf("failed to create post: %w", appErr)
+	}
+	return nil
+}
+
+// GET /api/follows
+// POST /api/follows {"tag": "kubernetes", "scope": "team", "team_id": "XXX"}
+// DELETE /api/follows?tag=kubernetes&scope=channel&channel_id=XXX
+func (p *Plugin) handleFollows(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request FollowRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		follow, err := parseTagFollow(userID, request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !p.canFollow(follow) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := p.followTag(follow); err != nil {
+			p.API.LogError("Failed to follow tag", "error", err.Error(), "tag", follow.Tag)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		query := r.URL.Query()
+		request := FollowRequest{
+			Tag:       query.Get("tag"),
+			Scope:     query.Get("scope"),
+			ChannelID: query.Get("channel_id"),
+			TeamID:    query.Get("team_id"),
+		}
+		follow, err := parseTagFollow(userID, request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := p.unfollowTag(follow); err != nil {
+			p.API.LogError("Failed to unfollow tag", "error", err.Error(), "tag", follow.Tag)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	follows, err := p.getUserFollows(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if follows == nil {
+		follows = []TagFollow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(FollowsResponse{Follows: follows}); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
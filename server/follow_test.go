@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowTag(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	follow, err := parseTagFollow("alice", FollowRequest{Tag: "#Kubernetes"})
+	require.NoError(t, err)
+	assert.Equal(t, TagFollow{UserID: "alice", Tag: "kubernetes", Scope: followScopeGlobal}, follow)
+	_, err = parseTagFollow("alice", FollowRequest{Tag: "kubernetes", Scope: followScopeChannel})
+	assert.Error(t, err)
+	_, err = parseTagFollow("alice", FollowRequest{Tag: "kubernetes", Scope: "everywhere"})
+	assert.Error(t, err)
+
+	require.NoError(t, p.followTag(follow))
+	require.NoError(t, p.followTag(follow))
+	follows, err := p.getUserFollows("alice")
+	require.NoError(t, err)
+	assert.Len(t, follows, 1)
+
+	removed, err := p.unfollowTag(follow)
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = p.unfollowTag(follow)
+	require.NoError(t, err)
+	assert.False(t, removed)
+	follows, err = p.getUserFollows("alice")
+	require.NoError(t, err)
+	assert.Empty(t, follows)
+}
+
+func TestFollowDigest(t *testing.T) {
+	api := newTestAPI()
+	api.outsiders["dave"] = true
+	p := &Plugin{botUserID: "bot1"}
+	p.SetAPI(api)
+	require.NoError(t, p.kvSetJSON(tagAliasesKey, map[string]string{"k8s": "kubernetes"}))
+
+	for _, follow := range []TagFollow{
+		{UserID: "alice", Tag: "kubernetes", Scope: followScopeGlobal},
+		{UserID: "bob", Tag: "k8s", Scope: followScopeChannel, ScopeID: "channel1"},
+		{UserID: "carol", Tag: "kubernetes", Scope: followScopeTeam, ScopeID: "team2"},
+		{UserID: "dave", Tag: "kubernetes", Scope: followScopeTeam, ScopeID: "team1"},
+		{UserID: "erin", Tag: "kubernetes", Scope: followScopeGlobal},
+	} {
+		require.NoError(t, p.followTag(follow))
+	}
+
+	post := &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "erin", CreateAt: 1000, Message: "#Kubernetes rollout\nis   done"}
+	api.posts[post.Id] = post
+	require.NoError(t, p.queueFollowNotices(post, p.postHashtags(post, map[string]*model.User{})))
+	bot := &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "bot1", Message: "#kubernetes"}
+	require.NoError(t, p.queueFollowNotices(bot, p.postHashtags(bot, map[string]*model.User{})))
+
+	taken, err := p.takeFollowNotices()
+	require.NoError(t, err)
+	assert.Len(t, taken, 3)
+	for _, userID := range []string{"alice", "bob", "dave"} {
+		require.Len(t, taken[userID], 1, userID)
+		assert.Equal(t, "kubernetes", taken[userID][0].Tag)
+	}
+	again, err := p.takeFollowNotices()
+	require.NoError(t, err)
+	assert.Empty(t, again)
+
+	require.NoError(t, p.sendFollowDigest("alice", taken["alice"]))
+	require.NoError(t, p.sendFollowDigest("dave", taken["dave"]))
+	require.Len(t, api.created, 1)
+	assert.Equal(t, "bot1", api.created[0].UserId)
+	assert.Equal(t, "dm_alice", api.created[0].ChannelId)
+	assert.Equal(t, "#### New posts in the hashtags you follow\n"+
+		"**#kubernetes** - 1 new post\n"+
+		"* @erin in ~channel1: #Kubernetes rollout is done [Jump](https://chat.example.com/_redirect/pl/"+post.Id+")",
+		api.created[0].Message)
+}
+
+// TestFollowDigestRequeue checks that only an empty queue marks its user
+// pending, and that a digest that could not be sent is queued again.
+func TestFollowDigestRequeue(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{botUserID: "bot1"}
+	p.SetAPI(api)
+	require.NoError(t, p.followTag(TagFollow{UserID: "alice", Tag: "kubernetes", Scope: followScopeGlobal}))
+
+	queue := func(createAt int64) *model.Post {
+		post := &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "erin", CreateAt: createAt, Message: "#kubernetes"}
+		api.posts[post.Id] = post
+		require.NoError(t, p.queueFollowNotices(post, p.postHashtags(post, map[string]*model.User{})))
+		return post
+	}
+	first := queue(1000)
+	assert.Contains(t, api.kv, followPendingKey)
+	// A second post goes to a non-empty queue and leaves follow_pending alone
+	delete(api.kv, followPendingKey)
+	second := queue(2000)
+	assert.NotContains(t, api.kv, followPendingKey)
+	require.NoError(t, p.markFollowPending("alice"))
+
+	api.failPosts = true
+	p.runFollowDigestJob()
+	assert.Empty(t, api.created)
+	pending := map[string]bool{}
+	_, err := p.kvGetJSON(followPendingKey, &pending)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"alice": true}, pending)
+
+	api.failPosts = false
+	third := queue(1500)
+	p.runFollowDigestJob()
+	require.Len(t, api.created, 1)
+	assert.Contains(t, api.created[0].Message, "3 new posts")
+	assert.Less(t, strings.Index(api.created[0].Message, first.Id), strings.Index(api.created[0].Message, third.Id))
+	assert.Less(t, strings.Index(api.created[0].Message, third.Id), strings.Index(api.created[0].Message, second.Id))
+
+	taken, err := p.takeFollowNotices()
+	require.NoError(t, err)
+	assert.Empty(t, taken)
+}
+
+func TestPostExcerpt(t *testing.T) {
+	assert.Equal(t, "a b c", postExcerpt(" a\n\nb\tc "))
+	long := postExcerpt(string(make([]rune, postExcerptLength+10)))
+	assert.Len(t, []rune(long), postExcerptLength+1)
+}
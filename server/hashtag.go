@@ -1,22 +1,42 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
+	"net/http"
 	"sort"
 	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
 )
 
+// channelPageSize is the number of channels fetched per page when listing the
+// public channels of a team.
+const channelPageSize = 200
+
 type HashtagGroup struct {
 	Prefix string         `json:"prefix"`
 	Tags   []HashtagCount `json:"tags"`
 }
 
-var tagRe = regexp.MustCompile(`(^|\s)#([a-zA-Z0-9_\-\.]+)`)
-
-func (p *Plugin) getPostsWithHashtag(tag string, channelID string) ([]HashtagPost, error) {
-	var result []HashtagPost
+// cachedUser looks a user up once per request. It returns nil if the user
+// cannot be loaded.
+func (p *Plugin) cachedUser(users map[string]*model.User, userID string) *model.User {
+	if user, ok := users[userID]; ok {
+		return user
+	}
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		user = nil
+	}
+	users[userID] = user
+	return user
+}
 
+// tagSearchChannelIDs returns the channels a tag search covers: the given
+// channel, or every channel userID is a member of when channelID is empty.
+// Callers check access to an explicit channelID.
+func (p *Plugin) tagSearchChannelIDs(userID string, channelID string) ([]string, error) {
 	// If channelID is provided, get posts from that channel
 	if channelID != "" {
 		channel, appErr := p.API.GetChannel(channelID)
@@ -24,144 +44,118 @@ func (p *Plugin) getPostsWithHashtag(tag string, channelID string) ([]HashtagPos
 			return nil, fmt.Errorf("failed to get channel: %w", appErr)
 		}
 
-		p.API.LogDebug("Searching for hashtag in channel", 
-			"tag", tag,
+		p.API.LogDebug("Searching for hashtag in channel",
 			"channel_id", channelID,
 			"team_id", channel.TeamId,
 			"channel_name", channel.Name)
 
-		page := 0
-		perPage := 200
+		return []string{channelID}, nil
+	}
 
-		for {
-			posts, appErr := p.API.GetPostsForChannel(channelID, page, perPage)
-			if appErr != nil {
-				return nil, fmt.Errorf("failed to get posts: %w", appErr)
-			}
-			if posts == nil || len(posts.Order) == 0 {
-				break
-			}
+	// If no channelID provided, search the user's own channels
+	return p.userChannelIDs(userID)
+}
 
-			p.API.LogDebug("Processing posts from channel", 
-				"page", page,
-				"count", len(posts.Order))
+// scopeChannelIDs returns the channels a request asks about: channel_id with
+// scope=channel, or the public channels of team_id with scope=team. Without a
+// scope, channel_id is used if given and team_id otherwise. On failure it
+// also returns the HTTP status to answer with.
+func (p *Plugin) scopeChannelIDs(r *http.Request) ([]string, int, error) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	scope := r.URL.Query().Get("scope")
+	channelID := r.URL.Query().Get("channel_id")
+	teamID := r.URL.Query().Get("team_id")
+	if scope == "" {
+		scope = "team"
+		if channelID != "" {
+			scope = "channel"
+		}
+	}
 
-			for _, id := range posts.Order {
-				post := posts.Posts[id]
-				if post == nil || post.Type != "" {
-					continue
-				}
+	switch scope {
+	case "channel":
+		if channelID == "" {
+			return nil, http.StatusBadRequest, errors.New("channel_id required")
+		}
+		if !p.canReadChannel(userID, channelID) {
+			return nil, http.StatusForbidden, errors.New("Forbidden")
+		}
+		return []string{channelID}, http.StatusOK, nil
+	case "team":
+		if teamID == "" {
+			return nil, http.StatusBadRequest, errors.New("team_id required")
+		}
+		if !p.API.HasPermissionToTeam(userID, teamID, model.PermissionViewTeam) {
+			return nil, http.StatusForbidden, errors.New("Forbidden")
+		}
+		channelIDs, err := p.teamChannelIDs(teamID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return channelIDs, http.StatusOK, nil
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid scope %q, expected channel or team", scope)
+	}
+}
 
-				// Check if post contains the hashtag
-				matches := tagRe.FindAllStringSubmatch(post.Message, -1)
-				hasTag := false
-				for _, m := range matches {
-					if m[2] == tag {
-						hasTag = true
-						break
-					}
-				}
+// teamChannelIDs returns the public channels of a team, the channels team
+// counts cover.
+func (p *Plugin) teamChannelIDs(teamID string) ([]string, error) {
+	var channelIDs []string
+	for page := 0; ; page++ {
+		channels, appErr := p.API.GetPublicChannelsForTeam(teamID, page, channelPageSize)
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to get channels: %w", appErr)
+		}
+		for _, channel := range channels {
+			channelIDs = append(channelIDs, channel.Id)
+		}
+		if len(channels) < channelPageSize {
+			return channelIDs, nil
+		}
+	}
+}
 
-				if !hasTag {
-					continue
-				}
+// userChannelIDs returns every channel a user belongs to across all of their
+// teams: public and private channels as well as direct and group messages.
+func (p *Plugin) userChannelIDs(userID string) ([]string, error) {
+	teams, appErr := p.API.GetTeamsForUser(userID)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get teams: %w", appErr)
+	}
 
-				// Skip bot posts
-				user, appErr := p.API.GetUser(post.UserId)
-				if appErr != nil || user.IsBot {
-					continue
-				}
+	// No team ID lists the DMs and GMs alone, which belong to no team, so a
+	// user in no team still finds them
+	teamIDs := []string{""}
+	for _, team := range teams {
+		teamIDs = append(teamIDs, team.Id)
+	}
 
-				result = append(result, HashtagPost{
-					ID:        post.Id,
-					Message:   post.Message,
-					CreateAt:  post.CreateAt,
-					Username:  user.Username,
-					ChannelID: post.ChannelId,
-				})
-			}
-			page++
-		}
-	} else {
-		// If no channelID provided, search across all teams
-		teams, appErr := p.API.GetTeams()
+	var channelIDs []string
+	seen := map[string]bool{}
+	for _, teamID := range teamIDs {
+		// DMs and GMs are returned for every team, so they are de-duplicated
+		channels, appErr := p.API.GetChannelsForTeamForUser(teamID, userID, false)
 		if appErr != nil {
-			return nil, fmt.Errorf("failed to get teams: %w", appErr)
+			p.API.LogError("Failed to get channels for team", "error", appErr.Error(), "team_id", teamID)
+			continue
 		}
-
-		for _, team := range teams {
-			channels, appErr := p.API.GetPublicChannelsForTeam(team.Id, 0, 1000)
-			if appErr != nil {
-				p.API.LogError("Failed to get channels for team", "error", appErr.Error(), "team_id", team.Id)
+		for _, channel := range channels {
+			if seen[channel.Id] {
 				continue
 			}
-
-			for _, channel := range channels {
-				page := 0
-				perPage := 200
-
-				for {
-					posts, appErr := p.API.GetPostsForChannel(channel.Id, page, perPage)
-					if appErr != nil {
-						p.API.LogError("Failed to get posts for channel", "error", appErr.Error(), "channel_id", channel.Id)
-						break
-					}
-					if posts == nil || len(posts.Order) == 0 {
-						break
-					}
-
-					for _, id := range posts.Order {
-						post := posts.Posts[id]
-						if post == nil || post.Type != "" {
-							continue
-						}
-
-						// Check if post contains the hashtag
-						matches := tagRe.FindAllStringSubmatch(post.Message, -1)
-						hasTag := false
-						for _, m := range matches {
-							if m[2] == tag {
-								hasTag = true
-								break
-							}
-						}
-
-						if !hasTag {
-							continue
-						}
-
-						// Skip bot posts
-						user, appErr := p.API.GetUser(post.UserId)
-						if appErr != nil || user.IsBot {
-							continue
-						}
-
-						result = append(result, HashtagPost{
-							ID:        post.Id,
-							Message:   post.Message,
-							CreateAt:  post.CreateAt,
-							Username:  user.Username,
-							ChannelID: post.ChannelId,
-						})
-					}
-					page++
-				}
-			}
+			seen[channel.Id] = true
+			channelIDs = append(channelIDs, channel.Id)
 		}
 	}
 
-	// Sort posts by creation time, newest first
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].CreateAt > result[j].CreateAt
-	})
-
-	p.API.LogDebug("Returning posts", "count", len(result))
-	return result, nil
+	p.API.LogDebug("Searching for hashtag in the user's channels", "user_id", userID, "channels", len(channelIDs))
+	return channelIDs, nil
 }
 
 func groupHashtagsByPrefix(tags []HashtagCount) []HashtagGroup {
 	groups := make(map[string][]HashtagCount)
-	
+
 	for _, tag := range tags {
 		parts := strings.Split(tag.Tag, "-")
 		// Only group hashtags that contain hyphens (multi-word)
@@ -198,8 +192,26 @@ func groupHashtagsByPrefix(tags []HashtagCount) []HashtagGroup {
 func formatHashtagCounts(counts map[string]*hashtagInfo) ([]HashtagCount, error) {
 	tags := make([]HashtagCount, 0, len(counts))
 	for t, info := range counts {
+		variants := info.sortedVariants()
+		display := t
+		for _, v := range variants {
+			// Alias spellings are listed as variants but never displayed
+			if canonicalTag(v) == t {
+				display = v
+				break
+			}
+		}
+		sort.Strings(info.aliases)
+		var facet *TagFacet
+		if f, ok := parseTagFacet(t); ok {
+			facet = &f
+		}
 		tags = append(tags, HashtagCount{
 			Tag:      t,
+			Display:  display,
+			Variants: variants,
+			Aliases:  info.aliases,
+			Facet:    facet,
 			Count:    info.count,
 			CreateAt: info.createAt,
 			LastUsed: info.lastUsed,
@@ -209,147 +221,223 @@ func formatHashtagCounts(counts map[string]*hashtagInfo) ([]HashtagCount, error)
 	return tags, nil
 }
 
-func (p *Plugin) computeTeamHashtags(teamID string, max int) ([]HashtagCount, error) {
+// computeTeamHashtags counts the tags of every public channel of a team used
+// within a time range. max bounds the number of tag uses read by scans of
+// channels that are not indexed yet; indexed channels always contribute their
+// full counts.
+func (p *Plugin) computeTeamHashtags(teamID string, max int, window timeRange) ([]HashtagCount, error) {
 	counts := map[string]*hashtagInfo{}
 	totalTags := 0
 
 	p.API.LogDebug("Getting channels for team", "team_id", teamID)
-	channels, appErr := p.API.GetPublicChannelsForTeam(teamID, 0, 1000)
-	if appErr != nil {
-		p.API.LogError("Failed to get channels", "error", appErr.Error(), "team_id", teamID)
-		return nil, fmt.Errorf("failed to get channels: %w", appErr)
+	channelIDs, err := p.teamChannelIDs(teamID)
+	if err != nil {
+		p.API.LogError("Failed to get channels", "error", err.Error(), "team_id", teamID)
+		return nil, err
 	}
 
-	p.API.LogDebug("Found channels", "count", len(channels))
-
-	for _, channel := range channels {
-		page := 0
-		perPage := 200
-
-		for {
-			posts, appErr := p.API.GetPostsForChannel(channel.Id, page, perPage)
-			if appErr != nil {
-				return nil, appErr
-			}
-			if posts == nil || len(posts.Order) == 0 {
-				break
-			}
-			for _, id := range posts.Order {
-				post := posts.Posts[id]
-				if post == nil || post.Type != "" {
-					continue
-				}
-				
-				// Check if the post is from a bot
-				user, appErr := p.API.GetUser(post.UserId)
-				if appErr != nil || user.IsBot {
-					continue
-				}
+	p.API.LogDebug("Found channels", "count", len(channelIDs))
 
-				matches := tagRe.FindAllStringSubmatch(post.Message, -1)
-				for _, m := range matches {
-					tag := m[2]
-					if info, exists := counts[tag]; exists {
-						info.count++
-						if post.CreateAt > info.lastUsed {
-							info.lastUsed = post.CreateAt
-						}
-						if post.CreateAt < info.createAt {
-							info.createAt = post.CreateAt
-						}
-					} else {
-						counts[tag] = &hashtagInfo{
-							count:    1,
-							createAt: post.CreateAt,
-							lastUsed: post.CreateAt,
-						}
-					}
-					totalTags++
-					if max > 0 && totalTags >= max {
-						break
-					}
-				}
-				
-				if max > 0 && totalTags >= max {
-					break
-				}
-			}
-			if max > 0 && totalTags >= max {
-				break
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	for _, channelID := range channelIDs {
+		budget := 0
+		if max > 0 {
+			budget = max - totalTags
+			if budget <= 0 {
+				budget = -1
 			}
-			page++
 		}
+		n, err := p.countChannelHashtags(channelID, counts, budget, window, aliases)
+		if err != nil {
+			return nil, err
+		}
+		totalTags += n
 	}
 
+	archived, err := p.getArchivedTags()
+	if err != nil {
+		return nil, err
+	}
+	hideArchivedTags(counts, archived)
+
 	return formatHashtagCounts(counts)
 }
 
+// hashtagInfo accumulates the counts of one canonical tag. variants holds the
+// number of posts per spelling seen and aliases the aliases counted under it.
 type hashtagInfo struct {
 	count    int
 	createAt int64
 	lastUsed int64
+	variants map[string]int
+	aliases  []string
 }
 
-func (p *Plugin) computeHashtags(channelID string, max int) ([]HashtagCount, error) {
-	counts := map[string]*hashtagInfo{}
-	totalTags := 0
+// hashtagInfoFor returns the entry of counts for a canonical key, creating it if needed.
+func hashtagInfoFor(counts map[string]*hashtagInfo, key string) *hashtagInfo {
+	info, ok := counts[key]
+	if !ok {
+		info = &hashtagInfo{variants: map[string]int{}}
+		counts[key] = info
+	}
+	return info
+}
 
-	page := 0
-	perPage := 200
+func (info *hashtagInfo) add(count int, createAt, lastUsed int64) {
+	if info.count == 0 || createAt < info.createAt {
+		info.createAt = createAt
+	}
+	if lastUsed > info.lastUsed {
+		info.lastUsed = lastUsed
+	}
+	info.count += count
+}
 
-	for {
-		posts, appErr := p.API.GetPostsForChannel(channelID, page, perPage)
-		if appErr != nil {
-			return nil, appErr
+func (info *hashtagInfo) addAlias(alias string) {
+	for _, a := range info.aliases {
+		if a == alias {
+			return
 		}
-		if posts == nil || len(posts.Order) == 0 {
-			break
+	}
+	info.aliases = append(info.aliases, alias)
+}
+
+// sortedVariants returns the casings of a tag, most used first. The first one
+// is the display form.
+func (info *hashtagInfo) sortedVariants() []string {
+	variants := make([]string, 0, len(info.variants))
+	for v := range info.variants {
+		variants = append(variants, v)
+	}
+	sort.Slice(variants, func(i, j int) bool {
+		if info.variants[variants[i]] != info.variants[variants[j]] {
+			return info.variants[variants[i]] > info.variants[variants[j]]
 		}
-		for _, id := range posts.Order {
-			post := posts.Posts[id]
-			if post == nil || post.Type != "" {
-				continue
+		return variants[i] < variants[j]
+	})
+	return variants
+}
+
+// computeHashtags counts the tags of a channel used within a time range, from
+// the index when the channel's history has been indexed and by scanning up to
+// max tag uses otherwise.
+func (p *Plugin) computeHashtags(channelID string, max int, window timeRange) ([]HashtagCount, error) {
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]*hashtagInfo{}
+	if _, err := p.countChannelHashtags(channelID, counts, max, window, aliases); err != nil {
+		return nil, err
+	}
+
+	archived, err := p.getArchivedTags()
+	if err != nil {
+		return nil, err
+	}
+	hideArchivedTags(counts, archived)
+
+	return formatHashtagCounts(counts)
+}
+
+// countChannelHashtags adds the tags of a channel used within a time range to
+// counts, with aliases counted under their canonical tag, and returns the
+// number of tag uses it added. A budget of 0 means no limit on the fallback
+// scan and a negative budget skips channels that would need one.
+func (p *Plugin) countChannelHashtags(channelID string, counts map[string]*hashtagInfo, budget int, window timeRange, aliases map[string]string) (int, error) {
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil {
+		return 0, err
+	}
+	if idx == nil || !idx.Indexed {
+		if budget < 0 {
+			return 0, nil
+		}
+		return p.scanChannelHashtags(channelID, counts, budget, window, aliases)
+	}
+	if window.bounded() {
+		return p.countChannelHashtagsInRange(idx, counts, window, aliases)
+	}
+	return p.addIndexedTagCounts(idx, counts, aliases)
+}
+
+// addIndexedTagCounts adds the all-time counters of an indexed channel to
+// counts under the canonical tag of each key. A tag used under several keys,
+// such as under its own name and an alias, is counted from its month logs
+// instead, since a single post may use more than one of them.
+func (p *Plugin) addIndexedTagCounts(idx *channelIndex, counts map[string]*hashtagInfo, aliases map[string]string) (int, error) {
+	keysByTag := map[string]tagAnyOf{}
+	for key := range idx.Tags {
+		tag := resolveTag(key, aliases)
+		keysByTag[tag] = append(keysByTag[tag], key)
+	}
+
+	total := 0
+	for tag, keys := range keysByTag {
+		info := hashtagInfoFor(counts, tag)
+		for _, key := range keys {
+			for variant, n := range idx.Tags[key].Variants {
+				info.variants[variant] += n
 			}
-			
-			// Skip bot posts for consistency with team view
-			user, appErr := p.API.GetUser(post.UserId)
-			if appErr != nil || user.IsBot {
-				continue
+			if key != tag {
+				info.addAlias(key)
 			}
+		}
 
-			matches := tagRe.FindAllStringSubmatch(post.Message, -1)
-			for _, m := range matches {
-				tag := m[2]
-				if info, exists := counts[tag]; exists {
-					info.count++
-					if post.CreateAt > info.lastUsed {
-						info.lastUsed = post.CreateAt
-					}
-					if post.CreateAt < info.createAt {
-						info.createAt = post.CreateAt
-					}
-				} else {
-					counts[tag] = &hashtagInfo{
-						count:    1,
-						createAt: post.CreateAt,
-						lastUsed: post.CreateAt,
-					}
-				}
-				totalTags++
-				if max > 0 && totalTags >= max {
-					break
-				}
+		if len(keys) == 1 {
+			stats := idx.Tags[keys[0]]
+			info.add(stats.Count, stats.CreateAt, stats.LastUsed)
+			total += stats.Count
+			continue
+		}
+		for month := range keys.months(idx) {
+			entries, err := p.getChannelLog(idx.ChannelID, month)
+			if err != nil {
+				return 0, err
 			}
-			
-			if max > 0 && totalTags >= max {
-				break
+			for _, e := range entries {
+				if _, ok := keys.match(e); ok {
+					info.add(1, e.CreateAt, e.CreateAt)
+					total++
+				}
 			}
 		}
-		if max > 0 && totalTags >= max {
-			break
+	}
+	return total, nil
+}
+
+// countChannelHashtagsInRange counts an indexed channel from the month logs
+// overlapping a time range, since the per-tag counters are all-time.
+func (p *Plugin) countChannelHashtagsInRange(idx *channelIndex, counts map[string]*hashtagInfo, window timeRange, aliases map[string]string) (int, error) {
+	total := 0
+	for _, month := range idx.Months {
+		if !window.containsMonth(month) {
+			continue
+		}
+		entries, err := p.getChannelLog(idx.ChannelID, month)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if window.contains(e.CreateAt) {
+				total += addPostTags(counts, e, aliases)
+			}
 		}
-		page++
 	}
+	return total, nil
+}
 
-	return formatHashtagCounts(counts)
-}
\ No newline at end of file
+func (p *Plugin) scanChannelHashtags(channelID string, counts map[string]*hashtagInfo, max int, window timeRange, aliases map[string]string) (int, error) {
+	totalTags := 0
+	err := p.scanChannelEntries(channelID, window, map[string]*model.User{}, func(entry indexedPost) bool {
+		totalTags += addPostTags(counts, entry, aliases)
+		return max <= 0 || totalTags < max
+	})
+	if err != nil {
+		return 0, err
+	}
+	return totalTags, nil
+}
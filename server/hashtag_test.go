@@ -0,0 +1,267 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corpusAPI serves a single unindexed channel holding one post per corpus
+// entry, plus a post by a bot that must never be counted.
+type corpusAPI struct {
+	*plugintest.API
+	posts map[string]*model.Post
+	order []string
+}
+
+func newCorpusAPI() *corpusAPI {
+	messages := make([]string, 0, len(hashtagCorpus))
+	for _, tc := range hashtagCorpus {
+		messages = append(messages, tc.message)
+	}
+	return newMessagesAPI(messages)
+}
+
+// newMessagesAPI serves one post by user1 per message, oldest first, plus
+// the bot post.
+func newMessagesAPI(messages []string) *corpusAPI {
+	api := &corpusAPI{API: &plugintest.API{}, posts: map[string]*model.Post{}}
+	for i, message := range messages {
+		post := &model.Post{
+			Id:        model.NewId(),
+			ChannelId: "channel1",
+			UserId:    "user1",
+			CreateAt:  int64(1000 + i),
+			Message:   message,
+		}
+		api.posts[post.Id] = post
+		api.order = append([]string{post.Id}, api.order...)
+	}
+	bot := &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "bot1", CreateAt: 5000, Message: "#release #alpha"}
+	api.posts[bot.Id] = bot
+	api.order = append([]string{bot.Id}, api.order...)
+	return api
+}
+
+func (a *corpusAPI) LogDebug(msg string, keyValuePairs ...interface{}) {}
+func (a *corpusAPI) LogWarn(msg string, keyValuePairs ...interface{})  {}
+func (a *corpusAPI) LogError(msg string, keyValuePairs ...interface{}) {}
+
+func (a *corpusAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return nil, nil
+}
+
+func (a *corpusAPI) GetChannel(channelID string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: channelID, TeamId: "team1", Name: channelID}, nil
+}
+
+func (a *corpusAPI) GetPublicChannelsForTeam(teamID string, page, perPage int) ([]*model.Channel, *model.AppError) {
+	return []*model.Channel{{Id: "channel1", TeamId: teamID}}, nil
+}
+
+func (a *corpusAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	return &model.User{Id: userID, Username: userID, IsBot: userID == "bot1"}, nil
+}
+
+func (a *corpusAPI) GetPost(postID string) (*model.Post, *model.AppError) {
+	post, ok := a.posts[postID]
+	if !ok {
+		return nil, model.NewAppError("GetPost", "not_found", nil, "", http.StatusNotFound)
+	}
+	return post, nil
+}
+
+// channelOrder returns the posts of one channel, newest first.
+func (a *corpusAPI) channelOrder(channelID string) []string {
+	var order []string
+	for _, id := range a.order {
+		if a.posts[id].ChannelId == channelID {
+			order = append(order, id)
+		}
+	}
+	return order
+}
+
+func (a *corpusAPI) GetPostsForChannel(channelID string, page, perPage int) (*model.PostList, *model.AppError) {
+	order := a.channelOrder(channelID)
+	list := model.NewPostList()
+	for i := page * perPage; i < len(order) && i < (page+1)*perPage; i++ {
+		list.AddPost(a.posts[order[i]])
+		list.AddOrder(order[i])
+	}
+	return list, nil
+}
+
+func (a *corpusAPI) GetPostsBefore(channelID, postID string, page, perPage int) (*model.PostList, *model.AppError) {
+	order := a.channelOrder(channelID)
+	list := model.NewPostList()
+	for i, id := range order {
+		if id != postID {
+			continue
+		}
+		for j := i + 1 + page*perPage; j < len(order) && j < i+1+(page+1)*perPage; j++ {
+			list.AddPost(a.posts[order[j]])
+			list.AddOrder(order[j])
+		}
+	}
+	return list, nil
+}
+
+// TestHashtagPathsAgree checks that channel counts, team counts and the post
+// search all see the same tags for the corpus.
+func TestHashtagPathsAgree(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(newCorpusAPI())
+
+	expected := map[string]int{}
+	for _, tc := range hashtagCorpus {
+		for _, tag := range tc.tags {
+			expected[canonicalTag(tag)]++
+		}
+	}
+
+	channelTags, err := p.computeHashtags("channel1", 0, timeRange{})
+	require.NoError(t, err)
+	teamTags, err := p.computeTeamHashtags("team1", 0, timeRange{})
+	require.NoError(t, err)
+
+	toMap := func(counts []HashtagCount) map[string]int {
+		m := map[string]int{}
+		for _, c := range counts {
+			m[c.Tag] = c.Count
+		}
+		return m
+	}
+	assert.Equal(t, expected, toMap(channelTags))
+	assert.Equal(t, expected, toMap(teamTags))
+
+	for tag, count := range expected {
+		page, err := p.pageTagPosts(tagAnyOf{tag}, []string{"channel1"}, timeRange{}, nil, 0, 0, map[string]*model.User{})
+		require.NoError(t, err)
+		assert.Len(t, page.Entries, count, "posts for #%s", tag)
+	}
+}
+
+func TestFormatHashtagCountsDisplay(t *testing.T) {
+	counts := map[string]*hashtagInfo{}
+	info := hashtagInfoFor(counts, "release")
+	info.add(5, 100, 200)
+	info.variants["Release"] = 3
+	info.variants["release"] = 1
+	info.variants["RELEASE"] = 1
+
+	tags, err := formatHashtagCounts(counts)
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	assert.Equal(t, "release", tags[0].Tag)
+	assert.Equal(t, "Release", tags[0].Display)
+	assert.Equal(t, []string{"Release", "RELEASE", "release"}, tags[0].Variants)
+	assert.Equal(t, 5, tags[0].Count)
+}
+
+// manyChannelsAPI serves a team with more public channels than fit in one page.
+type manyChannelsAPI struct {
+	*corpusAPI
+	channels int
+}
+
+func (a *manyChannelsAPI) GetPublicChannelsForTeam(teamID string, page, perPage int) ([]*model.Channel, *model.AppError) {
+	var channels []*model.Channel
+	for i := page * perPage; i < min((page+1)*perPage, a.channels); i++ {
+		channels = append(channels, &model.Channel{Id: model.NewId(), TeamId: teamID})
+	}
+	return channels, nil
+}
+
+func TestTeamChannelIDsPages(t *testing.T) {
+	for _, n := range []int{0, channelPageSize, 1234} {
+		p := &Plugin{}
+		p.SetAPI(&manyChannelsAPI{corpusAPI: newMessagesAPI(nil), channels: n})
+
+		channelIDs, err := p.teamChannelIDs("team1")
+		require.NoError(t, err)
+		assert.Len(t, channelIDs, n)
+	}
+}
+
+// userChannelsAPI serves the teams and channels of a user. Every team lists
+// the DMs and GMs too, like the server does; the teams in failing cannot be
+// loaded and the errors they cause are recorded.
+type userChannelsAPI struct {
+	*corpusAPI
+	teams    []string
+	channels map[string][]string
+	failing  map[string]bool
+	errors   []string
+}
+
+func (a *userChannelsAPI) GetTeamsForUser(userID string) ([]*model.Team, *model.AppError) {
+	teams := make([]*model.Team, 0, len(a.teams))
+	for _, teamID := range a.teams {
+		teams = append(teams, &model.Team{Id: teamID})
+	}
+	return teams, nil
+}
+
+func (a *userChannelsAPI) GetChannelsForTeamForUser(teamID, userID string, includeDeleted bool) ([]*model.Channel, *model.AppError) {
+	if a.failing[teamID] {
+		return nil, model.NewAppError("GetChannelsForTeamForUser", "team_failed", nil, "", http.StatusInternalServerError)
+	}
+	channels := []*model.Channel{{Id: "dm1", Type: model.ChannelTypeDirect}, {Id: "gm1", Type: model.ChannelTypeGroup}}
+	for _, channelID := range a.channels[teamID] {
+		channels = append(channels, &model.Channel{Id: channelID, TeamId: teamID})
+	}
+	return channels, nil
+}
+
+func (a *userChannelsAPI) LogError(msg string, keyValuePairs ...interface{}) {
+	a.errors = append(a.errors, msg)
+}
+
+func TestUserChannelIDs(t *testing.T) {
+	channels := map[string][]string{
+		"team1": {"channel1", "private1"},
+		"team2": {"channel2"},
+	}
+	tests := []struct {
+		name     string
+		teams    []string
+		failing  map[string]bool
+		expected []string
+		errors   int
+	}{
+		{
+			name:     "public and private channels of every team, with DMs and GMs once",
+			teams:    []string{"team1", "team2"},
+			expected: []string{"channel1", "channel2", "dm1", "gm1", "private1"},
+		},
+		{
+			name:     "a team that fails to load is skipped",
+			teams:    []string{"team1", "team2"},
+			failing:  map[string]bool{"team1": true},
+			expected: []string{"channel2", "dm1", "gm1"},
+			errors:   1,
+		},
+		{
+			name:     "a user in no team still has DMs and GMs",
+			expected: []string{"dm1", "gm1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &userChannelsAPI{corpusAPI: newMessagesAPI(nil), teams: tc.teams, channels: channels, failing: tc.failing}
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			channelIDs, err := p.userChannelIDs("user1")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, channelIDs)
+			assert.Len(t, api.errors, tc.errors)
+		})
+	}
+}
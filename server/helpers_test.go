@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// testAPI is the plugin API most tests share. On top of the posts of a
+// corpusAPI it keeps the KV store in memory and records the posts the bot
+// creates, or fails to create them when failPosts is set.
+//
+// Channels are public unless channelTypes says otherwise, and every user but
+// the outsiders can read them. The users in deactivated are deactivated.
+// team1 is the only team. Its single member, user1, belongs to channel1 and
+// private1, and so does any user asked about; memberSweeps counts how often
+// its members were listed.
+type testAPI struct {
+	*corpusAPI
+	// kvMu lets concurrent requests share the KV store
+	kvMu sync.Mutex
+	kv   map[string][]byte
+
+	channelTypes map[string]model.ChannelType
+	outsiders    map[string]bool
+	deactivated  map[string]bool
+
+	created      []*model.Post
+	failPosts    bool
+	memberSweeps int
+}
+
+func newTestAPI() *testAPI {
+	return &testAPI{
+		corpusAPI:    newMessagesAPI(nil),
+		kv:           map[string][]byte{},
+		channelTypes: map[string]model.ChannelType{},
+		outsiders:    map[string]bool{},
+		deactivated:  map[string]bool{},
+	}
+}
+
+func (a *testAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.kvMu.Lock()
+	defer a.kvMu.Unlock()
+	return a.kv[key], nil
+}
+
+func (a *testAPI) KVSet(key string, value []byte) *model.AppError {
+	a.kvMu.Lock()
+	defer a.kvMu.Unlock()
+	a.kv[key] = value
+	return nil
+}
+
+// KVSetWithOptions only supports what cluster.Mutex needs.
+func (a *testAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	a.kvMu.Lock()
+	defer a.kvMu.Unlock()
+	if options.Atomic && !bytes.Equal(a.kv[key], options.OldValue) {
+		return false, nil
+	}
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+	return true, nil
+}
+
+func (a *testAPI) KVDelete(key string) *model.AppError {
+	a.kvMu.Lock()
+	defer a.kvMu.Unlock()
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *testAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	user, appErr := a.corpusAPI.GetUser(userID)
+	if a.deactivated[userID] {
+		user.DeleteAt = 1
+	}
+	return user, appErr
+}
+
+func (a *testAPI) GetChannel(channelID string) (*model.Channel, *model.AppError) {
+	channel, appErr := a.corpusAPI.GetChannel(channelID)
+	channel.Type = model.ChannelTypeOpen
+	if channelType, ok := a.channelTypes[channelID]; ok {
+		channel.Type = channelType
+	}
+	return channel, appErr
+}
+
+func (a *testAPI) HasPermissionToChannel(userID, channelID string, permission *model.Permission) bool {
+	return !a.outsiders[userID]
+}
+
+func (a *testAPI) GetConfig() *model.Config {
+	config := &model.Config{}
+	config.SetDefaults()
+	config.ServiceSettings.SiteURL = model.NewPointer("https://chat.example.com/")
+	return config
+}
+
+func (a *testAPI) GetDirectChannel(userID1, userID2 string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: "dm_" + userID1, Type: model.ChannelTypeDirect}, nil
+}
+
+func (a *testAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	if a.failPosts {
+		return nil, model.NewAppError("CreatePost", "create_failed", nil, "", http.StatusInternalServerError)
+	}
+	a.created = append(a.created, post)
+	return post, nil
+}
+
+func (a *testAPI) GetTeams() ([]*model.Team, *model.AppError) {
+	return []*model.Team{{Id: "team1"}}, nil
+}
+
+func (a *testAPI) GetTeamsForUser(userID string) ([]*model.Team, *model.AppError) {
+	return []*model.Team{{Id: "team1"}}, nil
+}
+
+func (a *testAPI) GetTeamMembers(teamID string, page, perPage int) ([]*model.TeamMember, *model.AppError) {
+	a.memberSweeps++
+	return []*model.TeamMember{{TeamId: teamID, UserId: "user1"}}, nil
+}
+
+func (a *testAPI) GetChannelsForTeamForUser(teamID, userID string, includeDeleted bool) ([]*model.Channel, *model.AppError) {
+	return []*model.Channel{{Id: "channel1", TeamId: teamID}, {Id: "private1", TeamId: teamID, Type: model.ChannelTypePrivate}}, nil
+}
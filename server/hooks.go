@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// MessageHasBeenPosted adds new posts to the hashtag index, queues them for
+// the followers of their tags and the webhooks, and mirrors them as the
+// routing rules say. The tags are extracted once for all of them. A reply
+// refreshes the reply count of its root post.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	entry := p.indexEntryForPost(post, map[string]*model.User{})
+	if err := p.indexPost(post, entry); err != nil {
+		p.API.LogError("Failed to index post", "error", err.Error(), "post_id", post.Id)
+	}
+	if err := p.queueFollowNotices(post, entry.Tags); err != nil {
+		p.API.LogError("Failed to queue post for followers", "error", err.Error(), "post_id", post.Id)
+	}
+	if err := p.routePost(post, entry.Tags); err != nil {
+		p.API.LogError("Failed to route post", "error", err.Error(), "post_id", post.Id)
+	}
+	p.webhookPostEvent(webhookEventCreated, post, entry.Tags)
+	if post.RootId != "" {
+		if err := p.refreshIndexedPost(post.RootId); err != nil {
+			p.API.LogError("Failed to refresh indexed post", "error", err.Error(), "post_id", post.RootId)
+		}
+	}
+}
+
+// MessageHasBeenUpdated re-indexes edited posts so added or removed tags are
+// picked up, and tells the webhooks about the added ones.
+func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	users := map[string]*model.User{}
+	entry := p.indexEntryForPost(newPost, users)
+	if err := p.indexPost(newPost, entry); err != nil {
+		p.API.LogError("Failed to re-index post", "error", err.Error(), "post_id", newPost.Id)
+	}
+	p.webhookPostEvent(webhookEventEdited, newPost, addedTags(p.postHashtags(oldPost, users), entry.Tags))
+}
+
+// MessageHasBeenDeleted removes deleted posts from the hashtag index and
+// tells the webhooks about the tags they used.
+func (p *Plugin) MessageHasBeenDeleted(c *plugin.Context, post *model.Post) {
+	var indexed indexedPost
+	if _, err := p.kvGetJSON(indexPostKeyPrefix+post.Id, &indexed); err != nil {
+		p.API.LogError("Failed to get indexed post", "error", err.Error(), "post_id", post.Id)
+	}
+	if err := p.unindexPost(post); err != nil {
+		p.API.LogError("Failed to remove post from index", "error", err.Error(), "post_id", post.Id)
+	}
+	p.webhookPostEvent(webhookEventDeleted, post, indexed.Tags)
+	if post.RootId != "" {
+		if err := p.refreshIndexedPost(post.RootId); err != nil {
+			p.API.LogError("Failed to refresh indexed post", "error", err.Error(), "post_id", post.RootId)
+		}
+	}
+}
+
+// ReactionHasBeenAdded refreshes the reaction count of an indexed post.
+func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reaction) {
+	if err := p.refreshIndexedPost(reaction.PostId); err != nil {
+		p.API.LogError("Failed to refresh indexed post", "error", err.Error(), "post_id", reaction.PostId)
+	}
+}
+
+// ReactionHasBeenRemoved refreshes the reaction count of an indexed post.
+func (p *Plugin) ReactionHasBeenRemoved(c *plugin.Context, reaction *model.Reaction) {
+	if err := p.refreshIndexedPost(reaction.PostId); err != nil {
+		p.API.LogError("Failed to refresh indexed post", "error", err.Error(), "post_id", reaction.PostId)
+	}
+}
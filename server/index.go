@@ -0,0 +1,832 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// The hashtag index lives in the plugin KV store under these prefixes:
+//
+//	idx_post_<post id>                  the indexedPost last recorded for a post
+//	idx_channel_<channel id>            the channelIndex summary for a channel
+//	idx_month_<channel id>_<yyyymm>     the monthLog of a channel for one UTC month
+//	idx_log_<channel id>_<yyyymmdd>     the tagged posts of a channel for one UTC day
+//	idx_lock_<channel id>               cluster mutex guarding the keys of a channel
+//
+// and the counters of each tag described in series.go. A new post rewrites
+// the log of its day only, and a reaction or reply the monthLog only.
+const (
+	indexPostKeyPrefix    = "idx_post_"
+	indexChannelKeyPrefix = "idx_channel_"
+	indexMonthKeyPrefix   = "idx_month_"
+	indexLogKeyPrefix     = "idx_log_"
+	indexLockKeyPrefix    = "idx_lock_"
+
+	indexMonthLayout = "200601"
+	indexDayLayout   = "20060102"
+)
+
+// indexedPost is the part of a post the index keeps. Post bodies are not
+// stored; they are fetched again when a page of results is returned. Tags
+// keeps the casing the author used. Reactions and Replies are the engagement
+// the post received, refreshed by the reaction and reply hooks. UpdateAt and
+// DeleteAt are only kept in idx_post_, where an entry without tags marks a
+// post the hooks unindexed.
+type indexedPost struct {
+	ID        string   `json:"id"`
+	ChannelID string   `json:"channel_id"`
+	UserID    string   `json:"user_id"`
+	CreateAt  int64    `json:"create_at"`
+	UpdateAt  int64    `json:"update_at,omitempty"`
+	DeleteAt  int64    `json:"delete_at,omitempty"`
+	Tags      []string `json:"tags"`
+	Reactions int      `json:"reactions,omitempty"`
+	Replies   int      `json:"replies,omitempty"`
+}
+
+// hasTag reports whether the post uses the tag with the given canonical key.
+func (e indexedPost) hasTag(key string) bool {
+	return e.matchedTag(key) != ""
+}
+
+// matchedTag returns the form in which the post wrote the tag with the given
+// canonical key, or "" if it does not use it.
+func (e indexedPost) matchedTag(key string) string {
+	for _, t := range e.Tags {
+		if canonicalTag(t) == key {
+			return t
+		}
+	}
+	return ""
+}
+
+// matchedAnyTag returns the form in which the post wrote any of the given
+// canonical keys, or "" if it uses none of them.
+func (e indexedPost) matchedAnyTag(keys []string) string {
+	for _, key := range keys {
+		if t := e.matchedTag(key); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+// tagStats are the counters kept for a tag within one channel, keyed by its
+// canonical form. Months holds the number of posts per month log so readers
+// only open the logs that matter, and Variants the number of posts per casing.
+type tagStats struct {
+	Count    int            `json:"count"`
+	CreateAt int64          `json:"create_at"`
+	LastUsed int64          `json:"last_used"`
+	Months   map[string]int `json:"months"`
+	Variants map[string]int `json:"variants"`
+}
+
+// postEngagement is the reactions and replies of an indexed post, kept apart
+// from its log row so that they change without rewriting the log.
+type postEngagement struct {
+	Reactions int `json:"reactions,omitempty"`
+	Replies   int `json:"replies,omitempty"`
+}
+
+// monthLog lists the days of a month that have a log, newest first, and the
+// engagement of the posts of the month that received any.
+type monthLog struct {
+	Days       []string                  `json:"days"`
+	Engagement map[string]postEngagement `json:"engagement,omitempty"`
+}
+
+// channelIndex summarises the tags of one channel. Indexed is only set once the
+// channel's history has been loaded; until then readers fall back to a scan.
+// Series is set once the series counters cover the whole history too. Posts
+// is the number of tagged posts.
+type channelIndex struct {
+	ChannelID string               `json:"channel_id"`
+	TeamID    string               `json:"team_id"`
+	Indexed   bool                 `json:"indexed"`
+	Tags      map[string]*tagStats `json:"tags"`
+	Months    []string             `json:"months"`
+	Posts     int                  `json:"posts"`
+	Series    bool                 `json:"series"`
+}
+
+func indexMonth(createAt int64) string {
+	return time.UnixMilli(createAt).UTC().Format(indexMonthLayout)
+}
+
+func indexDay(createAt int64) string {
+	return time.UnixMilli(createAt).UTC().Format(indexDayLayout)
+}
+
+func indexMonthKey(channelID, month string) string {
+	return indexMonthKeyPrefix + channelID + "_" + month
+}
+
+// indexLogKey returns the key of the log of one day. Logs saved before they
+// were split by day are keyed by month instead.
+func indexLogKey(channelID, period string) string {
+	return indexLogKeyPrefix + channelID + "_" + period
+}
+
+// sortedMonths returns the months a tag was used in, newest first.
+func (s *tagStats) sortedMonths() []string {
+	months := make([]string, 0, len(s.Months))
+	for m := range s.Months {
+		months = append(months, m)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(months)))
+	return months
+}
+
+func (p *Plugin) kvGetJSON(key string, v interface{}) (bool, error) {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return false, fmt.Errorf("failed to get %s: %w", key, appErr)
+	}
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (p *Plugin) kvSetJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	if appErr := p.API.KVSet(key, data); appErr != nil {
+		return fmt.Errorf("failed to set %s: %w", key, appErr)
+	}
+	return nil
+}
+
+// getChannelIndex returns the index summary of a channel, or nil if nothing
+// has been indexed for it yet.
+func (p *Plugin) getChannelIndex(channelID string) (*channelIndex, error) {
+	var idx channelIndex
+	found, err := p.kvGetJSON(indexChannelKeyPrefix+channelID, &idx)
+	if err != nil || !found {
+		return nil, err
+	}
+	if idx.Tags == nil {
+		idx.Tags = map[string]*tagStats{}
+	}
+	// Summaries saved by older versions may lack the per-tag maps
+	for _, stats := range idx.Tags {
+		if stats.Months == nil {
+			stats.Months = map[string]int{}
+		}
+		if stats.Variants == nil {
+			stats.Variants = map[string]int{}
+		}
+	}
+	return &idx, nil
+}
+
+// resetChannelIndex deletes the summary, logs and series counters of a
+// channel, so that it reads as unindexed until the backfill has crawled it
+// again. Entries in idx_post_ are left behind and replaced as the crawl reaches
+// their posts.
+func (p *Plugin) resetChannelIndex(channelID string) error {
+	mutex, err := cluster.NewMutex(p.API, indexLockKeyPrefix+channelID)
+	if err != nil {
+		return fmt.Errorf("failed to create index lock: %w", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil || idx == nil {
+		return err
+	}
+	keys := make([]string, 0, len(idx.Months)+len(idx.Tags)+1)
+	for _, month := range idx.Months {
+		ml, err := p.getMonthLog(channelID, month)
+		if err != nil {
+			return err
+		}
+		for _, day := range ml.Days {
+			keys = append(keys, indexLogKey(channelID, day))
+		}
+		keys = append(keys, indexMonthKey(channelID, month), indexLogKey(channelID, month))
+	}
+	for key, stats := range idx.Tags {
+		for month := range stats.Months {
+			keys = append(keys, indexSeriesKey(channelID, key, month))
+		}
+		// Counters saved before they were split by month
+		keys = append(keys, strings.TrimSuffix(indexSeriesKey(channelID, key, ""), "_"))
+	}
+	keys = append(keys, indexChannelKeyPrefix+channelID)
+	for _, key := range keys {
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, appErr)
+		}
+	}
+	return nil
+}
+
+// channelPostCount returns the number of tagged posts in an index. Indexes
+// saved before Posts was kept have months but no count, and are counted from
+// their month logs.
+func (p *Plugin) channelPostCount(idx *channelIndex) (int, error) {
+	if idx.Posts > 0 || len(idx.Months) == 0 {
+		return idx.Posts, nil
+	}
+	total := 0
+	for _, month := range idx.Months {
+		entries, err := p.getChannelLog(idx.ChannelID, month)
+		if err != nil {
+			return 0, err
+		}
+		total += len(entries)
+	}
+	return total, nil
+}
+
+// getMonthLog returns the monthLog of a channel for one month, empty if
+// nothing was logged that month.
+func (p *Plugin) getMonthLog(channelID, month string) (*monthLog, error) {
+	ml := &monthLog{}
+	if _, err := p.kvGetJSON(indexMonthKey(channelID, month), ml); err != nil {
+		return nil, err
+	}
+	if ml.Engagement == nil {
+		ml.Engagement = map[string]postEngagement{}
+	}
+	return ml, nil
+}
+
+// getDayLog returns the tagged posts of a channel for one day, newest first,
+// without their engagement.
+func (p *Plugin) getDayLog(channelID, day string) ([]indexedPost, error) {
+	var entries []indexedPost
+	if _, err := p.kvGetJSON(indexLogKey(channelID, day), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// getChannelLog returns the tagged posts of a channel for one month, newest
+// first, with their engagement.
+func (p *Plugin) getChannelLog(channelID, month string) ([]indexedPost, error) {
+	var ml monthLog
+	found, err := p.kvGetJSON(indexMonthKey(channelID, month), &ml)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// A log saved before logs were split by day, until the backfill
+		// re-indexes the channel
+		return p.getDayLog(channelID, month)
+	}
+	var entries []indexedPost
+	for _, day := range ml.Days {
+		logged, err := p.getDayLog(channelID, day)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, logged...)
+	}
+	for i := range entries {
+		if e, ok := ml.Engagement[entries[i].ID]; ok {
+			entries[i].Reactions, entries[i].Replies = e.Reactions, e.Replies
+		}
+	}
+	return entries, nil
+}
+
+// indexBatch collects changes to the index of one channel while its lock is
+// held, so a batch of posts costs one read and one write per touched key.
+type indexBatch struct {
+	p             *Plugin
+	idx           *channelIndex
+	months        map[string]*monthLog
+	days          map[string][]indexedPost
+	dirtyMonths   map[string]bool
+	dirtyDays     map[string]bool
+	seriesByMonth map[tagMonth]*tagSeries
+}
+
+// withChannelIndex runs fn against the index of a channel under the channel's
+// cluster lock and saves whatever fn changed.
+func (p *Plugin) withChannelIndex(channelID string, fn func(b *indexBatch) error) error {
+	mutex, err := p.channelIndexMutex(channelID)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil {
+		return err
+	}
+	if idx == nil {
+		channel, appErr := p.API.GetChannel(channelID)
+		if appErr != nil {
+			return fmt.Errorf("failed to get channel: %w", appErr)
+		}
+		idx = &channelIndex{
+			ChannelID: channelID,
+			TeamID:    channel.TeamId,
+			Tags:      map[string]*tagStats{},
+		}
+		// The backfill still has to mark the channel indexed
+		if _, err := p.registerBackfillChannels(channelID); err != nil {
+			return err
+		}
+	}
+	if idx.Posts, err = p.channelPostCount(idx); err != nil {
+		return err
+	}
+
+	b := &indexBatch{
+		p:             p,
+		idx:           idx,
+		months:        map[string]*monthLog{},
+		days:          map[string][]indexedPost{},
+		dirtyMonths:   map[string]bool{},
+		dirtyDays:     map[string]bool{},
+		seriesByMonth: map[tagMonth]*tagSeries{},
+	}
+	if err := fn(b); err != nil {
+		return err
+	}
+	return b.commit()
+}
+
+// channelIndexMutex returns the cluster lock guarding the index of a channel.
+func (p *Plugin) channelIndexMutex(channelID string) (*cluster.Mutex, error) {
+	mutex, err := cluster.NewMutex(p.API, indexLockKeyPrefix+channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index lock: %w", err)
+	}
+	return mutex, nil
+}
+
+func (b *indexBatch) monthLog(month string) (*monthLog, error) {
+	if ml, ok := b.months[month]; ok {
+		return ml, nil
+	}
+	ml, err := b.p.getMonthLog(b.idx.ChannelID, month)
+	if err != nil {
+		return nil, err
+	}
+	b.months[month] = ml
+	return ml, nil
+}
+
+func (b *indexBatch) dayLog(day string) ([]indexedPost, error) {
+	if entries, ok := b.days[day]; ok {
+		return entries, nil
+	}
+	entries, err := b.p.getDayLog(b.idx.ChannelID, day)
+	if err != nil {
+		return nil, err
+	}
+	b.days[day] = entries
+	return entries, nil
+}
+
+// addDay records that a day of the month has a log, keeping Days newest first.
+func (ml *monthLog) addDay(day string) {
+	i := sort.Search(len(ml.Days), func(i int) bool { return ml.Days[i] <= day })
+	if i < len(ml.Days) && ml.Days[i] == day {
+		return
+	}
+	ml.Days = append(ml.Days, "")
+	copy(ml.Days[i+1:], ml.Days[i:])
+	ml.Days[i] = day
+}
+
+func (ml *monthLog) removeDay(day string) {
+	for i := range ml.Days {
+		if ml.Days[i] == day {
+			ml.Days = append(ml.Days[:i], ml.Days[i+1:]...)
+			return
+		}
+	}
+}
+
+// contains reports whether a post is already indexed. The day log of a post
+// never changes, so this avoids a read of idx_post_ for posts seen the first time.
+func (b *indexBatch) contains(entry indexedPost) (bool, error) {
+	entries, err := b.dayLog(indexDay(entry.CreateAt))
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.ID == entry.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// put records entry in the index, replacing whatever was indexed for the same
+// post before. An entry without tags only removes the old one.
+func (b *indexBatch) put(entry indexedPost) error {
+	indexed, err := b.contains(entry)
+	if err != nil {
+		return err
+	}
+	if indexed {
+		if err := b.drop(entry.ID); err != nil {
+			return err
+		}
+	}
+	if len(entry.Tags) == 0 {
+		return nil
+	}
+
+	day, month := indexDay(entry.CreateAt), indexMonth(entry.CreateAt)
+	entries, err := b.dayLog(day)
+	if err != nil {
+		return err
+	}
+	ml, err := b.monthLog(month)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].CreateAt < entry.CreateAt ||
+			(entries[i].CreateAt == entry.CreateAt && entries[i].ID < entry.ID)
+	})
+	row := entry
+	row.UpdateAt, row.Reactions, row.Replies = 0, 0, 0
+	entries = append(entries, indexedPost{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = row
+	b.days[day] = entries
+	b.dirtyDays[day] = true
+	if len(entries) == 1 {
+		ml.addDay(day)
+		b.dirtyMonths[month] = true
+	}
+	if entry.Reactions > 0 || entry.Replies > 0 {
+		ml.Engagement[entry.ID] = postEngagement{Reactions: entry.Reactions, Replies: entry.Replies}
+		b.dirtyMonths[month] = true
+	}
+	b.idx.Posts++
+
+	for _, tag := range entry.Tags {
+		key := canonicalTag(tag)
+		stats, ok := b.idx.Tags[key]
+		if !ok {
+			stats = &tagStats{
+				CreateAt: entry.CreateAt,
+				LastUsed: entry.CreateAt,
+				Months:   map[string]int{},
+				Variants: map[string]int{},
+			}
+			b.idx.Tags[key] = stats
+		}
+		stats.Count++
+		stats.Months[month]++
+		stats.Variants[tag]++
+		if entry.CreateAt > stats.LastUsed {
+			stats.LastUsed = entry.CreateAt
+		}
+		if entry.CreateAt < stats.CreateAt {
+			stats.CreateAt = entry.CreateAt
+		}
+	}
+	if err := b.addSeries(entry); err != nil {
+		return err
+	}
+
+	return b.p.kvSetJSON(indexPostKeyPrefix+entry.ID, entry)
+}
+
+// drop removes whatever is indexed for a post. Unknown posts are ignored, as
+// are entries left over from before the channel was reset, which are no
+// longer in its logs or counters, and the entries marking unindexed posts.
+func (b *indexBatch) drop(postID string) error {
+	var old indexedPost
+	found, err := b.p.kvGetJSON(indexPostKeyPrefix+postID, &old)
+	if err != nil || !found {
+		return err
+	}
+
+	day, month := indexDay(old.CreateAt), indexMonth(old.CreateAt)
+	entries, err := b.dayLog(day)
+	if err != nil {
+		return err
+	}
+	logged := false
+	for i := range entries {
+		if entries[i].ID == postID {
+			entries = append(entries[:i], entries[i+1:]...)
+			b.idx.Posts--
+			logged = true
+			break
+		}
+	}
+	if !logged {
+		if appErr := b.p.API.KVDelete(indexPostKeyPrefix + postID); appErr != nil {
+			return fmt.Errorf("failed to delete indexed post: %w", appErr)
+		}
+		return nil
+	}
+	b.days[day] = entries
+	b.dirtyDays[day] = true
+	ml, err := b.monthLog(month)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		ml.removeDay(day)
+		b.dirtyMonths[month] = true
+	}
+	if _, ok := ml.Engagement[postID]; ok {
+		delete(ml.Engagement, postID)
+		b.dirtyMonths[month] = true
+	}
+	if err := b.removeSeries(old); err != nil {
+		return err
+	}
+
+	for _, tag := range old.Tags {
+		key := canonicalTag(tag)
+		stats, ok := b.idx.Tags[key]
+		if !ok {
+			continue
+		}
+		stats.Count--
+		stats.Months[month]--
+		if stats.Months[month] <= 0 {
+			delete(stats.Months, month)
+		}
+		stats.Variants[tag]--
+		if stats.Variants[tag] <= 0 {
+			delete(stats.Variants, tag)
+		}
+		if stats.Count <= 0 || len(stats.Months) == 0 {
+			delete(b.idx.Tags, key)
+			continue
+		}
+		if old.CreateAt == stats.CreateAt || old.CreateAt == stats.LastUsed {
+			if err := b.refreshBounds(key, stats); err != nil {
+				return err
+			}
+		}
+	}
+
+	if appErr := b.p.API.KVDelete(indexPostKeyPrefix + postID); appErr != nil {
+		return fmt.Errorf("failed to delete indexed post: %w", appErr)
+	}
+	return nil
+}
+
+// refreshBounds recomputes the first and last use of a tag after a post using
+// it went away, reading only the oldest and newest months it appears in, day
+// by day from the end that matters.
+func (b *indexBatch) refreshBounds(key string, stats *tagStats) error {
+	months := stats.sortedMonths()
+
+	newest, err := b.monthLog(months[0])
+	if err != nil {
+		return err
+	}
+newestDays:
+	for _, day := range newest.Days {
+		entries, err := b.dayLog(day)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.hasTag(key) {
+				stats.LastUsed = e.CreateAt
+				break newestDays
+			}
+		}
+	}
+
+	oldest, err := b.monthLog(months[len(months)-1])
+	if err != nil {
+		return err
+	}
+	for i := len(oldest.Days) - 1; i >= 0; i-- {
+		entries, err := b.dayLog(oldest.Days[i])
+		if err != nil {
+			return err
+		}
+		for j := len(entries) - 1; j >= 0; j-- {
+			if entries[j].hasTag(key) {
+				stats.CreateAt = entries[j].CreateAt
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (b *indexBatch) commit() error {
+	if err := b.commitSeries(); err != nil {
+		return err
+	}
+	for day := range b.dirtyDays {
+		entries := b.days[day]
+		key := indexLogKey(b.idx.ChannelID, day)
+		if len(entries) == 0 {
+			if appErr := b.p.API.KVDelete(key); appErr != nil {
+				return fmt.Errorf("failed to delete %s: %w", key, appErr)
+			}
+			continue
+		}
+		if err := b.p.kvSetJSON(key, entries); err != nil {
+			return err
+		}
+	}
+	for month := range b.dirtyMonths {
+		ml := b.months[month]
+		key := indexMonthKey(b.idx.ChannelID, month)
+		if len(ml.Days) == 0 {
+			if appErr := b.p.API.KVDelete(key); appErr != nil {
+				return fmt.Errorf("failed to delete %s: %w", key, appErr)
+			}
+			continue
+		}
+		if err := b.p.kvSetJSON(key, ml); err != nil {
+			return err
+		}
+	}
+
+	months := map[string]bool{}
+	for _, m := range b.idx.Months {
+		months[m] = true
+	}
+	for month := range b.dirtyMonths {
+		months[month] = len(b.months[month].Days) > 0
+	}
+	b.idx.Months = b.idx.Months[:0]
+	for m, ok := range months {
+		if ok {
+			b.idx.Months = append(b.idx.Months, m)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(b.idx.Months)))
+
+	return b.p.kvSetJSON(indexChannelKeyPrefix+b.idx.ChannelID, b.idx)
+}
+
+// indexEntryForPost builds the index entry for a post. Posts the plugin does
+// not count (system messages, deleted posts and bot posts) get no tags, so
+// putting them only removes a previous entry.
+func (p *Plugin) indexEntryForPost(post *model.Post, users map[string]*model.User) indexedPost {
+	entry := indexedPost{
+		ID:        post.Id,
+		ChannelID: post.ChannelId,
+		UserID:    post.UserId,
+		CreateAt:  post.CreateAt,
+		UpdateAt:  post.UpdateAt,
+		Tags:      p.postHashtags(post, users),
+	}
+	if len(entry.Tags) == 0 {
+		return entry
+	}
+	entry.Reactions = p.reactionCount(post)
+	entry.Replies = int(post.ReplyCount)
+	return entry
+}
+
+// postHashtags returns the tags the plugin counts for a post: none for system
+// messages, deleted posts and bot posts.
+func (p *Plugin) postHashtags(post *model.Post, users map[string]*model.User) []string {
+	if post.Type != "" || post.DeleteAt != 0 {
+		return nil
+	}
+	tags := extractHashtags(post.Message, p.getConfiguration().extractOptions())
+	if len(tags) == 0 {
+		return nil
+	}
+	user := p.cachedUser(users, post.UserId)
+	if user == nil || user.IsBot {
+		return nil
+	}
+	return tags
+}
+
+// reactionCount returns the number of reactions to a post. Posts without
+// metadata only have their reactions loaded when they have any.
+func (p *Plugin) reactionCount(post *model.Post) int {
+	if post.Metadata != nil && len(post.Metadata.Reactions) > 0 {
+		return len(post.Metadata.Reactions)
+	}
+	if !post.HasReactions {
+		return 0
+	}
+	reactions, appErr := p.API.GetReactions(post.Id)
+	if appErr != nil {
+		p.API.LogError("Failed to get reactions", "error", appErr.Error(), "post_id", post.Id)
+		return 0
+	}
+	return len(reactions)
+}
+
+// indexPost adds or refreshes a single post in the index, given its entry
+// from indexEntryForPost.
+func (p *Plugin) indexPost(post *model.Post, entry indexedPost) error {
+	if len(entry.Tags) == 0 {
+		return p.unindexPost(post)
+	}
+	return p.withChannelIndex(post.ChannelId, func(b *indexBatch) error {
+		return b.put(entry)
+	})
+}
+
+// refreshIndexedPost updates the reaction and reply counts of an indexed post
+// after they changed, in its entry and its monthLog. Its tags, its day log,
+// the channel summary and the series counters are left alone, so the post is
+// not extracted again. Posts that are not indexed cost a single KV read.
+func (p *Plugin) refreshIndexedPost(postID string) error {
+	var entry indexedPost
+	found, err := p.kvGetJSON(indexPostKeyPrefix+postID, &entry)
+	if err != nil || !found {
+		return err
+	}
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return fmt.Errorf("failed to get post: %w", appErr)
+	}
+	reactions, replies := p.reactionCount(post), int(post.ReplyCount)
+	if reactions == entry.Reactions && replies == entry.Replies {
+		return nil
+	}
+
+	mutex, err := p.channelIndexMutex(entry.ChannelID)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// The post may have been edited or deleted while the lock was taken
+	found, err = p.kvGetJSON(indexPostKeyPrefix+postID, &entry)
+	if err != nil || !found {
+		return err
+	}
+	entries, err := p.getDayLog(entry.ChannelID, indexDay(entry.CreateAt))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.ID != postID {
+			continue
+		}
+		month := indexMonth(entry.CreateAt)
+		ml, err := p.getMonthLog(entry.ChannelID, month)
+		if err != nil {
+			return err
+		}
+		if reactions > 0 || replies > 0 {
+			ml.Engagement[postID] = postEngagement{Reactions: reactions, Replies: replies}
+		} else {
+			delete(ml.Engagement, postID)
+		}
+		if err := p.kvSetJSON(indexMonthKey(entry.ChannelID, month), ml); err != nil {
+			return err
+		}
+		entry.Reactions, entry.Replies = reactions, replies
+		return p.kvSetJSON(indexPostKeyPrefix+postID, entry)
+	}
+	// Entries left over from before the channel was reset are not logged
+	return nil
+}
+
+// unindexPost removes a single post from the index. Its entry is replaced by
+// one without tags recording when the post was edited or deleted, so that a
+// backfill page read before cannot index it again. Posts that were never
+// indexed cost a single KV read and leave the channel untouched, unless they
+// were deleted with tags the backfill may not have reached yet.
+func (p *Plugin) unindexPost(post *model.Post) error {
+	data, appErr := p.API.KVGet(indexPostKeyPrefix + post.Id)
+	if appErr != nil {
+		return fmt.Errorf("failed to get indexed post: %w", appErr)
+	}
+	if data == nil && (post.DeleteAt == 0 || len(extractHashtags(post.Message, p.getConfiguration().extractOptions())) == 0) {
+		return nil
+	}
+	return p.withChannelIndex(post.ChannelId, func(b *indexBatch) error {
+		if err := b.drop(post.Id); err != nil {
+			return err
+		}
+		return b.p.kvSetJSON(indexPostKeyPrefix+post.Id, indexedPost{
+			ID:        post.Id,
+			ChannelID: post.ChannelId,
+			CreateAt:  post.CreateAt,
+			UpdateAt:  post.UpdateAt,
+			DeleteAt:  post.DeleteAt,
+		})
+	})
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexBatchPutDrop(t *testing.T) {
+	april := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+	may := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+	june := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	a1 := indexedPost{ID: "a1", ChannelID: "channel1", UserID: "alice", CreateAt: april, Tags: []string{"Release"}}
+	b1 := indexedPost{ID: "b1", ChannelID: "channel1", UserID: "bob", CreateAt: may, Tags: []string{"release", "alpha"}}
+	c1 := indexedPost{ID: "c1", ChannelID: "channel1", UserID: "alice", CreateAt: june, Tags: []string{"release"}}
+	c2 := indexedPost{ID: "c2", ChannelID: "channel1", UserID: "bob", CreateAt: june, Tags: []string{"alpha"}}
+	edited := indexedPost{ID: "b1", ChannelID: "channel1", UserID: "bob", CreateAt: may, Tags: []string{"Beta"}}
+
+	put := func(e indexedPost) func(b *indexBatch) error {
+		return func(b *indexBatch) error { return b.put(e) }
+	}
+	drop := func(id string) func(b *indexBatch) error {
+		return func(b *indexBatch) error { return b.drop(id) }
+	}
+
+	tests := []struct {
+		name  string
+		steps []func(b *indexBatch) error
+		tags  map[string]*tagStats
+		logs  map[string][]string
+	}{
+		{
+			name:  "dropping every post leaves nothing behind",
+			steps: []func(b *indexBatch) error{put(a1), put(b1), put(c1), drop("c1"), drop("a1"), drop("b1")},
+			tags:  map[string]*tagStats{},
+			logs:  map[string][]string{},
+		},
+		{
+			name:  "dropping the newest use moves LastUsed back",
+			steps: []func(b *indexBatch) error{put(a1), put(b1), put(c1), drop("c1")},
+			tags: map[string]*tagStats{
+				"release": {Count: 2, CreateAt: april, LastUsed: may, Months: map[string]int{"202404": 1, "202405": 1}, Variants: map[string]int{"Release": 1, "release": 1}},
+				"alpha":   {Count: 1, CreateAt: may, LastUsed: may, Months: map[string]int{"202405": 1}, Variants: map[string]int{"alpha": 1}},
+			},
+			logs: map[string][]string{"202404": {"a1"}, "202405": {"b1"}},
+		},
+		{
+			name:  "dropping the oldest use moves CreateAt forward",
+			steps: []func(b *indexBatch) error{put(a1), put(b1), put(c1), drop("a1")},
+			tags: map[string]*tagStats{
+				"release": {Count: 2, CreateAt: may, LastUsed: june, Months: map[string]int{"202405": 1, "202406": 1}, Variants: map[string]int{"release": 2}},
+				"alpha":   {Count: 1, CreateAt: may, LastUsed: may, Months: map[string]int{"202405": 1}, Variants: map[string]int{"alpha": 1}},
+			},
+			logs: map[string][]string{"202405": {"b1"}, "202406": {"c1"}},
+		},
+		{
+			name:  "an edited post is re-indexed with its new tags",
+			steps: []func(b *indexBatch) error{put(b1), put(edited)},
+			tags: map[string]*tagStats{
+				"beta": {Count: 1, CreateAt: may, LastUsed: may, Months: map[string]int{"202405": 1}, Variants: map[string]int{"Beta": 1}},
+			},
+			logs: map[string][]string{"202405": {"b1"}},
+		},
+		{
+			name:  "month logs are newest first with ties broken by id",
+			steps: []func(b *indexBatch) error{put(c1), put(a1), put(c2), put(b1)},
+			tags: map[string]*tagStats{
+				"release": {Count: 3, CreateAt: april, LastUsed: june, Months: map[string]int{"202404": 1, "202405": 1, "202406": 1}, Variants: map[string]int{"Release": 1, "release": 2}},
+				"alpha":   {Count: 2, CreateAt: may, LastUsed: june, Months: map[string]int{"202405": 1, "202406": 1}, Variants: map[string]int{"alpha": 2}},
+			},
+			logs: map[string][]string{"202404": {"a1"}, "202405": {"b1"}, "202406": {"c2", "c1"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			// Each step runs in its own batch, as separate hooks would
+			for _, step := range tc.steps {
+				require.NoError(t, p.withChannelIndex("channel1", step))
+			}
+
+			idx, err := p.getChannelIndex("channel1")
+			require.NoError(t, err)
+			assert.Equal(t, tc.tags, idx.Tags)
+
+			logs := map[string][]string{}
+			posts := 0
+			for _, month := range idx.Months {
+				entries, err := p.getChannelLog("channel1", month)
+				require.NoError(t, err)
+				for _, e := range entries {
+					logs[month] = append(logs[month], e.ID)
+				}
+				posts += len(entries)
+			}
+			assert.Equal(t, tc.logs, logs)
+			assert.Equal(t, posts, idx.Posts)
+
+			if len(tc.tags) == 0 {
+				for key := range api.kv {
+					assert.False(t, strings.HasPrefix(key, indexPostKeyPrefix), key)
+					assert.False(t, strings.HasPrefix(key, indexLogKeyPrefix), key)
+					assert.False(t, strings.HasPrefix(key, indexMonthKeyPrefix), key)
+					assert.False(t, strings.HasPrefix(key, indexSeriesKeyPrefix), key)
+				}
+			}
+		})
+	}
+}
+
+// TestIndexOlderVersions covers summaries and entries saved by versions with
+// other rules: tag stats without maps, and posts keyed by their exact casing.
+func TestIndexOlderVersions(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	may := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+	old := indexedPost{ID: "p1", ChannelID: "channel1", UserID: "alice", CreateAt: may, Tags: []string{"Release", "release"}}
+	require.NoError(t, p.kvSetJSON(indexPostKeyPrefix+"p1", old))
+	require.NoError(t, p.kvSetJSON(indexLogKey("channel1", "202405"), []indexedPost{old}))
+	require.NoError(t, p.kvSetJSON(indexSeriesKey("channel1", "Release", "202405"), &tagSeries{}))
+	require.NoError(t, p.kvSetJSON(strings.TrimSuffix(indexSeriesKey("channel1", "release", ""), "_"), &tagSeries{}))
+	require.NoError(t, p.kvSetJSON(indexChannelKeyPrefix+"channel1", &channelIndex{
+		ChannelID: "channel1",
+		Indexed:   true,
+		Tags: map[string]*tagStats{
+			"Release": {Count: 1, CreateAt: may, LastUsed: may, Months: map[string]int{"202405": 1}},
+			"release": {Count: 1, CreateAt: may, LastUsed: may},
+		},
+		Months: []string{"202405"},
+		Posts:  1,
+	}))
+
+	// Putting a post into an old summary must not panic on the missing maps
+	next := indexedPost{ID: "p2", ChannelID: "channel1", UserID: "bob", CreateAt: may + 1, Tags: []string{"Release"}}
+	require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error {
+		return b.put(next)
+	}))
+
+	require.NoError(t, p.resetChannelIndex("channel1"))
+	idx, err := p.getChannelIndex("channel1")
+	require.NoError(t, err)
+	assert.Nil(t, idx)
+	for key := range api.kv {
+		assert.False(t, strings.HasPrefix(key, indexLogKeyPrefix), key)
+		assert.False(t, strings.HasPrefix(key, indexMonthKeyPrefix), key)
+		assert.False(t, strings.HasPrefix(key, indexSeriesKeyPrefix), key)
+	}
+
+	// Once re-indexed, dropping a post whose entry predates the reset leaves
+	// the new counters alone
+	require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error {
+		return b.put(next)
+	}))
+	require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error {
+		return b.drop("p1")
+	}))
+	idx, err = p.getChannelIndex("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, idx.Posts)
+	assert.Equal(t, 1, idx.Tags["release"].Count)
+	assert.NotContains(t, api.kv, indexPostKeyPrefix+"p1")
+}
+
+// TestRefreshIndexedPost checks that reactions and replies only touch the
+// post's entry and its monthLog.
+func TestRefreshIndexedPost(t *testing.T) {
+	api := newTestAPI()
+	api.corpusAPI = newMessagesAPI([]string{"#release"})
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	post := api.posts[api.order[1]]
+	require.NoError(t, p.indexPost(post, p.indexEntryForPost(post, map[string]*model.User{})))
+	saved := map[string]string{}
+	for key, value := range api.kv {
+		if strings.HasPrefix(key, indexChannelKeyPrefix) || strings.HasPrefix(key, indexLogKeyPrefix) ||
+			strings.HasPrefix(key, indexSeriesKeyPrefix) {
+			saved[key] = string(value)
+		}
+	}
+	require.NotEmpty(t, saved)
+
+	// A changed message shows the tags are not extracted again
+	post.Message = "#other"
+	post.ReplyCount = 2
+	post.Metadata = &model.PostMetadata{Reactions: []*model.Reaction{{}, {}, {}}}
+	require.NoError(t, p.refreshIndexedPost(post.Id))
+
+	var entry indexedPost
+	_, err := p.kvGetJSON(indexPostKeyPrefix+post.Id, &entry)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"release"}, entry.Tags)
+	assert.Equal(t, 3, entry.Reactions)
+	assert.Equal(t, 2, entry.Replies)
+
+	entries, err := p.getChannelLog("channel1", indexMonth(post.CreateAt))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, entry, entries[0])
+
+	for key, value := range saved {
+		assert.Equal(t, value, string(api.kv[key]), key)
+	}
+
+	// Posts that are not indexed are ignored
+	require.NoError(t, p.refreshIndexedPost(api.order[0]))
+}
+
+// TestIndexDayLogs checks that a post only rewrites the log of its own day.
+func TestIndexDayLogs(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	tenth := time.Date(2024, 4, 10, 12, 0, 0, 0, time.UTC).UnixMilli()
+	eleventh := time.Date(2024, 4, 11, 12, 0, 0, 0, time.UTC).UnixMilli()
+	put := func(e indexedPost) {
+		require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error { return b.put(e) }))
+	}
+	put(indexedPost{ID: "a1", ChannelID: "channel1", UserID: "user1", CreateAt: tenth, Tags: []string{"release"}})
+	put(indexedPost{ID: "a2", ChannelID: "channel1", UserID: "user1", CreateAt: eleventh, Tags: []string{"release"}})
+	tenthLog := string(api.kv[indexLogKey("channel1", "20240410")])
+	require.NotEmpty(t, tenthLog)
+
+	put(indexedPost{ID: "a3", ChannelID: "channel1", UserID: "user1", CreateAt: eleventh + 1, Tags: []string{"alpha"}, Reactions: 2})
+	assert.Equal(t, tenthLog, string(api.kv[indexLogKey("channel1", "20240410")]))
+	assert.NotContains(t, string(api.kv[indexLogKey("channel1", "20240411")]), "reactions")
+
+	ml, err := p.getMonthLog("channel1", "202404")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240411", "20240410"}, ml.Days)
+	assert.Equal(t, map[string]postEngagement{"a3": {Reactions: 2}}, ml.Engagement)
+
+	entries, err := p.getChannelLog("channel1", "202404")
+	require.NoError(t, err)
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	assert.Equal(t, []string{"a3", "a2", "a1"}, ids)
+	assert.Equal(t, 2, entries[0].Reactions)
+
+	// Emptying a day deletes its log and takes it out of the month
+	require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error { return b.drop("a1") }))
+	assert.NotContains(t, api.kv, indexLogKey("channel1", "20240410"))
+	ml, err = p.getMonthLog("channel1", "202404")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240411"}, ml.Days)
+	idx, err := p.getChannelIndex("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, eleventh, idx.Tags["release"].CreateAt)
+}
+
+func TestIndexPost(t *testing.T) {
+	april := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+	may := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	post := func(id, userID string, createAt int64, message string) *model.Post {
+		return &model.Post{Id: id, ChannelId: "channel1", UserId: userID, CreateAt: createAt, Message: message}
+	}
+	deleted := post("b1", "user1", may, "#release")
+	deleted.DeleteAt = may + 1
+
+	tests := []struct {
+		name   string
+		posts  []*model.Post
+		remove []*model.Post
+		counts map[string]int
+		logs   map[string][]string
+	}{
+		{
+			name:   "posts are counted per tag and logged per month",
+			posts:  []*model.Post{post("a1", "user1", april, "#release #alpha"), post("b1", "user2", may, "#release")},
+			counts: map[string]int{"release": 2, "alpha": 1},
+			logs:   map[string][]string{"202404": {"a1"}, "202405": {"b1"}},
+		},
+		{
+			name:   "an edit replaces the tags of a post",
+			posts:  []*model.Post{post("a1", "user1", april, "#release"), post("a1", "user1", april, "#beta")},
+			counts: map[string]int{"beta": 1},
+			logs:   map[string][]string{"202404": {"a1"}},
+		},
+		{
+			name:   "an edit removing every tag unindexes the post",
+			posts:  []*model.Post{post("a1", "user1", april, "#release"), post("a1", "user1", april, "no tags left")},
+			counts: map[string]int{},
+			logs:   map[string][]string{},
+		},
+		{
+			name:   "bot posts and deleted posts are not indexed",
+			posts:  []*model.Post{post("a1", "bot1", april, "#release"), post("b1", "user1", may, "#release"), deleted},
+			counts: map[string]int{},
+			logs:   map[string][]string{},
+		},
+		{
+			name:   "deleting a post removes it",
+			posts:  []*model.Post{post("a1", "user1", april, "#release"), post("b1", "user1", may, "#release")},
+			remove: []*model.Post{post("a1", "user1", april, "#release")},
+			counts: map[string]int{"release": 1},
+			logs:   map[string][]string{"202405": {"b1"}},
+		},
+		{
+			name:   "month logs are newest first",
+			posts:  []*model.Post{post("a1", "user1", april, "#release"), post("a2", "user1", april+1, "#release")},
+			counts: map[string]int{"release": 2},
+			logs:   map[string][]string{"202404": {"a2", "a1"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			for _, post := range tc.posts {
+				require.NoError(t, p.indexPost(post, p.indexEntryForPost(post, map[string]*model.User{})))
+			}
+			for _, post := range tc.remove {
+				require.NoError(t, p.unindexPost(post))
+			}
+
+			idx, err := p.getChannelIndex("channel1")
+			require.NoError(t, err)
+			require.NotNil(t, idx)
+			assert.Equal(t, "team1", idx.TeamID)
+
+			counts := map[string]int{}
+			for tag, stats := range idx.Tags {
+				counts[tag] = stats.Count
+			}
+			assert.Equal(t, tc.counts, counts)
+
+			logs := map[string][]string{}
+			for _, month := range idx.Months {
+				entries, err := p.getChannelLog("channel1", month)
+				require.NoError(t, err)
+				for _, e := range entries {
+					logs[month] = append(logs[month], e.ID)
+				}
+			}
+			assert.Equal(t, tc.logs, logs)
+		})
+	}
+}
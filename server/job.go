@@ -0,0 +1,436 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	backfillJobKey          = "HashtagBackfill"
+	backfillStatusKey       = "backfill_status"
+	backfillCursorKeyPrefix = "backfill_cursor_"
+	backfillChannelsKey     = "backfill_channels"
+	backfillChannelsLockKey = "backfill_channels_lock"
+	backfillSweptKey        = "backfill_swept"
+
+	// backfillChannelsPageSize is the number of channel ids kept under one
+	// key of backfill_channels.
+	backfillChannelsPageSize = 1000
+
+	backfillInterval  = 1 * time.Hour
+	backfillRecheck   = 1 * time.Minute
+	backfillPageSize  = 200
+	backfillPageDelay = 250 * time.Millisecond
+
+	// indexVersion is bumped whenever tag extraction or the indexed entries
+	// change, so that the backfill crawls every channel again and re-indexes it
+	// with the new rules.
+	indexVersion = 11
+)
+
+// indexRules identifies the extraction rules the index is built with. Changing
+// indexVersion or a setting that affects extraction restarts the backfill.
+func (p *Plugin) indexRules() string {
+	return fmt.Sprintf("v%d quotes=%t", indexVersion, p.getConfiguration().CountTagsInQuotes)
+}
+
+// backfillWait schedules the backfill every backfillInterval, and as soon as
+// the extraction rules differ from those of the last run so that a settings
+// change re-indexes every channel without waiting for the next run. Every node
+// sees the new settings through OnConfigurationChange, and the job lock makes
+// sure only one of them crawls.
+func (p *Plugin) backfillWait(now time.Time, metadata cluster.JobMetadata) time.Duration {
+	wait := cluster.MakeWaitForInterval(backfillInterval)(now, metadata)
+	if wait == 0 {
+		return 0
+	}
+	status, err := p.getBackfillStatus()
+	if err != nil {
+		p.API.LogError("Failed to get backfill status", "error", err.Error())
+		return wait
+	}
+	if status.Rules != "" && status.Rules != p.indexRules() {
+		return 0
+	}
+	return min(wait, backfillRecheck)
+}
+
+// backfillStatus is the progress report of the backfill job, saved after every
+// page so any node can answer the admin status endpoint.
+type backfillStatus struct {
+	Rules          string `json:"rules"`
+	Running        bool   `json:"running"`
+	StartedAt      int64  `json:"started_at"`
+	UpdatedAt      int64  `json:"updated_at"`
+	CompletedAt    int64  `json:"completed_at"`
+	ChannelsTotal  int    `json:"channels_total"`
+	ChannelsDone   int    `json:"channels_done"`
+	PostsScanned   int    `json:"posts_scanned"`
+	CurrentChannel string `json:"current_channel,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+}
+
+// backfillCursor is the checkpoint of one channel. The crawl walks from the
+// newest post backwards, so Before is the oldest post handled so far.
+type backfillCursor struct {
+	Rules  string `json:"rules"`
+	Before string `json:"before"`
+	Posts  int    `json:"posts"`
+	Done   bool   `json:"done"`
+}
+
+func (p *Plugin) getBackfillStatus() (*backfillStatus, error) {
+	var status backfillStatus
+	if _, err := p.kvGetJSON(backfillStatusKey, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (p *Plugin) saveBackfillStatus(status *backfillStatus) {
+	status.UpdatedAt = model.GetMillis()
+	if err := p.kvSetJSON(backfillStatusKey, status); err != nil {
+		p.API.LogError("Failed to save backfill status", "error", err.Error())
+	}
+}
+
+// stopping reports whether the plugin is being deactivated.
+func (p *Plugin) stopping() bool {
+	select {
+	case <-p.backfillStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// runBackfillJob crawls the history of every channel into the hashtag index.
+// It is scheduled through cluster.Schedule so only one node runs it at a time,
+// and it resumes from the saved cursors after a restart.
+func (p *Plugin) runBackfillJob() {
+	channelIDs, err := p.backfillChannelIDs()
+	if err != nil {
+		p.API.LogError("Failed to list channels for backfill", "error", err.Error())
+		return
+	}
+
+	status := &backfillStatus{
+		Rules:         p.indexRules(),
+		Running:       true,
+		StartedAt:     model.GetMillis(),
+		ChannelsTotal: len(channelIDs),
+	}
+	p.saveBackfillStatus(status)
+
+	for _, channelID := range channelIDs {
+		status.CurrentChannel = channelID
+		done, err := p.backfillChannel(channelID, status)
+		if err != nil {
+			p.API.LogError("Failed to backfill channel", "error", err.Error(), "channel_id", channelID)
+			status.LastError = err.Error()
+			continue
+		}
+		if !done {
+			p.API.LogInfo("Hashtag backfill interrupted, it will resume on the next run")
+			status.Running = false
+			p.saveBackfillStatus(status)
+			return
+		}
+		status.ChannelsDone++
+	}
+
+	status.Running = false
+	status.CurrentChannel = ""
+	status.CompletedAt = model.GetMillis()
+	p.saveBackfillStatus(status)
+	p.API.LogInfo("Hashtag backfill finished", "channels", status.ChannelsTotal, "posts", status.PostsScanned)
+}
+
+// backfillChannelIDs lists the channels to crawl. Public channels are listed
+// per team on every run. The plugin API has no call listing the private
+// channels of a team, so those and DMs and GMs are found through the members
+// of each team, once, and remembered in backfill_channels. Channels created
+// later are added there by the index when they get their first tagged post.
+func (p *Plugin) backfillChannelIDs() ([]string, error) {
+	var swept bool
+	if _, err := p.kvGetJSON(backfillSweptKey, &swept); err != nil {
+		return nil, err
+	}
+
+	teams, appErr := p.API.GetTeams()
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get teams: %w", appErr)
+	}
+	var found []string
+	for _, team := range teams {
+		for page := 0; ; page++ {
+			channels, appErr := p.API.GetPublicChannelsForTeam(team.Id, page, backfillPageSize)
+			if appErr != nil {
+				return nil, fmt.Errorf("failed to get channels: %w", appErr)
+			}
+			for _, channel := range channels {
+				found = append(found, channel.Id)
+			}
+			if len(channels) < backfillPageSize {
+				break
+			}
+		}
+		if !swept {
+			found = append(found, p.memberChannelIDs(team.Id)...)
+		}
+	}
+
+	channelIDs, err := p.registerBackfillChannels(found...)
+	if err != nil || swept {
+		return channelIDs, err
+	}
+	// Channels indexed by a hook before the first run are registered too,
+	// so the sweep is recorded apart from them
+	return channelIDs, p.kvSetJSON(backfillSweptKey, true)
+}
+
+// memberChannelIDs returns the channels the members of a team belong to,
+// their DMs and GMs included.
+func (p *Plugin) memberChannelIDs(teamID string) []string {
+	var channelIDs []string
+	for page := 0; ; page++ {
+		members, appErr := p.API.GetTeamMembers(teamID, page, backfillPageSize)
+		if appErr != nil {
+			p.API.LogWarn("Failed to get team members", "error", appErr.Error(), "team_id", teamID)
+			break
+		}
+		for _, member := range members {
+			if member.DeleteAt != 0 {
+				continue
+			}
+			channels, appErr := p.API.GetChannelsForTeamForUser(teamID, member.UserId, false)
+			if appErr != nil {
+				p.API.LogWarn("Failed to get channels for user", "error", appErr.Error(), "user_id", member.UserId)
+				continue
+			}
+			for _, channel := range channels {
+				channelIDs = append(channelIDs, channel.Id)
+			}
+		}
+		if len(members) < backfillPageSize {
+			break
+		}
+	}
+	return channelIDs
+}
+
+// backfillChannelsPageKey returns the key of one page of backfill_channels.
+// The first page keeps the key of the single list saved by older versions.
+func backfillChannelsPageKey(page int) string {
+	if page == 0 {
+		return backfillChannelsKey
+	}
+	return fmt.Sprintf("%s_%d", backfillChannelsKey, page)
+}
+
+// getBackfillChannelPages returns the pages of backfill_channels.
+func (p *Plugin) getBackfillChannelPages() ([][]string, error) {
+	var pages [][]string
+	for page := 0; ; page++ {
+		var channelIDs []string
+		found, err := p.kvGetJSON(backfillChannelsPageKey(page), &channelIDs)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return pages, nil
+		}
+		pages = append(pages, channelIDs)
+	}
+}
+
+// getBackfillChannels returns every channel in backfill_channels, sorted.
+func (p *Plugin) getBackfillChannels() ([]string, error) {
+	pages, err := p.getBackfillChannelPages()
+	if err != nil {
+		return nil, err
+	}
+	var channelIDs []string
+	for _, page := range pages {
+		channelIDs = append(channelIDs, page...)
+	}
+	sort.Strings(channelIDs)
+	return channelIDs, nil
+}
+
+// registerBackfillChannels adds channels to backfill_channels and returns
+// every channel in it, sorted. New channels fill up the last page, then go to
+// new pages of backfillChannelsPageSize, so only the pages that change are
+// written.
+func (p *Plugin) registerBackfillChannels(channelIDs ...string) ([]string, error) {
+	var registered []string
+	err := p.withLock(backfillChannelsLockKey, func() error {
+		pages, err := p.getBackfillChannelPages()
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, page := range pages {
+			for _, id := range page {
+				seen[id] = true
+			}
+		}
+		changed := map[int]bool{}
+		if len(pages) == 0 {
+			pages = [][]string{{}}
+		}
+		for _, id := range channelIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			last := len(pages) - 1
+			if len(pages[last]) >= backfillChannelsPageSize {
+				pages = append(pages, nil)
+				last++
+			}
+			pages[last] = append(pages[last], id)
+			changed[last] = true
+		}
+		for page := range changed {
+			if err := p.kvSetJSON(backfillChannelsPageKey(page), pages[page]); err != nil {
+				return err
+			}
+		}
+		for id := range seen {
+			registered = append(registered, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(registered)
+	return registered, nil
+}
+
+// backfillChannel crawls one channel page by page, checkpointing after each
+// page. It returns false if it was interrupted by the plugin stopping.
+func (p *Plugin) backfillChannel(channelID string, status *backfillStatus) (bool, error) {
+	key := backfillCursorKeyPrefix + channelID
+	var cursor backfillCursor
+	if _, err := p.kvGetJSON(key, &cursor); err != nil {
+		return false, err
+	}
+	if rules := p.indexRules(); cursor.Rules != rules {
+		// Entries indexed under other rules may be keyed or counted
+		// differently, so the channel starts over from an empty index
+		if err := p.resetChannelIndex(channelID); err != nil {
+			return false, err
+		}
+		cursor = backfillCursor{Rules: rules}
+		if err := p.kvSetJSON(key, cursor); err != nil {
+			return false, err
+		}
+	}
+	if cursor.Done {
+		return true, nil
+	}
+
+	users := map[string]*model.User{}
+	for {
+		if p.stopping() {
+			return false, nil
+		}
+
+		var posts *model.PostList
+		var appErr *model.AppError
+		if cursor.Before == "" {
+			posts, appErr = p.API.GetPostsForChannel(channelID, 0, backfillPageSize)
+		} else {
+			posts, appErr = p.API.GetPostsBefore(channelID, cursor.Before, 0, backfillPageSize)
+		}
+		if appErr != nil {
+			return false, fmt.Errorf("failed to get posts: %w", appErr)
+		}
+		if posts == nil || len(posts.Order) == 0 {
+			break
+		}
+
+		var entries []indexedPost
+		var oldest *model.Post
+		for _, id := range posts.Order {
+			post := posts.Posts[id]
+			if post == nil || post.ChannelId != channelID {
+				continue
+			}
+			if oldest == nil || post.CreateAt < oldest.CreateAt {
+				oldest = post
+			}
+			entries = append(entries, p.indexEntryForPost(post, users))
+		}
+		if oldest == nil {
+			break
+		}
+
+		err := p.withChannelIndex(channelID, func(b *indexBatch) error {
+			for _, entry := range entries {
+				if err := b.putCrawled(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+
+		cursor.Before = oldest.Id
+		cursor.Posts += len(entries)
+		if err := p.kvSetJSON(key, cursor); err != nil {
+			return false, err
+		}
+		status.PostsScanned += len(entries)
+		p.saveBackfillStatus(status)
+
+		select {
+		case <-p.backfillStop:
+			return false, nil
+		case <-time.After(backfillPageDelay):
+		}
+	}
+
+	err := p.withChannelIndex(channelID, func(b *indexBatch) error {
+		b.idx.Indexed = true
+		b.idx.Series = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	cursor.Done = true
+	return true, p.kvSetJSON(key, cursor)
+}
+
+// putCrawled puts an entry the backfill built before it took the channel lock,
+// unless a hook changed the post in between: the post was deleted, or edited
+// after the version the backfill read. Posts without tags that are not indexed
+// are skipped without reading their entry.
+func (b *indexBatch) putCrawled(entry indexedPost) error {
+	indexed, err := b.contains(entry)
+	if err != nil {
+		return err
+	}
+	if !indexed && len(entry.Tags) == 0 {
+		return nil
+	}
+	var stored indexedPost
+	found, err := b.p.kvGetJSON(indexPostKeyPrefix+entry.ID, &stored)
+	if err != nil {
+		return err
+	}
+	if found && (stored.DeleteAt != 0 || stored.UpdateAt > entry.UpdateAt) {
+		return nil
+	}
+	return b.put(entry)
+}
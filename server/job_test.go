@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillChannel(t *testing.T) {
+	tests := []struct {
+		name string
+		// cursor returns the saved cursor, given the ids of the posts
+		// tagged #one, #two and #three
+		cursor func(p *Plugin, ids []string) *backfillCursor
+		tags   []string
+		posts  int
+	}{
+		{
+			name:   "a new channel is crawled from the newest post",
+			cursor: func(p *Plugin, ids []string) *backfillCursor { return nil },
+			tags:   []string{"one", "three", "two"},
+			posts:  4,
+		},
+		{
+			name: "an interrupted crawl resumes before the saved post",
+			cursor: func(p *Plugin, ids []string) *backfillCursor {
+				return &backfillCursor{Rules: p.indexRules(), Before: ids[2], Posts: 2}
+			},
+			tags:  []string{"one", "stale", "two"},
+			posts: 4,
+		},
+		{
+			name: "a finished crawl is not repeated",
+			cursor: func(p *Plugin, ids []string) *backfillCursor {
+				return &backfillCursor{Rules: p.indexRules(), Done: true, Posts: 4}
+			},
+			tags:  []string{"stale"},
+			posts: 4,
+		},
+		{
+			name: "a crawl under other rules starts over",
+			cursor: func(p *Plugin, ids []string) *backfillCursor {
+				return &backfillCursor{Rules: "v0", Done: true, Posts: 4}
+			},
+			tags:  []string{"one", "three", "two"},
+			posts: 4,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newTestAPI()
+			api.corpusAPI = newMessagesAPI([]string{"#one", "#two", "#three"})
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			// An earlier index holding a tag no post uses any more
+			stale := indexedPost{ID: "gone", ChannelID: "channel1", UserID: "user1", CreateAt: 900, Tags: []string{"stale"}}
+			require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error {
+				return b.put(stale)
+			}))
+
+			var ids []string
+			for i := len(api.order) - 1; i >= 0; i-- {
+				if api.posts[api.order[i]].UserId == "user1" {
+					ids = append(ids, api.order[i])
+				}
+			}
+			if cursor := tc.cursor(p, ids); cursor != nil {
+				require.NoError(t, p.kvSetJSON(backfillCursorKeyPrefix+"channel1", cursor))
+			}
+
+			done, err := p.backfillChannel("channel1", &backfillStatus{})
+			require.NoError(t, err)
+			assert.True(t, done)
+
+			var cursor backfillCursor
+			_, err = p.kvGetJSON(backfillCursorKeyPrefix+"channel1", &cursor)
+			require.NoError(t, err)
+			assert.True(t, cursor.Done)
+			assert.Equal(t, p.indexRules(), cursor.Rules)
+			assert.Equal(t, tc.posts, cursor.Posts)
+
+			idx, err := p.getChannelIndex("channel1")
+			require.NoError(t, err)
+			var tags []string
+			for tag := range idx.Tags {
+				tags = append(tags, tag)
+			}
+			assert.ElementsMatch(t, tc.tags, tags)
+		})
+	}
+}
+
+func TestBackfillChannelIDs(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// A channel indexed by a hook before the first run does not stand for
+	// the sweep
+	require.NoError(t, p.withChannelIndex("dm0", func(b *indexBatch) error { return nil }))
+	channelIDs, err := p.backfillChannelIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"channel1", "dm0", "private1"}, channelIDs)
+	assert.Equal(t, 1, api.memberSweeps)
+
+	// Later runs only list public channels, plus what the index registered
+	require.NoError(t, p.withChannelIndex("dm1", func(b *indexBatch) error { return nil }))
+	channelIDs, err = p.backfillChannelIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"channel1", "dm0", "dm1", "private1"}, channelIDs)
+	assert.Equal(t, 1, api.memberSweeps)
+}
+
+func TestRegisterBackfillChannelsPages(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// The single list saved by older versions is the first page
+	require.NoError(t, p.kvSetJSON(backfillChannelsKey, []string{"old1", "old2"}))
+
+	var ids []string
+	for i := 0; i < backfillChannelsPageSize; i++ {
+		ids = append(ids, fmt.Sprintf("channel%04d", i))
+	}
+	registered, err := p.registerBackfillChannels(ids...)
+	require.NoError(t, err)
+	assert.Len(t, registered, backfillChannelsPageSize+2)
+
+	pages, err := p.getBackfillChannelPages()
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+	assert.Len(t, pages[0], backfillChannelsPageSize)
+	assert.Len(t, pages[1], 2)
+
+	// Only the last page is written for a new channel
+	first := string(api.kv[backfillChannelsPageKey(0)])
+	registered, err = p.registerBackfillChannels("new1", "old1")
+	require.NoError(t, err)
+	assert.Len(t, registered, backfillChannelsPageSize+3)
+	assert.Equal(t, first, string(api.kv[backfillChannelsPageKey(0)]))
+
+	channelIDs, err := p.getBackfillChannels()
+	require.NoError(t, err)
+	assert.Equal(t, registered, channelIDs)
+}
+
+// TestBackfillSkipsChangedPosts covers posts a hook edits or deletes after a
+// backfill page read them and before it takes the channel lock.
+func TestBackfillSkipsChangedPosts(t *testing.T) {
+	api := newTestAPI()
+	api.corpusAPI = newMessagesAPI([]string{"#edited", "#deleted", "#unindexed", "#kept"})
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	users := map[string]*model.User{}
+	var crawled []indexedPost
+	posts := map[string]*model.Post{}
+	for _, id := range api.order {
+		post := api.posts[id]
+		post.UpdateAt = post.CreateAt
+		posts[post.Message] = post
+		crawled = append(crawled, p.indexEntryForPost(post, users))
+	}
+
+	edited := posts["#edited"].Clone()
+	edited.Message, edited.UpdateAt = "#changed", edited.UpdateAt+10
+	require.NoError(t, p.indexPost(edited, p.indexEntryForPost(edited, users)))
+
+	deleted := posts["#deleted"].Clone()
+	deleted.DeleteAt, deleted.UpdateAt = deleted.UpdateAt+10, deleted.UpdateAt+10
+	require.NoError(t, p.unindexPost(deleted))
+
+	require.NoError(t, p.indexPost(posts["#unindexed"], p.indexEntryForPost(posts["#unindexed"], users)))
+	unindexed := posts["#unindexed"].Clone()
+	unindexed.Message, unindexed.UpdateAt = "no tags", unindexed.UpdateAt+10
+	require.NoError(t, p.indexPost(unindexed, p.indexEntryForPost(unindexed, users)))
+
+	require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error {
+		for _, entry := range crawled {
+			if err := b.putCrawled(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	idx, err := p.getChannelIndex("channel1")
+	require.NoError(t, err)
+	var tags []string
+	for tag := range idx.Tags {
+		tags = append(tags, tag)
+	}
+	assert.ElementsMatch(t, []string{"changed", "kept"}, tags)
+	assert.Equal(t, 2, idx.Posts)
+}
+
+func TestBackfillWait(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+	now := time.Now()
+
+	// Never run anywhere
+	assert.Zero(t, p.backfillWait(now, cluster.JobMetadata{}))
+
+	// Ran recently with the current rules: check the rules again shortly
+	p.saveBackfillStatus(&backfillStatus{Rules: p.indexRules()})
+	recent := cluster.JobMetadata{LastFinished: now.Add(-10 * time.Minute)}
+	assert.Equal(t, backfillRecheck, p.backfillWait(now, recent))
+	assert.Equal(t, backfillRecheck/2, p.backfillWait(now, cluster.JobMetadata{LastFinished: now.Add(backfillRecheck/2 - backfillInterval)}))
+
+	// The settings changed since
+	p.setConfiguration(&configuration{CountTagsInQuotes: true})
+	assert.Zero(t, p.backfillWait(now, recent))
+}
+
+// LogInfo is only called by the backfill job, to report its progress.
+func (a *testAPI) LogInfo(msg string, keyValuePairs ...interface{}) {}
+
+func TestRunBackfillJob(t *testing.T) {
+	api := newTestAPI()
+	api.corpusAPI = newMessagesAPI([]string{"#release #alpha", "#release"})
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	p.runBackfillJob()
+
+	status, err := p.getBackfillStatus()
+	require.NoError(t, err)
+	assert.False(t, status.Running)
+	assert.NotZero(t, status.CompletedAt)
+	assert.Equal(t, 2, status.ChannelsTotal)
+	assert.Equal(t, 2, status.ChannelsDone)
+	assert.Empty(t, status.LastError)
+
+	// The bot post is crawled but never counted
+	counts := map[string]map[string]int{}
+	for _, channelID := range []string{"channel1", "private1"} {
+		idx, err := p.getChannelIndex(channelID)
+		require.NoError(t, err)
+		require.NotNil(t, idx, channelID)
+		assert.True(t, idx.Indexed, channelID)
+		counts[channelID] = map[string]int{}
+		for tag, stats := range idx.Tags {
+			counts[channelID][tag] = stats.Count
+		}
+	}
+	assert.Equal(t, map[string]map[string]int{
+		"channel1": {"release": 2, "alpha": 1},
+		"private1": {},
+	}, counts)
+}
@@ -1,15 +1,133 @@
 package main
 
 import (
+	"fmt"
+	"sync"
+
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 )
 
 type Plugin struct {
 	plugin.MattermostPlugin
+
+	// backfillJob crawls channel history into the hashtag index.
+	backfillJob *cluster.Job
+
+	// backfillStop is closed on deactivation to interrupt a running backfill.
+	backfillStop chan struct{}
+
+	// followDigestJob sends the digests of the tags users follow.
+	followDigestJob *cluster.Job
+
+	// webhookJob retries the webhook deliveries that failed.
+	webhookJob *cluster.Job
+
+	// webhookSends tracks the deliveries started by hooks, which
+	// OnDeactivate waits for.
+	webhookSends sync.WaitGroup
+
+	// botUserID is the plugin bot, which sends follow digests.
+	botUserID string
+
+	// commandHandler serves the /hashtags slash command.
+	commandHandler *commandHandler
+
+	// configurationLock synchronizes access to the configuration.
+	configurationLock sync.RWMutex
+
+	// configuration is the active plugin configuration. Consult getConfiguration and
+	// setConfiguration for usage.
+	configuration *configuration
 }
 
 // Main ServeHTTP implementation is in api.go
 
+// OnActivate registers the bot and the slash command, and schedules the
+// backfill job that loads history into the hashtag index, the follow digest
+// job and the webhook job.
+func (p *Plugin) OnActivate() error {
+	p.backfillStop = make(chan struct{})
+
+	botUserID, err := p.API.EnsureBotUser(&model.Bot{
+		Username:    "hashtags",
+		DisplayName: "Hashtags",
+		Description: "Sends the new posts of the hashtags you follow.",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ensure bot user: %w", err)
+	}
+	p.botUserID = botUserID
+
+	handler, err := newCommandHandler(p)
+	if err != nil {
+		return err
+	}
+	p.commandHandler = handler
+
+	job, err := cluster.Schedule(
+		p.API,
+		backfillJobKey,
+		p.backfillWait,
+		p.runBackfillJob,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to schedule backfill job: %w", err)
+	}
+	p.backfillJob = job
+
+	digestJob, err := cluster.Schedule(
+		p.API,
+		followDigestJobKey,
+		cluster.MakeWaitForInterval(followDigestInterval),
+		p.runFollowDigestJob,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to schedule follow digest job: %w", err)
+	}
+	p.followDigestJob = digestJob
+
+	webhookJob, err := cluster.Schedule(
+		p.API,
+		webhookJobKey,
+		cluster.MakeWaitForInterval(webhookJobInterval),
+		p.runWebhookJob,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to schedule webhook job: %w", err)
+	}
+	p.webhookJob = webhookJob
+
+	return nil
+}
+
+// OnDeactivate stops the jobs and waits for the webhook deliveries in
+// flight. The backfill cursors and the follow and webhook queues are kept, so
+// they resume where they left off the next time the plugin is enabled.
+func (p *Plugin) OnDeactivate() error {
+	if p.backfillStop != nil {
+		close(p.backfillStop)
+	}
+	if p.backfillJob != nil {
+		if err := p.backfillJob.Close(); err != nil {
+			p.API.LogError("Failed to close backfill job", "error", err.Error())
+		}
+	}
+	if p.followDigestJob != nil {
+		if err := p.followDigestJob.Close(); err != nil {
+			p.API.LogError("Failed to close follow digest job", "error", err.Error())
+		}
+	}
+	if p.webhookJob != nil {
+		if err := p.webhookJob.Close(); err != nil {
+			p.API.LogError("Failed to close webhook job", "error", err.Error())
+		}
+	}
+	p.webhookSends.Wait()
+	return nil
+}
+
 func main() {
 	plugin.ClientMain(&Plugin{})
-}
\ No newline at end of file
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// A tag query combines tags with AND, OR, NOT and parentheses, for example
+//
+//	#incident AND #database NOT #resolved
+//	(#bug OR #regression) AND status=blocked
+//
+// Operators are upper case, so "and" or "not" on their own are tags. Terms
+// next to each other are joined with AND, and AND binds tighter than OR. A
+// term may be quoted to use a tag that reads like an operator or holds
+// parentheses, and key=value stands for the tag key:value.
+
+// QueryError reports where a tag query could not be parsed. Offset and Length
+// count UTF-16 code units of the query, like JavaScript string indices, so a
+// client can underline the problem.
+type QueryError struct {
+	Message string `json:"message"`
+	Offset  int    `json:"offset"`
+	Length  int    `json:"length"`
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+type queryTokenKind int
+
+const (
+	queryEOF queryTokenKind = iota
+	queryTerm
+	queryAnd
+	queryOr
+	queryNot
+	queryOpen
+	queryClose
+)
+
+type queryToken struct {
+	kind   queryTokenKind
+	text   string
+	offset int
+	length int
+}
+
+// lexTagQuery splits a query into tokens. The only error it reports is an
+// unterminated quote.
+func lexTagQuery(query string) ([]queryToken, *QueryError) {
+	var tokens []queryToken
+	runes := []rune(query)
+	// units[i] is the UTF-16 offset of runes[i]
+	units := make([]int, len(runes)+1)
+	for i, r := range runes {
+		n := utf16.RuneLen(r)
+		if n < 0 {
+			n = 1
+		}
+		units[i+1] = units[i] + n
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: queryOpen, text: "(", offset: units[i], length: 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: queryClose, text: ")", offset: units[i], length: 1})
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, &QueryError{Message: "unterminated quote", Offset: units[i], Length: units[j] - units[i]}
+			}
+			tokens = append(tokens, queryToken{kind: queryTerm, text: string(runes[i+1 : j]), offset: units[i], length: units[j+1] - units[i]})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(`()"`, runes[j]) {
+				j++
+			}
+			token := queryToken{kind: queryTerm, text: string(runes[i:j]), offset: units[i], length: units[j] - units[i]}
+			switch token.text {
+			case "AND":
+				token.kind = queryAnd
+			case "OR":
+				token.kind = queryOr
+			case "NOT":
+				token.kind = queryNot
+			}
+			tokens = append(tokens, token)
+			i = j
+		}
+	}
+	return append(tokens, queryToken{kind: queryEOF, offset: units[len(runes)]}), nil
+}
+
+// tagQueryParser is a recursive descent parser over the tokens of a query.
+// Tags are expanded with their aliases as they are read.
+type tagQueryParser struct {
+	tokens  []queryToken
+	pos     int
+	aliases map[string]string
+}
+
+// parseTagQuery parses a tag query into a matcher.
+func parseTagQuery(query string, aliases map[string]string) (tagMatcher, *QueryError) {
+	tokens, qerr := lexTagQuery(query)
+	if qerr != nil {
+		return nil, qerr
+	}
+	if len(tokens) == 1 {
+		return nil, &QueryError{Message: "empty query"}
+	}
+
+	p := &tagQueryParser{tokens: tokens, aliases: aliases}
+	matcher, qerr := p.parseOr()
+	if qerr != nil {
+		return nil, qerr
+	}
+	if tok := p.peek(); tok.kind != queryEOF {
+		if tok.kind == queryClose {
+			return nil, p.errorAt(tok, "unmatched closing parenthesis")
+		}
+		return nil, p.errorAt(tok, "unexpected "+tok.text)
+	}
+	return matcher, nil
+}
+
+func (p *tagQueryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *tagQueryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != queryEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *tagQueryParser) errorAt(tok queryToken, message string) *QueryError {
+	return &QueryError{Message: message, Offset: tok.offset, Length: tok.length}
+}
+
+// parseOr reads and-expressions separated by OR.
+func (p *tagQueryParser) parseOr() (tagMatcher, *QueryError) {
+	first, qerr := p.parseAnd()
+	if qerr != nil {
+		return nil, qerr
+	}
+	terms := tagOneOf{first}
+	for p.peek().kind == queryOr {
+		p.next()
+		term, qerr := p.parseAnd()
+		if qerr != nil {
+			return nil, qerr
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return terms, nil
+}
+
+// parseAnd reads unary expressions joined by AND or by nothing at all.
+func (p *tagQueryParser) parseAnd() (tagMatcher, *QueryError) {
+	first, qerr := p.parseUnary()
+	if qerr != nil {
+		return nil, qerr
+	}
+	terms := tagAllOf{first}
+	for {
+		switch p.peek().kind {
+		case queryAnd:
+			p.next()
+		case queryTerm, queryNot, queryOpen:
+		default:
+			if len(terms) == 1 {
+				return first, nil
+			}
+			return terms, nil
+		}
+		term, qerr := p.parseUnary()
+		if qerr != nil {
+			return nil, qerr
+		}
+		terms = append(terms, term)
+	}
+}
+
+// parseUnary reads a term, a negation or a parenthesised expression.
+func (p *tagQueryParser) parseUnary() (tagMatcher, *QueryError) {
+	tok := p.next()
+	switch tok.kind {
+	case queryNot:
+		term, qerr := p.parseUnary()
+		if qerr != nil {
+			return nil, qerr
+		}
+		return tagNot{term}, nil
+	case queryOpen:
+		inner, qerr := p.parseOr()
+		if qerr != nil {
+			return nil, qerr
+		}
+		if p.peek().kind != queryClose {
+			return nil, p.errorAt(tok, "missing closing parenthesis")
+		}
+		p.next()
+		return inner, nil
+	case queryTerm:
+		key := queryTermTag(tok.text)
+		if !isValidHashtag(key) {
+			// Tags this short are never counted, so they could not match anything
+			if first, _ := utf8.DecodeRuneInString(key); unicode.IsLetter(first) {
+				return nil, p.errorAt(tok, fmt.Sprintf("%q is too short, tags have at least %d characters", tok.text, minHashtagLength))
+			}
+			return nil, p.errorAt(tok, fmt.Sprintf("%q is not a valid hashtag", tok.text))
+		}
+		return tagAnyOf(expandTag(key, p.aliases)), nil
+	case queryEOF:
+		return nil, p.errorAt(tok, "expected a tag at the end of the query")
+	default:
+		return nil, p.errorAt(tok, "expected a tag before "+tok.text)
+	}
+}
+
+// queryTermTag returns the canonical tag a query term stands for. A term of
+// the form key=value is the facet tag key:value.
+func queryTermTag(term string) string {
+	if key, value, ok := strings.Cut(term, "="); ok {
+		term = key + facetSeparator + value
+	}
+	return normalizeTagQuery(term)
+}
+
+// tagOneOf matches posts matched by any of its terms.
+type tagOneOf []tagMatcher
+
+func (m tagOneOf) match(e indexedPost) (string, bool) {
+	for _, term := range m {
+		if t, ok := term.match(e); ok {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func (m tagOneOf) months(idx *channelIndex) map[string]bool {
+	months := map[string]bool{}
+	for _, term := range m {
+		for month := range term.months(idx) {
+			months[month] = true
+		}
+	}
+	return months
+}
+
+// count cannot add up the terms, since a post may match several of them.
+func (m tagOneOf) count(idx *channelIndex) (int, bool) {
+	return 0, false
+}
+
+// tagNot matches the tagged posts its term does not match.
+type tagNot struct {
+	term tagMatcher
+}
+
+func (m tagNot) match(e indexedPost) (string, bool) {
+	_, ok := m.term.match(e)
+	return "", !ok
+}
+
+func (m tagNot) months(idx *channelIndex) map[string]bool {
+	months := map[string]bool{}
+	for _, month := range idx.Months {
+		months[month] = true
+	}
+	return months
+}
+
+func (m tagNot) count(idx *channelIndex) (int, bool) {
+	return 0, false
+}
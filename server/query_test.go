@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTagQuery(t *testing.T) {
+	posts := map[string]indexedPost{
+		"incident":    {ID: "incident", Tags: []string{"Incident"}},
+		"incident-db": {ID: "incident-db", Tags: []string{"incident", "Database"}},
+		"resolved":    {ID: "resolved", Tags: []string{"incident", "database", "resolved"}},
+		"bug":         {ID: "bug", Tags: []string{"bug", "status:blocked"}},
+		"and":         {ID: "and", Tags: []string{"and"}},
+		"kube":        {ID: "kube", Tags: []string{"k8s"}},
+	}
+	aliases := map[string]string{"k8s": "kubernetes"}
+
+	tests := []struct {
+		query   string
+		matches []string
+	}{
+		{"#incident", []string{"incident", "incident-db", "resolved"}},
+		{"#incident AND #database NOT #resolved", []string{"incident-db"}},
+		{"incident database", []string{"incident-db", "resolved"}},
+		{"#database OR #bug", []string{"incident-db", "resolved", "bug"}},
+		{"#bug OR #database AND #resolved", []string{"resolved", "bug"}},
+		{"(#bug OR #database) AND NOT #resolved", []string{"incident-db", "bug"}},
+		{"NOT #incident", []string{"bug", "and", "kube"}},
+		{"NOT NOT #bug", []string{"bug"}},
+		{`"and"`, []string{"and"}},
+		{"and", []string{"and"}},
+		{"status=blocked", []string{"bug"}},
+		{"#Status:Blocked", []string{"bug"}},
+		{"#kubernetes", []string{"kube"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			matcher, qerr := parseTagQuery(tc.query, aliases)
+			require.Nil(t, qerr)
+
+			var matches []string
+			for _, id := range []string{"incident", "incident-db", "resolved", "bug", "and", "kube"} {
+				if _, ok := matcher.match(posts[id]); ok {
+					matches = append(matches, id)
+				}
+			}
+			assert.Equal(t, tc.matches, matches)
+		})
+	}
+}
+
+func TestParseTagQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   QueryError
+	}{
+		{"", QueryError{Message: "empty query"}},
+		{"#incident AND", QueryError{Message: "expected a tag at the end of the query", Offset: 13}},
+		{"#1ab OR #incident", QueryError{Message: `"#1ab" is not a valid hashtag`, Offset: 0, Length: 4}},
+		{"#incident AND #db", QueryError{Message: `"#db" is too short, tags have at least 3 characters`, Offset: 14, Length: 3}},
+		{"#incident 🔥", QueryError{Message: `"🔥" is not a valid hashtag`, Offset: 10, Length: 2}},
+		{`#𝒜𝒜𝒜 "db`, QueryError{Message: "unterminated quote", Offset: 8, Length: 3}},
+		{"(#incident OR #database", QueryError{Message: "missing closing parenthesis", Offset: 0, Length: 1}},
+		{"#incident)", QueryError{Message: "unmatched closing parenthesis", Offset: 9, Length: 1}},
+		{`#incident "db`, QueryError{Message: "unterminated quote", Offset: 10, Length: 3}},
+		{"OR #database", QueryError{Message: "expected a tag before OR", Offset: 0, Length: 2}},
+		{"#database AND AND #bug", QueryError{Message: "expected a tag before AND", Offset: 14, Length: 3}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			_, qerr := parseTagQuery(tc.query, nil)
+			require.NotNil(t, qerr)
+			assert.Equal(t, tc.err, *qerr)
+		})
+	}
+}
+
+func TestTagQueryMonths(t *testing.T) {
+	idx := &channelIndex{
+		Months: []string{"202403", "202402", "202401"},
+		Tags: map[string]*tagStats{
+			"incident": {Count: 2, Months: map[string]int{"202401": 1, "202402": 1}},
+			"database": {Count: 1, Months: map[string]int{"202402": 1}},
+			"bug":      {Count: 1, Months: map[string]int{"202403": 1}},
+		},
+	}
+
+	matcher, qerr := parseTagQuery("#incident #database OR #bug", nil)
+	require.Nil(t, qerr)
+	assert.Equal(t, map[string]bool{"202402": true, "202403": true}, matcher.months(idx))
+
+	matcher, qerr = parseTagQuery("NOT #incident", nil)
+	require.Nil(t, qerr)
+	assert.Len(t, matcher.months(idx), 3)
+
+	matcher, qerr = parseTagQuery("#incident", nil)
+	require.Nil(t, qerr)
+	count, ok := matcher.count(idx)
+	assert.True(t, ok)
+	assert.Equal(t, 2, count)
+}
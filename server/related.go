@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultRelatedLimit    = 10
+	maxRelatedLimit        = 100
+	defaultRelatedMinCount = 2
+)
+
+// RelatedTag is a tag that appears in posts alongside the tag asked about.
+// Count is the number of posts using both, TagCount the number using this
+// tag at all. Lift is how much more often the two meet than they would by
+// chance, and PMI its base 2 logarithm.
+type RelatedTag struct {
+	Tag      string  `json:"tag"`
+	Count    int     `json:"count"`
+	TagCount int     `json:"tag_count"`
+	Lift     float64 `json:"lift"`
+	PMI      float64 `json:"pmi"`
+}
+
+// RelatedTagsResponse lists the tags related to Tag. Count is the number of
+// posts using Tag and Total the number of tagged posts in the scope.
+type RelatedTagsResponse struct {
+	Tag     string       `json:"tag"`
+	Count   int          `json:"count"`
+	Total   int          `json:"total"`
+	Related []RelatedTag `json:"related"`
+}
+
+// cooccurrence accumulates the counts behind related tags. All tags are
+// canonical, with aliases resolved.
+type cooccurrence struct {
+	tag    string
+	keys   tagAnyOf
+	total  int
+	count  int
+	tags   map[string]int
+	shared map[string]int
+}
+
+func newCooccurrence(tag string, aliases map[string]string) *cooccurrence {
+	tag = resolveTag(tag, aliases)
+	return &cooccurrence{
+		tag:    tag,
+		keys:   tagAnyOf(expandTag(tag, aliases)),
+		tags:   map[string]int{},
+		shared: map[string]int{},
+	}
+}
+
+// postTags returns the distinct canonical tags of a post other than the tag
+// asked about, with aliases resolved.
+func (c *cooccurrence) postTags(e indexedPost, aliases map[string]string) []string {
+	var tags []string
+	seen := map[string]bool{c.tag: true}
+	for _, t := range e.Tags {
+		key := resolveTag(canonicalTag(t), aliases)
+		if !seen[key] {
+			seen[key] = true
+			tags = append(tags, key)
+		}
+	}
+	return tags
+}
+
+// addShared counts the other tags of a post using the tag asked about.
+func (c *cooccurrence) addShared(e indexedPost, aliases map[string]string) {
+	c.count++
+	for _, key := range c.postTags(e, aliases) {
+		c.shared[key]++
+	}
+}
+
+// addChannelCooccurrence adds the counts of one channel within a time range.
+// Without a range an indexed channel only has the month logs holding the tag
+// read; with one, the logs of the range are read in full. Other channels are
+// scanned.
+func (p *Plugin) addChannelCooccurrence(c *cooccurrence, channelID string, window timeRange, aliases map[string]string, users map[string]*model.User) error {
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil {
+		return err
+	}
+	if idx == nil || !idx.Indexed {
+		return p.scanChannelEntries(channelID, window, users, func(e indexedPost) bool {
+			c.add(e, aliases)
+			return true
+		})
+	}
+	if window.bounded() {
+		for _, month := range idx.Months {
+			if !window.containsMonth(month) {
+				continue
+			}
+			entries, err := p.getChannelLog(channelID, month)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if window.contains(e.CreateAt) {
+					c.add(e, aliases)
+				}
+			}
+		}
+		return nil
+	}
+
+	total, err := p.channelPostCount(idx)
+	if err != nil {
+		return err
+	}
+	c.total += total
+	counts := map[string]*hashtagInfo{}
+	if _, err := p.addIndexedTagCounts(idx, counts, aliases); err != nil {
+		return err
+	}
+	for tag, info := range counts {
+		c.tags[tag] += info.count
+	}
+
+	var months []string
+	for month := range c.keys.months(idx) {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months {
+		entries, err := p.getChannelLog(channelID, month)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if _, ok := c.keys.match(e); ok {
+				c.addShared(e, aliases)
+			}
+		}
+	}
+	return nil
+}
+
+// add counts a tagged post that the channel counters do not cover.
+func (c *cooccurrence) add(e indexedPost, aliases map[string]string) {
+	c.total++
+	if _, ok := c.keys.match(e); ok {
+		c.addShared(e, aliases)
+		c.tags[c.tag]++
+	}
+	for _, key := range c.postTags(e, aliases) {
+		c.tags[key]++
+	}
+}
+
+// related ranks the tags seen with the tag asked about by lift. Lift compares
+// how often two tags meet with how often they would by chance, so a niche tag
+// always used with the tag outranks a popular one that meets it as often.
+// Tags seen together fewer than minCount times are left out as noise.
+func (c *cooccurrence) related(minCount, limit int) []RelatedTag {
+	related := []RelatedTag{}
+	if c.count == 0 || c.total == 0 {
+		return related
+	}
+	for tag, shared := range c.shared {
+		if shared < minCount {
+			continue
+		}
+		tagCount := c.tags[tag]
+		if tagCount < shared {
+			tagCount = shared
+		}
+		lift := float64(shared) * float64(c.total) / (float64(c.count) * float64(tagCount))
+		related = append(related, RelatedTag{
+			Tag:      tag,
+			Count:    shared,
+			TagCount: tagCount,
+			Lift:     lift,
+			PMI:      math.Log2(lift),
+		})
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Lift != related[j].Lift {
+			return related[i].Lift > related[j].Lift
+		}
+		if related[i].Count != related[j].Count {
+			return related[i].Count > related[j].Count
+		}
+		return related[i].Tag < related[j].Tag
+	})
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related
+}
+
+// relatedTags computes the tags related to tag over a set of channels and a
+// time range.
+func (p *Plugin) relatedTags(tag string, channelIDs []string, window timeRange, minCount, limit int) (*RelatedTagsResponse, error) {
+	tag = normalizeTagQuery(tag)
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	c := newCooccurrence(tag, aliases)
+	users := map[string]*model.User{}
+	for _, channelID := range channelIDs {
+		if err := p.addChannelCooccurrence(c, channelID, window, aliases, users); err != nil {
+			if len(channelIDs) == 1 {
+				return nil, err
+			}
+			p.API.LogError("Failed to count related tags for channel", "error", err.Error(), "channel_id", channelID)
+		}
+	}
+
+	return &RelatedTagsResponse{
+		Tag:     c.tag,
+		Count:   c.count,
+		Total:   c.total,
+		Related: c.related(minCount, limit),
+	}, nil
+}
+
+// GET /api/related?tag=XXX&scope=channel&channel_id=YYY&limit=10&min_count=2
+// GET /api/related?tag=XXX&scope=team&team_id=YYY
+func (p *Plugin) handleRelatedTags(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tag := normalizeTagQuery(query.Get("tag"))
+	if tag == "" {
+		http.Error(w, "tag is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultRelatedLimit
+	if s := query.Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= maxRelatedLimit {
+			limit = n
+		}
+	}
+	minCount := defaultRelatedMinCount
+	if s := query.Get("min_count"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			minCount = n
+		}
+	}
+
+	window, err := parseTimeRange(query, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	channelIDs, status, err := p.scopeChannelIDs(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	response, err := p.relatedTags(tag, channelIDs, window, minCount, limit)
+	if err != nil {
+		p.API.LogError("Failed to compute related tags", "error", err.Error(), "tag", tag)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelatedTags(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(newMessagesAPI([]string{
+		"#incident #database",
+		"#incident #database #urgent",
+		"#incident #postgres",
+		"#incident #postgres",
+		"#incident #urgent",
+		"#urgent #release",
+		"#urgent #release",
+		"#urgent #deploy",
+		"#release",
+		"#deploy #postgres",
+		"no tags here",
+	}))
+
+	result, err := p.relatedTags("#Incident", []string{"channel1"}, timeRange{}, 2, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "incident", result.Tag)
+	assert.Equal(t, 5, result.Count)
+	assert.Equal(t, 10, result.Total)
+
+	// #database only ever appears with #incident, #urgent mostly elsewhere
+	require.Len(t, result.Related, 3)
+	assert.Equal(t, "database", result.Related[0].Tag)
+	assert.Equal(t, 2, result.Related[0].Count)
+	assert.Equal(t, 2, result.Related[0].TagCount)
+	assert.InDelta(t, 2.0, result.Related[0].Lift, 1e-9)
+	assert.InDelta(t, 1.0, result.Related[0].PMI, 1e-9)
+	assert.Equal(t, "postgres", result.Related[1].Tag)
+	assert.Equal(t, "urgent", result.Related[2].Tag)
+	assert.InDelta(t, 0.8, result.Related[2].Lift, 1e-9)
+
+	result, err = p.relatedTags("incident", []string{"channel1"}, timeRange{}, 3, 10)
+	require.NoError(t, err)
+	assert.Empty(t, result.Related)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// routingRulesKey holds the routing rules, in the order they were added.
+	routingRulesKey     = "routing_rules"
+	routingRulesLockKey = "routing_rules_lock"
+)
+
+// routedPostProp marks the posts the bot creates for a routing rule, so they
+// are never routed again even if the bot were counted one day.
+const routedPostProp = "hashtags_routed_from"
+
+// RoutingRule mirrors the posts using Tag into DestinationChannelID. Without
+// SourceChannelID posts from every public channel are mirrored; naming a
+// source is the only way to route a private channel.
+type RoutingRule struct {
+	ID                   string `json:"id"`
+	Tag                  string `json:"tag"`
+	SourceChannelID      string `json:"source_channel_id,omitempty"`
+	DestinationChannelID string `json:"destination_channel_id"`
+	CreatorID            string `json:"creator_id"`
+	CreateAt             int64  `json:"create_at"`
+}
+
+type RoutingRulesResponse struct {
+	Rules []RoutingRule `json:"rules"`
+}
+
+func (p *Plugin) getRoutingRules() ([]RoutingRule, error) {
+	var rules []RoutingRule
+	if _, err := p.kvGetJSON(routingRulesKey, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// updateRoutingRules replaces the routing rules with what fn returns for them,
+// holding a lock so that concurrent edits by several admins are not lost.
+func (p *Plugin) updateRoutingRules(fn func(rules []RoutingRule) []RoutingRule) ([]RoutingRule, error) {
+	var rules []RoutingRule
+	err := p.withLock(routingRulesLockKey, func() error {
+		current, err := p.getRoutingRules()
+		if err != nil {
+			return err
+		}
+		rules = fn(current)
+		return p.kvSetJSON(routingRulesKey, rules)
+	})
+	return rules, err
+}
+
+// matches reports whether a rule mirrors a post using the canonical tag key
+// from a channel.
+func (r RoutingRule) matches(key string, channel *model.Channel, aliases map[string]string) bool {
+	if resolveTag(r.Tag, aliases) != key || r.DestinationChannelID == channel.Id {
+		return false
+	}
+	if r.SourceChannelID != "" {
+		return r.SourceChannelID == channel.Id
+	}
+	return channel.Type == model.ChannelTypeOpen
+}
+
+// routePost mirrors a new post into the destination of every rule matching
+// one of its tags, as postHashtags returns them, once per destination. Bot
+// posts carry no tags and posts created by a rule are skipped, so mirrors are
+// never mirrored again.
+func (p *Plugin) routePost(post *model.Post, tags []string) error {
+	if post.GetProp(routedPostProp) != nil {
+		return nil
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	rules, err := p.getRoutingRules()
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return err
+	}
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		return fmt.Errorf("failed to get channel: %w", appErr)
+	}
+
+	routed := map[string]bool{}
+	for _, t := range tags {
+		key := resolveTag(canonicalTag(t), aliases)
+		for _, rule := range rules {
+			if routed[rule.DestinationChannelID] || !rule.matches(key, channel, aliases) {
+				continue
+			}
+			routed[rule.DestinationChannelID] = true
+			if err := p.createRoutedPost(post, channel, t, rule); err != nil {
+				p.API.LogError("Failed to route post", "error", err.Error(), "post_id", post.Id, "rule_id", rule.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// createRoutedPost posts the bot's mirror of a post: who wrote it where, an
+// excerpt and a permalink.
+func (p *Plugin) createRoutedPost(post *model.Post, channel *model.Channel, tag string, rule RoutingRule) error {
+	author := "someone"
+	if user, appErr := p.API.GetUser(post.UserId); appErr == nil {
+		author = "@" + user.Username
+	}
+	message := fmt.Sprintf("**#%s** from %s in ~%s:\n> %s\n\n[View post](%s)",
+		tag, author, channel.Name, postExcerpt(post.Message), p.permalink(post.Id))
+
+	mirror := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: rule.DestinationChannelID,
+		Message:   message,
+	}
+	mirror.AddProp(routedPostProp, post.Id)
+	if _, appErr := p.API.CreatePost(mirror); appErr != nil {
+		return fmt.Errorf("failed to create post: %w", appErr)
+	}
+	return nil
+}
+
+// validateRoutingRule checks that a rule names existing channels and that it
+// would not route a channel into itself.
+func (p *Plugin) validateRoutingRule(rule RoutingRule) error {
+	if rule.Tag == "" || rule.DestinationChannelID == "" {
+		return errors.New("tag and destination_channel_id are required")
+	}
+	if rule.SourceChannelID == rule.DestinationChannelID {
+		return errors.New("a channel cannot be routed into itself")
+	}
+	if _, appErr := p.API.GetChannel(rule.DestinationChannelID); appErr != nil {
+		return fmt.Errorf("destination channel not found: %w", appErr)
+	}
+	if rule.SourceChannelID != "" {
+		if _, appErr := p.API.GetChannel(rule.SourceChannelID); appErr != nil {
+			return fmt.Errorf("source channel not found: %w", appErr)
+		}
+	}
+	return nil
+}
+
+// GET /api/admin/routing
+// POST /api/admin/routing {"tag": "incident", "destination_channel_id": "XXX", "source_channel_id": "YYY"}
+// DELETE /api/admin/routing?id=XXX
+func (p *Plugin) handleRoutingRules(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var rules []RoutingRule
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		rules, err = p.getRoutingRules()
+	case http.MethodPost:
+		var rule RoutingRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		rule.Tag = normalizeTagQuery(rule.Tag)
+		if err := p.validateRoutingRule(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The bot needs to be a member to post in private destinations
+		if _, appErr := p.API.AddChannelMember(rule.DestinationChannelID, p.botUserID); appErr != nil {
+			p.API.LogError("Failed to add bot to channel", "error", appErr.Error(), "channel_id", rule.DestinationChannelID)
+			http.Error(w, appErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		rule.ID = model.NewId()
+		rule.CreatorID = userID
+		rule.CreateAt = model.GetMillis()
+		rules, err = p.updateRoutingRules(func(rules []RoutingRule) []RoutingRule {
+			return append(rules, rule)
+		})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		rules, err = p.updateRoutingRules(func(rules []RoutingRule) []RoutingRule {
+			kept := rules[:0]
+			for _, rule := range rules {
+				if rule.ID != id {
+					kept = append(kept, rule)
+				}
+			}
+			return kept
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rules == nil {
+		rules = []RoutingRule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(RoutingRulesResponse{Rules: rules}); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
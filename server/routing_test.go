@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutePost(t *testing.T) {
+	api := newTestAPI()
+	api.channelTypes["private1"] = model.ChannelTypePrivate
+	p := &Plugin{botUserID: "bot1"}
+	p.SetAPI(api)
+	require.NoError(t, p.kvSetJSON(routingRulesKey, []RoutingRule{
+		{ID: "r1", Tag: "incident", DestinationChannelID: "feed"},
+		{ID: "r2", Tag: "outage", DestinationChannelID: "feed"},
+		{ID: "r3", Tag: "incident", DestinationChannelID: "channel1"},
+		{ID: "r4", Tag: "database", SourceChannelID: "private1", DestinationChannelID: "ops"},
+	}))
+
+	route := func(channelID, userID, message string) *model.Post {
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID, UserId: userID, Message: message}
+		require.NoError(t, p.routePost(post, p.postHashtags(post, map[string]*model.User{})))
+		return post
+	}
+
+	// One mirror per destination, never into the source channel
+	post := route("channel1", "alice", "#Incident and #outage in\nthe #database")
+	require.Len(t, api.created, 1)
+	assert.Equal(t, "feed", api.created[0].ChannelId)
+	assert.Equal(t, "bot1", api.created[0].UserId)
+	assert.Equal(t, post.Id, api.created[0].GetProp(routedPostProp))
+	assert.Equal(t, "**#Incident** from @alice in ~channel1:\n> #Incident and #outage in the #database\n\n"+
+		"[View post](https://chat.example.com/_redirect/pl/"+post.Id+")", api.created[0].Message)
+
+	// Private channels are only routed by rules naming them
+	api.created = nil
+	route("private1", "alice", "#incident in the #database")
+	require.Len(t, api.created, 1)
+	assert.Equal(t, "ops", api.created[0].ChannelId)
+
+	// Mirrors and bot posts are never routed
+	api.created = nil
+	mirror := &model.Post{Id: model.NewId(), ChannelId: "channel2", UserId: "alice", Message: "#incident"}
+	mirror.AddProp(routedPostProp, post.Id)
+	require.NoError(t, p.routePost(mirror, p.postHashtags(mirror, map[string]*model.User{})))
+	route("channel2", "bot1", "#incident")
+	assert.Empty(t, api.created)
+}
+
+// routingAdminAPI lets the bot join any channel.
+type routingAdminAPI struct {
+	*authAPI
+}
+
+func (a *routingAdminAPI) AddChannelMember(channelID, userID string) (*model.ChannelMember, *model.AppError) {
+	return &model.ChannelMember{ChannelId: channelID, UserId: userID}, nil
+}
+
+func TestHandleRoutingRules(t *testing.T) {
+	api := &routingAdminAPI{authAPI: &authAPI{testAPI: newTestAPI(), system: true}}
+	p := &Plugin{botUserID: "bot1"}
+	p.SetAPI(api)
+
+	request := func(method, url, body string) RoutingRulesResponse {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin")
+		w := httptest.NewRecorder()
+		p.handleRoutingRules(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response RoutingRulesResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+
+	// Rules added at the same time are all kept
+	tags := []string{"incident", "outage", "database", "release"}
+	var wg sync.WaitGroup
+	for _, tag := range tags {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			request(http.MethodPost, "/api/admin/routing", `{"tag": "#`+tag+`", "destination_channel_id": "feed"}`)
+		}(tag)
+	}
+	wg.Wait()
+
+	rules, err := p.getRoutingRules()
+	require.NoError(t, err)
+	require.Len(t, rules, len(tags))
+
+	response := request(http.MethodDelete, "/api/admin/routing?id="+rules[0].ID, "")
+	assert.Len(t, response.Rules, len(tags)-1)
+	assert.NotContains(t, response.Rules, rules[0])
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// tagPostCursor marks a position in the newest-first order of posts. It is
+// handed to clients as an opaque string.
+type tagPostCursor struct {
+	CreateAt int64
+	PostID   string
+}
+
+func (c tagPostCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.CreateAt, 10) + ":" + c.PostID))
+}
+
+func decodeTagPostCursor(s string) (*tagPostCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	createAt, postID, ok := strings.Cut(string(data), ":")
+	if !ok || postID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	millis, err := strconv.ParseInt(createAt, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &tagPostCursor{CreateAt: millis, PostID: postID}, nil
+}
+
+// admits reports whether e lies past the cursor in newest-first order. A nil
+// cursor admits every post.
+func (c *tagPostCursor) admits(e indexedPost) bool {
+	return c == nil || e.CreateAt < c.CreateAt || (e.CreateAt == c.CreateAt && e.ID < c.PostID)
+}
+
+// newerThan orders index entries newest first, breaking ties on the post id
+// the same way the month logs do.
+func newerThan(a, b indexedPost) bool {
+	return a.CreateAt > b.CreateAt || (a.CreateAt == b.CreateAt && a.ID > b.ID)
+}
+
+// tagMatcher selects the posts a search returns.
+type tagMatcher interface {
+	// match reports whether an entry matches, along with the tag, as the post
+	// wrote it, through which it matched. The tag is "" for entries matched
+	// only by exclusion.
+	match(e indexedPost) (string, bool)
+	// months returns the month logs of an indexed channel that can hold matches.
+	months(idx *channelIndex) map[string]bool
+	// count returns the number of matching posts in an indexed channel from its
+	// counters, or false if the counters cannot tell.
+	count(idx *channelIndex) (int, bool)
+}
+
+// tagAnyOf matches posts using any of a set of canonical tag keys, typically a
+// tag and its aliases.
+type tagAnyOf []string
+
+func (m tagAnyOf) match(e indexedPost) (string, bool) {
+	t := e.matchedAnyTag(m)
+	return t, t != ""
+}
+
+func (m tagAnyOf) months(idx *channelIndex) map[string]bool {
+	months := map[string]bool{}
+	for _, key := range m {
+		if stats, ok := idx.Tags[key]; ok {
+			for month := range stats.Months {
+				months[month] = true
+			}
+		}
+	}
+	return months
+}
+
+// count can only use the counters when at most one of the keys is used in the
+// channel, since a post may use several of them.
+func (m tagAnyOf) count(idx *channelIndex) (int, bool) {
+	if m.used(idx) > 1 {
+		return 0, false
+	}
+	total := 0
+	for _, key := range m {
+		if stats, ok := idx.Tags[key]; ok {
+			total += stats.Count
+		}
+	}
+	return total, true
+}
+
+// used returns the number of keys used in an indexed channel.
+func (m tagAnyOf) used(idx *channelIndex) int {
+	n := 0
+	for _, key := range m {
+		if _, ok := idx.Tags[key]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// tagAllOf matches posts matched by every one of its terms.
+type tagAllOf []tagMatcher
+
+func (m tagAllOf) match(e indexedPost) (string, bool) {
+	matched := ""
+	for _, term := range m {
+		t, ok := term.match(e)
+		if !ok {
+			return "", false
+		}
+		if matched == "" {
+			matched = t
+		}
+	}
+	return matched, true
+}
+
+// months keeps the months every term appears in.
+func (m tagAllOf) months(idx *channelIndex) map[string]bool {
+	var months map[string]bool
+	for _, term := range m {
+		termMonths := term.months(idx)
+		if months == nil {
+			months = termMonths
+			continue
+		}
+		for month := range months {
+			if !termMonths[month] {
+				delete(months, month)
+			}
+		}
+	}
+	return months
+}
+
+func (m tagAllOf) count(idx *channelIndex) (int, bool) {
+	if len(m) == 1 {
+		return m[0].count(idx)
+	}
+	return 0, false
+}
+
+// tagPostSource yields the posts of one channel that a matcher selects, newest
+// first. Indexed channels are read one month log at a time when the counters
+// give the total, and all at once otherwise; other channels are scanned once
+// up front.
+type tagPostSource struct {
+	p         *Plugin
+	channelID string
+	matcher   tagMatcher
+	cursor    *tagPostCursor
+	months    []string
+	buf       []indexedPost
+	total     int
+}
+
+func (p *Plugin) newTagPostSource(matcher tagMatcher, channelID string, window timeRange, cursor *tagPostCursor, users map[string]*model.User) (*tagPostSource, error) {
+	if window.bounded() {
+		matcher = tagInRange{term: matcher, window: window}
+	}
+	s := &tagPostSource{p: p, channelID: channelID, matcher: matcher, cursor: cursor}
+
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil || !idx.Indexed {
+		entries, err := p.scanChannelTagEntries(matcher, channelID, window, users)
+		if err != nil {
+			return nil, err
+		}
+		s.total = len(entries)
+		s.buf = s.filter(entries)
+		return s, nil
+	}
+
+	for month := range matcher.months(idx) {
+		s.months = append(s.months, month)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(s.months)))
+
+	total, ok := matcher.count(idx)
+	if !ok {
+		// The total is only known once every candidate month has been read
+		for _, month := range s.months {
+			entries, err := p.getChannelLog(channelID, month)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if _, ok := matcher.match(e); !ok {
+					continue
+				}
+				total++
+				if cursor.admits(e) {
+					s.buf = append(s.buf, e)
+				}
+			}
+		}
+		s.months = nil
+	}
+	s.total = total
+
+	if cursor != nil {
+		// Months newer than the cursor cannot hold anything past it.
+		for len(s.months) > 0 && s.months[0] > indexMonth(cursor.CreateAt) {
+			s.months = s.months[1:]
+		}
+	}
+	return s, nil
+}
+
+func (s *tagPostSource) filter(entries []indexedPost) []indexedPost {
+	var out []indexedPost
+	for _, e := range entries {
+		if _, ok := s.matcher.match(e); ok && s.cursor.admits(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// peek returns the next post without consuming it, or nil once the source is exhausted.
+func (s *tagPostSource) peek() (*indexedPost, error) {
+	for len(s.buf) == 0 && len(s.months) > 0 {
+		entries, err := s.p.getChannelLog(s.channelID, s.months[0])
+		if err != nil {
+			return nil, err
+		}
+		s.months = s.months[1:]
+		s.buf = s.filter(entries)
+	}
+	if len(s.buf) == 0 {
+		return nil, nil
+	}
+	return &s.buf[0], nil
+}
+
+func (s *tagPostSource) next() {
+	s.buf = s.buf[1:]
+}
+
+// tagPostPage is one page of index entries for a search across several channels.
+type tagPostPage struct {
+	Entries []indexedPost
+	Total   int
+	HasMore bool
+}
+
+// pageTagPosts merges the posts of several channels that the matcher selects
+// within a time range, newest first, skipping offset posts past the cursor and
+// returning up to limit posts. A limit of 0 returns everything. Only the month
+// logs needed to reach the page are read, and Total comes from the per-channel
+// counters. Skipped posts are read like returned ones, so an offset costs as
+// much as fetching every page before it; callers paging deep should pass the
+// cursor of the previous page instead.
+func (p *Plugin) pageTagPosts(matcher tagMatcher, channelIDs []string, window timeRange, cursor *tagPostCursor, offset, limit int, users map[string]*model.User) (*tagPostPage, error) {
+	page := &tagPostPage{}
+	var sources []*tagPostSource
+	for _, channelID := range channelIDs {
+		s, err := p.newTagPostSource(matcher, channelID, window, cursor, users)
+		if err != nil {
+			if len(channelIDs) == 1 {
+				return nil, err
+			}
+			p.API.LogError("Failed to get posts for channel", "error", err.Error(), "channel_id", channelID)
+			continue
+		}
+		page.Total += s.total
+		sources = append(sources, s)
+	}
+
+	for {
+		var best *tagPostSource
+		var head *indexedPost
+		for _, s := range sources {
+			e, err := s.peek()
+			if err != nil {
+				return nil, err
+			}
+			if e != nil && (head == nil || newerThan(*e, *head)) {
+				best, head = s, e
+			}
+		}
+		if best == nil {
+			break
+		}
+		if limit > 0 && len(page.Entries) == limit {
+			page.HasMore = true
+			break
+		}
+		if offset > 0 {
+			offset--
+		} else {
+			page.Entries = append(page.Entries, *head)
+		}
+		best.next()
+	}
+
+	return page, nil
+}
+
+// hashtagPosts loads the posts behind a page of index entries, noting the tag
+// through which each one matched. Posts that were deleted since they were
+// indexed are skipped.
+func (p *Plugin) hashtagPosts(entries []indexedPost, matcher tagMatcher, users map[string]*model.User) []HashtagPost {
+	result := make([]HashtagPost, 0, len(entries))
+	for _, entry := range entries {
+		post, appErr := p.API.GetPost(entry.ID)
+		if appErr != nil {
+			p.API.LogWarn("Indexed post could not be loaded", "error", appErr.Error(), "post_id", entry.ID)
+			continue
+		}
+		user := p.cachedUser(users, entry.UserID)
+		if user == nil {
+			continue
+		}
+		matched, _ := matcher.match(entry)
+
+		result = append(result, HashtagPost{
+			ID:         post.Id,
+			Message:    post.Message,
+			CreateAt:   post.CreateAt,
+			Username:   user.Username,
+			ChannelID:  post.ChannelId,
+			MatchedTag: matched,
+		})
+	}
+	return result
+}
+
+// scanChannelTagEntries walks the posts of a channel that has not been
+// indexed yet and returns the entries of the posts the matcher selects.
+func (p *Plugin) scanChannelTagEntries(matcher tagMatcher, channelID string, window timeRange, users map[string]*model.User) ([]indexedPost, error) {
+	var result []indexedPost
+	err := p.scanChannelEntries(channelID, window, users, func(entry indexedPost) bool {
+		if _, ok := matcher.match(entry); ok {
+			result = append(result, entry)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return newerThan(result[i], result[j])
+	})
+	return result, nil
+}
+
+// walkChannelEntries calls fn with every tagged post of a channel created
+// within a time range, until fn returns false. Indexed channels are read from
+// the month logs overlapping the range, newest first; other channels are
+// scanned.
+func (p *Plugin) walkChannelEntries(channelID string, window timeRange, users map[string]*model.User, fn func(entry indexedPost) bool) error {
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil {
+		return err
+	}
+	if idx == nil || !idx.Indexed {
+		return p.scanChannelEntries(channelID, window, users, fn)
+	}
+
+	for _, month := range idx.Months {
+		if !window.containsMonth(month) {
+			continue
+		}
+		entries, err := p.getChannelLog(channelID, month)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if window.contains(e.CreateAt) && !fn(e) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// scanPageSize is the number of posts loaded per page by channel scans.
+const scanPageSize = 200
+
+// scanChannelEntries walks the posts of a channel created within a time range
+// and calls fn with the index entry of each post the index would keep, until
+// fn returns false. Posts are paged newest first and the walk stops at the
+// first post older than the range. With an upper bound, paging starts at the
+// newest post within it rather than at the newest post of the channel.
+func (p *Plugin) scanChannelEntries(channelID string, window timeRange, users map[string]*model.User, fn func(entry indexedPost) bool) error {
+	var posts *model.PostList
+	var appErr *model.AppError
+	if window.Until > 0 {
+		var err error
+		if posts, err = p.postAtOrBefore(channelID, window.Until); err != nil {
+			return err
+		}
+	} else {
+		posts, appErr = p.API.GetPostsForChannel(channelID, 0, scanPageSize)
+		if appErr != nil {
+			return fmt.Errorf("failed to get posts: %w", appErr)
+		}
+	}
+
+	for posts != nil && len(posts.Order) > 0 {
+		for _, id := range posts.Order {
+			post := posts.Posts[id]
+			if post == nil || post.ChannelId != channelID {
+				continue
+			}
+			if window.Since > 0 && post.CreateAt < window.Since {
+				return nil
+			}
+			if !window.contains(post.CreateAt) {
+				continue
+			}
+
+			// Skips system and bot posts like the index does
+			entry := p.indexEntryForPost(post, users)
+			if len(entry.Tags) == 0 {
+				continue
+			}
+			if !fn(entry) {
+				return nil
+			}
+		}
+
+		p.API.LogDebug("Processed posts from channel", "channel_id", channelID, "count", len(posts.Order))
+
+		oldest := posts.Order[len(posts.Order)-1]
+		posts, appErr = p.API.GetPostsBefore(channelID, oldest, 0, scanPageSize)
+		if appErr != nil {
+			return fmt.Errorf("failed to get posts: %w", appErr)
+		}
+	}
+	return nil
+}
+
+// postAtOrBefore returns the newest post of a channel created at or before a
+// time, or nil if there is none. The plugin API cannot look posts up by time,
+// so it gallops over single-post pages from the newest post and then bisects,
+// loading about twice the logarithm of the number of newer posts.
+func (p *Plugin) postAtOrBefore(channelID string, until int64) (*model.PostList, error) {
+	probe := func(offset int) (*model.PostList, bool, error) {
+		posts, appErr := p.API.GetPostsForChannel(channelID, offset, 1)
+		if appErr != nil {
+			return nil, false, fmt.Errorf("failed to get posts: %w", appErr)
+		}
+		if posts == nil || len(posts.Order) == 0 {
+			return nil, true, nil
+		}
+		post := posts.Posts[posts.Order[0]]
+		return posts, post == nil || post.CreateAt <= until, nil
+	}
+
+	// Find an offset past the post: newer is the largest offset known to
+	// hold a newer post and older the smallest known not to
+	newer, older := -1, 0
+	var found *model.PostList
+	for {
+		posts, past, err := probe(older)
+		if err != nil {
+			return nil, err
+		}
+		if past {
+			found = posts
+			break
+		}
+		newer, older = older, max(2*older, 1)
+	}
+	for older-newer > 1 {
+		mid := newer + (older-newer)/2
+		posts, past, err := probe(mid)
+		if err != nil {
+			return nil, err
+		}
+		if past {
+			older, found = mid, posts
+		} else {
+			newer = mid
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagPostCursorRoundTrip(t *testing.T) {
+	for _, c := range []tagPostCursor{
+		{CreateAt: 1717200000000, PostID: "p4uxg6ihsfgbuqh9a8uydr5m5h"},
+		{CreateAt: 0, PostID: "a"},
+		{CreateAt: -1, PostID: "with:colon"},
+	} {
+		decoded, err := decodeTagPostCursor(c.encode())
+		require.NoError(t, err)
+		assert.Equal(t, c, *decoded)
+	}
+
+	for _, s := range []string{"", "!!!", tagPostCursor{CreateAt: 5}.encode(), "eDpwMQ", "MTIzNA"} {
+		_, err := decodeTagPostCursor(s)
+		assert.Error(t, err, "cursor %q", s)
+	}
+}
+
+func TestPageTagPostsAcrossChannels(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	june := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	may := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	channels := map[string][]indexedPost{
+		"channel1": {
+			{ID: "a", CreateAt: june + 3, Tags: []string{"release"}},
+			{ID: "c", CreateAt: june + 2, Tags: []string{"Release"}},
+			{ID: "e", CreateAt: may, Tags: []string{"release"}},
+		},
+		"channel2": {
+			{ID: "b", CreateAt: june + 3, Tags: []string{"release"}},
+			{ID: "d", CreateAt: june + 2, Tags: []string{"release"}},
+			{ID: "f", CreateAt: june + 2, Tags: []string{"release", "alpha"}},
+			{ID: "g", CreateAt: june + 1, Tags: []string{"alpha"}},
+		},
+	}
+	for channelID, entries := range channels {
+		require.NoError(t, p.withChannelIndex(channelID, func(b *indexBatch) error {
+			for _, e := range entries {
+				e.ChannelID = channelID
+				e.UserID = "user1"
+				if err := b.put(e); err != nil {
+					return err
+				}
+			}
+			b.idx.Indexed = true
+			return nil
+		}))
+	}
+
+	// Ties on CreateAt are broken by post id across channels too
+	want := []string{"b", "a", "f", "d", "c", "e"}
+	matcher := tagAnyOf{"release"}
+	channelIDs := []string{"channel1", "channel2"}
+	ids := func(page *tagPostPage) []string {
+		var out []string
+		for _, e := range page.Entries {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+
+	all, err := p.pageTagPosts(matcher, channelIDs, timeRange{}, nil, 0, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, want, ids(all))
+	assert.Equal(t, 6, all.Total)
+	assert.False(t, all.HasMore)
+
+	var byCursor, byOffset []string
+	var cursor *tagPostCursor
+	for offset := 0; ; offset += 2 {
+		page, err := p.pageTagPosts(matcher, channelIDs, timeRange{}, cursor, 0, 2, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 6, page.Total)
+		byCursor = append(byCursor, ids(page)...)
+
+		paged, err := p.pageTagPosts(matcher, channelIDs, timeRange{}, nil, offset, 2, nil)
+		require.NoError(t, err)
+		assert.Equal(t, ids(page), ids(paged))
+		assert.Equal(t, page.HasMore, paged.HasMore)
+		byOffset = append(byOffset, ids(paged)...)
+
+		if !page.HasMore {
+			break
+		}
+		last := page.Entries[len(page.Entries)-1]
+		cursor, err = decodeTagPostCursor(tagPostCursor{CreateAt: last.CreateAt, PostID: last.ID}.encode())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, want, byCursor)
+	assert.Equal(t, want, byOffset)
+}
+
+func TestScanChannelEntries(t *testing.T) {
+	messages := make([]string, 1200)
+	for i := range messages {
+		messages[i] = "#release"
+	}
+	p := &Plugin{}
+	p.SetAPI(newMessagesAPI(messages))
+
+	// Posts are created at 1000 to 2199, and the bot post at 5000
+	for _, tc := range []struct {
+		name     string
+		window   timeRange
+		count    int
+		newest   int64
+		lastSeen int64
+	}{
+		{"whole channel", timeRange{}, 1200, 2199, 1000},
+		{"more posts since than one call used to return", timeRange{Since: 1100}, 1100, 2199, 1100},
+		{"until only", timeRange{Until: 1500}, 501, 1500, 1000},
+		{"both bounds", timeRange{Since: 1100, Until: 1500}, 401, 1500, 1100},
+		{"until after every post", timeRange{Until: 9999}, 1200, 2199, 1000},
+		{"until before every post", timeRange{Until: 500}, 0, 0, 0},
+		{"since after every post", timeRange{Since: 9999}, 0, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen []int64
+			err := p.scanChannelEntries("channel1", tc.window, map[string]*model.User{}, func(e indexedPost) bool {
+				seen = append(seen, e.CreateAt)
+				return true
+			})
+			require.NoError(t, err)
+			require.Len(t, seen, tc.count)
+			if tc.count > 0 {
+				assert.Equal(t, tc.newest, seen[0])
+				assert.Equal(t, tc.lastSeen, seen[len(seen)-1])
+			}
+			assert.True(t, sort.SliceIsSorted(seen, func(i, j int) bool { return seen[i] > seen[j] }))
+		})
+	}
+
+	seen := 0
+	err := p.scanChannelEntries("channel1", timeRange{}, map[string]*model.User{}, func(e indexedPost) bool {
+		seen++
+		return seen < 3
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, seen)
+}
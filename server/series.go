@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// indexSeriesKeyPrefix holds the counters of a tag in a channel, one key per
+// UTC month so a post only rewrites the counters of its own month:
+//
+//	idx_series_<channel id>_<tag hash>_<yyyymm>  the tagSeries of one tag in one channel and month
+//
+// Tags are hashed to keep keys within the KV store's length limit.
+const indexSeriesKeyPrefix = "idx_series_"
+
+const (
+	bucketDay   = "day"
+	bucketWeek  = "week"
+	bucketMonth = "month"
+
+	// seriesCellMillis is the span of one counter. Quarter hours line up with
+	// the day boundaries of every timezone in use, including those offset by
+	// half or three quarters of an hour such as India and Nepal.
+	seriesCellMillis = int64(15 * time.Minute / time.Millisecond)
+)
+
+// seriesCell counts the posts using a tag within one quarter hour, with the
+// number of those posts per author so authors can be removed again.
+type seriesCell struct {
+	Posts   int            `json:"posts"`
+	Authors map[string]int `json:"authors"`
+}
+
+// tagSeries holds the counters of one tag in one channel and month, keyed by
+// the number of quarter hours since the epoch.
+type tagSeries struct {
+	Cells map[string]*seriesCell `json:"cells"`
+}
+
+// tagMonth identifies the counters of one tag in one month.
+type tagMonth struct {
+	key   string
+	month string
+}
+
+func indexSeriesKey(channelID, key, month string) string {
+	sum := sha256.Sum256([]byte(key))
+	return indexSeriesKeyPrefix + channelID + "_" + hex.EncodeToString(sum[:16]) + "_" + month
+}
+
+func seriesCellKey(createAt int64) string {
+	return strconv.FormatInt(createAt/seriesCellMillis, 10)
+}
+
+func (p *Plugin) getTagSeries(channelID, key, month string) (*tagSeries, error) {
+	series := &tagSeries{}
+	if _, err := p.kvGetJSON(indexSeriesKey(channelID, key, month), series); err != nil {
+		return nil, err
+	}
+	if series.Cells == nil {
+		series.Cells = map[string]*seriesCell{}
+	}
+	return series, nil
+}
+
+// series returns the counters of a tag for the month of createAt within the
+// batch, loading them once.
+func (b *indexBatch) series(key string, createAt int64) (*tagSeries, error) {
+	tm := tagMonth{key: key, month: indexMonth(createAt)}
+	if series, ok := b.seriesByMonth[tm]; ok {
+		return series, nil
+	}
+	series, err := b.p.getTagSeries(b.idx.ChannelID, tm.key, tm.month)
+	if err != nil {
+		return nil, err
+	}
+	b.seriesByMonth[tm] = series
+	return series, nil
+}
+
+// addSeries counts an entry in the counters of each of its tags.
+func (b *indexBatch) addSeries(entry indexedPost) error {
+	cellKey := seriesCellKey(entry.CreateAt)
+	for _, tag := range entry.Tags {
+		series, err := b.series(canonicalTag(tag), entry.CreateAt)
+		if err != nil {
+			return err
+		}
+		cell, ok := series.Cells[cellKey]
+		if !ok {
+			cell = &seriesCell{Authors: map[string]int{}}
+			series.Cells[cellKey] = cell
+		}
+		cell.Posts++
+		cell.Authors[entry.UserID]++
+	}
+	return nil
+}
+
+// removeSeries undoes addSeries. Counters that were never recorded, as for
+// posts indexed before the series existed, are left alone.
+func (b *indexBatch) removeSeries(entry indexedPost) error {
+	cellKey := seriesCellKey(entry.CreateAt)
+	for _, tag := range entry.Tags {
+		series, err := b.series(canonicalTag(tag), entry.CreateAt)
+		if err != nil {
+			return err
+		}
+		cell, ok := series.Cells[cellKey]
+		if !ok || cell.Authors[entry.UserID] == 0 {
+			continue
+		}
+		cell.Posts--
+		cell.Authors[entry.UserID]--
+		if cell.Authors[entry.UserID] == 0 {
+			delete(cell.Authors, entry.UserID)
+		}
+		if cell.Posts <= 0 {
+			delete(series.Cells, cellKey)
+		}
+	}
+	return nil
+}
+
+func (b *indexBatch) commitSeries() error {
+	for tm, series := range b.seriesByMonth {
+		kvKey := indexSeriesKey(b.idx.ChannelID, tm.key, tm.month)
+		if len(series.Cells) == 0 {
+			if appErr := b.p.API.KVDelete(kvKey); appErr != nil {
+				return fmt.Errorf("failed to delete %s: %w", kvKey, appErr)
+			}
+			continue
+		}
+		if err := b.p.kvSetJSON(kvKey, series); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TimeseriesPoint is one bucket of a tag's usage. Start is the beginning of
+// the bucket in the requested timezone and Label its date there.
+type TimeseriesPoint struct {
+	Start   int64  `json:"start"`
+	Label   string `json:"label"`
+	Posts   int    `json:"posts"`
+	Authors int    `json:"authors"`
+}
+
+type TagTimeseriesResponse struct {
+	Tag      string            `json:"tag"`
+	Bucket   string            `json:"bucket"`
+	Timezone string            `json:"timezone"`
+	Since    int64             `json:"since"`
+	Until    int64             `json:"until"`
+	Points   []TimeseriesPoint `json:"points"`
+}
+
+// bucketStart returns the start of the bucket holding t, in t's location.
+// Weeks start on Monday.
+func bucketStart(t time.Time, bucket string) time.Time {
+	y, m, d := t.Date()
+	switch bucket {
+	case bucketMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case bucketWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case bucketMonth:
+		return t.AddDate(0, 1, 0)
+	case bucketWeek:
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// defaultSeriesRange is how far back a timeseries goes without since.
+func defaultSeriesRange(bucket string) time.Duration {
+	switch bucket {
+	case bucketMonth:
+		return 365 * 24 * time.Hour
+	case bucketWeek:
+		return 12 * 7 * 24 * time.Hour
+	default:
+		return 30 * 24 * time.Hour
+	}
+}
+
+// seriesBuilder groups posts into buckets, counting each author once per bucket.
+type seriesBuilder struct {
+	bucket  string
+	loc     *time.Location
+	window  timeRange
+	posts   map[int64]int
+	authors map[int64]map[string]bool
+}
+
+func newSeriesBuilder(bucket string, loc *time.Location, window timeRange) *seriesBuilder {
+	return &seriesBuilder{
+		bucket:  bucket,
+		loc:     loc,
+		window:  window,
+		posts:   map[int64]int{},
+		authors: map[int64]map[string]bool{},
+	}
+}
+
+// add counts posts created at createAt by the given authors.
+func (s *seriesBuilder) add(createAt int64, posts int, authors ...string) {
+	if !s.window.contains(createAt) {
+		return
+	}
+	start := bucketStart(time.UnixMilli(createAt).In(s.loc), s.bucket).UnixMilli()
+	s.posts[start] += posts
+	if s.authors[start] == nil {
+		s.authors[start] = map[string]bool{}
+	}
+	for _, author := range authors {
+		s.authors[start][author] = true
+	}
+}
+
+// addSeries counts stored counters, placing each cell by its start.
+func (s *seriesBuilder) addSeries(series *tagSeries) {
+	for cellKey, cell := range series.Cells {
+		n, err := strconv.ParseInt(cellKey, 10, 64)
+		if err != nil {
+			continue
+		}
+		authors := make([]string, 0, len(cell.Authors))
+		for author := range cell.Authors {
+			authors = append(authors, author)
+		}
+		s.add(n*seriesCellMillis, cell.Posts, authors...)
+	}
+}
+
+// points returns every bucket of the window in order, empty ones included.
+func (s *seriesBuilder) points() []TimeseriesPoint {
+	points := []TimeseriesPoint{}
+	end := time.UnixMilli(s.window.Until).In(s.loc)
+	for t := bucketStart(time.UnixMilli(s.window.Since).In(s.loc), s.bucket); !t.After(end); t = nextBucket(t, s.bucket) {
+		start := t.UnixMilli()
+		label := t.Format("2006-01-02")
+		if s.bucket == bucketMonth {
+			label = t.Format("2006-01")
+		}
+		points = append(points, TimeseriesPoint{
+			Start:   start,
+			Label:   label,
+			Posts:   s.posts[start],
+			Authors: len(s.authors[start]),
+		})
+	}
+	return points
+}
+
+// addChannelSeries adds the usage of the tag keys in one channel, from the
+// series counters once the channel's history has been indexed with them, from
+// the month logs of the tag if it was indexed before they existed, and by
+// scanning the channel otherwise. The counters are kept per key, so a channel
+// using several keys, which one post may combine, is read from the logs too.
+func (p *Plugin) addChannelSeries(s *seriesBuilder, keys tagAnyOf, channelID string, users map[string]*model.User) error {
+	idx, err := p.getChannelIndex(channelID)
+	if err != nil {
+		return err
+	}
+
+	addEntry := func(e indexedPost) bool {
+		if _, ok := keys.match(e); ok {
+			s.add(e.CreateAt, 1, e.UserID)
+		}
+		return true
+	}
+
+	switch {
+	case idx == nil || !idx.Indexed:
+		return p.scanChannelEntries(channelID, s.window, users, addEntry)
+	case idx.Series && keys.used(idx) <= 1:
+		for _, key := range keys {
+			stats, ok := idx.Tags[key]
+			if !ok {
+				continue
+			}
+			for month := range stats.Months {
+				if !s.window.containsMonth(month) {
+					continue
+				}
+				series, err := p.getTagSeries(channelID, key, month)
+				if err != nil {
+					return err
+				}
+				s.addSeries(series)
+			}
+		}
+		return nil
+	default:
+		for month := range (tagInRange{term: keys, window: s.window}).months(idx) {
+			entries, err := p.getChannelLog(channelID, month)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				addEntry(e)
+			}
+		}
+		return nil
+	}
+}
+
+// tagTimeseries buckets the usage of a tag over a set of channels.
+func (p *Plugin) tagTimeseries(tag string, channelIDs []string, bucket string, loc *time.Location, window timeRange) (*TagTimeseriesResponse, error) {
+	tag = normalizeTagQuery(tag)
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	keys := tagAnyOf(expandTag(tag, aliases))
+
+	s := newSeriesBuilder(bucket, loc, window)
+	users := map[string]*model.User{}
+	for _, channelID := range channelIDs {
+		if err := p.addChannelSeries(s, keys, channelID, users); err != nil {
+			if len(channelIDs) == 1 {
+				return nil, err
+			}
+			p.API.LogError("Failed to get tag timeseries for channel", "error", err.Error(), "channel_id", channelID)
+		}
+	}
+
+	return &TagTimeseriesResponse{
+		Tag:      keys[0],
+		Bucket:   bucket,
+		Timezone: loc.String(),
+		Since:    window.Since,
+		Until:    window.Until,
+		Points:   s.points(),
+	}, nil
+}
+
+// GET /api/tag_timeseries?tag=XXX&bucket=day&scope=channel&channel_id=YYY&since=30d
+// GET /api/tag_timeseries?tag=XXX&bucket=week&scope=team&team_id=YYY&tz=Europe/Paris
+func (p *Plugin) handleTagTimeseries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tag := normalizeTagQuery(query.Get("tag"))
+	if tag == "" {
+		http.Error(w, "tag is required", http.StatusBadRequest)
+		return
+	}
+
+	bucket := query.Get("bucket")
+	switch bucket {
+	case "":
+		bucket = bucketDay
+	case bucketDay, bucketWeek, bucketMonth:
+	default:
+		http.Error(w, "bucket must be day, week or month", http.StatusBadRequest)
+		return
+	}
+
+	// The user's own timezone unless the client asks for another one
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			http.Error(w, "invalid tz", http.StatusBadRequest)
+			return
+		}
+	} else if user, appErr := p.API.GetUser(r.Header.Get("Mattermost-User-ID")); appErr == nil {
+		loc = user.GetTimezoneLocation()
+	}
+
+	now := time.Now()
+	window, err := parseTimeRange(query, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if window.Until == 0 {
+		window.Until = now.UnixMilli()
+	}
+	if window.Since == 0 {
+		window.Since = time.UnixMilli(window.Until).Add(-defaultSeriesRange(bucket)).UnixMilli()
+	}
+
+	channelIDs, status, err := p.scopeChannelIDs(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	response, err := p.tagTimeseries(tag, channelIDs, bucket, loc, window)
+	if err != nil {
+		p.API.LogError("Failed to compute tag timeseries", "error", err.Error(), "tag", tag)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
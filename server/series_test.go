@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesBuilderBuckets(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	// 2024-03-03 is a Sunday; 23:30 UTC is already Monday in Paris
+	sundayLate := time.Date(2024, 3, 3, 23, 30, 0, 0, time.UTC).UnixMilli()
+	monday := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC).UnixMilli()
+	window := timeRange{
+		Since: time.Date(2024, 3, 1, 0, 0, 0, 0, paris).UnixMilli(),
+		Until: time.Date(2024, 3, 10, 0, 0, 0, 0, paris).UnixMilli(),
+	}
+
+	days := newSeriesBuilder(bucketDay, paris, window)
+	days.add(sundayLate, 1, "alice")
+	days.add(monday, 1, "alice")
+	days.add(monday, 1, "bob")
+	days.add(window.Until+1, 1, "carol")
+
+	points := days.points()
+	require.Len(t, points, 10)
+	assert.Equal(t, "2024-03-01", points[0].Label)
+	assert.Equal(t, TimeseriesPoint{
+		Start:   time.Date(2024, 3, 4, 0, 0, 0, 0, paris).UnixMilli(),
+		Label:   "2024-03-04",
+		Posts:   3,
+		Authors: 2,
+	}, points[3])
+	assert.Zero(t, points[2].Posts)
+
+	utc := newSeriesBuilder(bucketWeek, time.UTC, window)
+	utc.add(sundayLate, 1, "alice")
+	utc.add(monday, 1, "alice")
+	weeks := utc.points()
+	require.Len(t, weeks, 2)
+	assert.Equal(t, "2024-02-26", weeks[0].Label)
+	assert.Equal(t, 1, weeks[0].Posts)
+	assert.Equal(t, 1, weeks[1].Posts)
+	assert.Equal(t, 1, weeks[1].Authors)
+}
+
+func TestIndexBatchSeries(t *testing.T) {
+	b := &indexBatch{
+		idx: &channelIndex{ChannelID: "channel1"},
+		seriesByMonth: map[tagMonth]*tagSeries{
+			{key: "release", month: "197001"}: {Cells: map[string]*seriesCell{}},
+			{key: "alpha", month: "197001"}:   {Cells: map[string]*seriesCell{}},
+		},
+	}
+	release := b.seriesByMonth[tagMonth{key: "release", month: "197001"}]
+	alpha := b.seriesByMonth[tagMonth{key: "alpha", month: "197001"}]
+	first := indexedPost{ID: "p1", UserID: "alice", CreateAt: 3 * seriesCellMillis, Tags: []string{"Release", "alpha"}}
+	second := indexedPost{ID: "p2", UserID: "bob", CreateAt: 3*seriesCellMillis + 10, Tags: []string{"release"}}
+
+	require.NoError(t, b.addSeries(first))
+	require.NoError(t, b.addSeries(second))
+	assert.Equal(t, &seriesCell{Posts: 2, Authors: map[string]int{"alice": 1, "bob": 1}}, release.Cells["3"])
+
+	require.NoError(t, b.removeSeries(first))
+	assert.Equal(t, &seriesCell{Posts: 1, Authors: map[string]int{"bob": 1}}, release.Cells["3"])
+	assert.Empty(t, alpha.Cells)
+
+	// Removing a post never counted leaves the counters alone
+	require.NoError(t, b.removeSeries(first))
+	assert.Equal(t, 1, release.Cells["3"].Posts)
+
+	builder := newSeriesBuilder(bucketDay, time.UTC, timeRange{Since: 0, Until: 24 * time.Hour.Milliseconds()})
+	builder.addSeries(release)
+	points := builder.points()
+	require.Len(t, points, 2)
+	assert.Equal(t, 1, points[0].Posts)
+	assert.Equal(t, 1, points[0].Authors)
+}
+
+// TestSeriesHalfHourOffset checks that stored counters land on the right day
+// in timezones offset from UTC by a fraction of an hour.
+func TestSeriesHalfHourOffset(t *testing.T) {
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	kathmandu, err := time.LoadLocation("Asia/Kathmandu")
+	require.NoError(t, err)
+
+	// 18:50 UTC on March 3rd is 00:20 on March 4th in Kolkata (+05:30) and
+	// 00:35 in Kathmandu (+05:45); 18:20 UTC is still March 3rd in Kolkata
+	late := time.Date(2024, 3, 3, 18, 50, 0, 0, time.UTC).UnixMilli()
+	early := time.Date(2024, 3, 3, 18, 20, 0, 0, time.UTC).UnixMilli()
+	require.NoError(t, p.withChannelIndex("channel1", func(b *indexBatch) error {
+		if err := b.put(indexedPost{ID: "p1", ChannelID: "channel1", UserID: "alice", CreateAt: late, Tags: []string{"release"}}); err != nil {
+			return err
+		}
+		if err := b.put(indexedPost{ID: "p2", ChannelID: "channel1", UserID: "bob", CreateAt: early, Tags: []string{"release"}}); err != nil {
+			return err
+		}
+		b.idx.Indexed = true
+		b.idx.Series = true
+		return nil
+	}))
+
+	for _, tc := range []struct {
+		loc     *time.Location
+		march3  int
+		march4  int
+		summary string
+	}{
+		{kolkata, 1, 1, "Kolkata"},
+		{kathmandu, 0, 2, "Kathmandu"},
+		{time.UTC, 2, 0, "UTC"},
+	} {
+		window := timeRange{
+			Since: time.Date(2024, 3, 3, 0, 0, 0, 0, tc.loc).UnixMilli(),
+			Until: time.Date(2024, 3, 4, 23, 0, 0, 0, tc.loc).UnixMilli(),
+		}
+		s := newSeriesBuilder(bucketDay, tc.loc, window)
+		require.NoError(t, p.addChannelSeries(s, tagAnyOf{"release"}, "channel1", nil))
+		points := s.points()
+		require.Len(t, points, 2, tc.summary)
+		assert.Equal(t, tc.march3, points[0].Posts, tc.summary)
+		assert.Equal(t, tc.march4, points[1].Posts, tc.summary)
+	}
+
+	// The counters of a month live under their own key
+	_, ok := api.kv[indexSeriesKey("channel1", "release", "202403")]
+	assert.True(t, ok)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// archivedTagsKey holds the archived tags: a map from a canonical tag to the
+// time it was archived. Archived tags are hidden from tag lists until they are
+// used again.
+const (
+	archivedTagsKey     = "archived_tags"
+	archivedTagsLockKey = "archived_tags_lock"
+)
+
+const (
+	defaultStaleDays = 90
+
+	tagReasonStale     = "stale"
+	tagReasonSingleUse = "single_use"
+	tagReasonOrphaned  = "orphaned"
+)
+
+// TagReportEntry is a tag flagged by the report. Reasons lists why: stale when
+// it has not been used for the report's number of days, single_use when only
+// one post uses it, and orphaned when its only author has been deactivated.
+// Author is set when the tag has a single author.
+type TagReportEntry struct {
+	Tag      string   `json:"tag"`
+	Count    int      `json:"count"`
+	Channels int      `json:"channels"`
+	Authors  int      `json:"authors"`
+	Author   string   `json:"author,omitempty"`
+	CreateAt int64    `json:"create_at"`
+	LastUsed int64    `json:"last_used"`
+	Reasons  []string `json:"reasons"`
+	Archived bool     `json:"archived"`
+}
+
+// TagReport lists the tags worth cleaning up, the most dormant first. Only
+// channels whose history has been indexed are covered; Unindexed counts those
+// still waiting for the backfill.
+type TagReport struct {
+	GeneratedAt int64            `json:"generated_at"`
+	StaleDays   int              `json:"stale_days"`
+	Channels    int              `json:"channels"`
+	Unindexed   int              `json:"unindexed"`
+	Tags        []TagReportEntry `json:"tags"`
+}
+
+// MergeTagRequest asks for Tag to be merged into Into.
+type MergeTagRequest struct {
+	Tag  string `json:"tag"`
+	Into string `json:"into"`
+}
+
+type ArchivedTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type ArchivedTagsResponse struct {
+	Archived map[string]int64 `json:"archived"`
+}
+
+func (p *Plugin) getArchivedTags() (map[string]int64, error) {
+	archived := map[string]int64{}
+	if _, err := p.kvGetJSON(archivedTagsKey, &archived); err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// updateArchivedTags applies fn to the archived tags and saves the result,
+// holding a lock so that concurrent edits by several admins are not lost.
+func (p *Plugin) updateArchivedTags(fn func(archived map[string]int64)) (map[string]int64, error) {
+	var archived map[string]int64
+	err := p.withLock(archivedTagsLockKey, func() error {
+		current, err := p.getArchivedTags()
+		if err != nil {
+			return err
+		}
+		fn(current)
+		archived = current
+		return p.kvSetJSON(archivedTagsKey, archived)
+	})
+	return archived, err
+}
+
+// hideArchivedTags removes the archived tags that have not been used since
+// they were archived from counts.
+func hideArchivedTags(counts map[string]*hashtagInfo, archived map[string]int64) {
+	for tag, archivedAt := range archived {
+		if info, ok := counts[tag]; ok && info.lastUsed <= archivedAt {
+			delete(counts, tag)
+		}
+	}
+}
+
+// mergeTagInto makes tag an alias of into, moving the aliases of tag along so
+// the alias table stays flat. Merging is applied when reading like any alias,
+// so it needs no re-indexing and can be undone by deleting the alias.
+func mergeTagInto(tag, into string, aliases map[string]string) error {
+	if tag == "" || into == "" {
+		return errors.New("tag and into are required")
+	}
+	for _, t := range []string{tag, into} {
+		if !isHashtag(t) {
+			return fmt.Errorf("%s is not a valid hashtag", t)
+		}
+	}
+	if target, ok := aliases[tag]; ok {
+		return fmt.Errorf("%s is already merged into %s", tag, target)
+	}
+	into = resolveTag(into, aliases)
+	if tag == into {
+		return errors.New("a tag cannot be merged into itself")
+	}
+	for alias, target := range aliases {
+		if target == tag {
+			aliases[alias] = into
+		}
+	}
+	aliases[tag] = into
+	return nil
+}
+
+// indexedChannelIDs lists the channels that may have an index, crawled or
+// not: every channel the backfill knows about, which includes any channel
+// indexed by a hook. Channels without an index yet are skipped by the callers.
+func (p *Plugin) indexedChannelIDs() ([]string, error) {
+	return p.getBackfillChannels()
+}
+
+// tagUsage accumulates what the report needs to know about one tag.
+type tagUsage struct {
+	count    int
+	createAt int64
+	lastUsed int64
+	channels map[string]bool
+	authors  map[string]bool
+}
+
+// tagReport reads the month logs of every indexed channel, optionally of one
+// team only, and flags the tags unused for staleDays, used once, or written
+// only by a deactivated user.
+func (p *Plugin) tagReport(teamID string, staleDays int, includeArchived bool, now time.Time) (*TagReport, error) {
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := p.getArchivedTags()
+	if err != nil {
+		return nil, err
+	}
+	channelIDs, err := p.indexedChannelIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TagReport{GeneratedAt: now.UnixMilli(), StaleDays: staleDays, Tags: []TagReportEntry{}}
+	usage := map[string]*tagUsage{}
+	for _, channelID := range channelIDs {
+		idx, err := p.getChannelIndex(channelID)
+		if err != nil {
+			return nil, err
+		}
+		if idx == nil || (teamID != "" && idx.TeamID != teamID) {
+			continue
+		}
+		if !idx.Indexed {
+			report.Unindexed++
+			continue
+		}
+		report.Channels++
+
+		for _, month := range idx.Months {
+			entries, err := p.getChannelLog(channelID, month)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				seen := map[string]bool{}
+				for _, t := range e.Tags {
+					key := resolveTag(canonicalTag(t), aliases)
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+					u, ok := usage[key]
+					if !ok {
+						u = &tagUsage{createAt: e.CreateAt, channels: map[string]bool{}, authors: map[string]bool{}}
+						usage[key] = u
+					}
+					u.count++
+					u.createAt = min(u.createAt, e.CreateAt)
+					u.lastUsed = max(u.lastUsed, e.CreateAt)
+					u.channels[channelID] = true
+					u.authors[e.UserID] = true
+				}
+			}
+		}
+	}
+
+	staleBefore := now.Add(-time.Duration(staleDays) * 24 * time.Hour).UnixMilli()
+	users := map[string]*model.User{}
+	for key, u := range usage {
+		archivedAt, isArchived := archived[key]
+		isArchived = isArchived && u.lastUsed <= archivedAt
+		if isArchived && !includeArchived {
+			continue
+		}
+
+		entry := TagReportEntry{
+			Tag:      key,
+			Count:    u.count,
+			Channels: len(u.channels),
+			Authors:  len(u.authors),
+			CreateAt: u.createAt,
+			LastUsed: u.lastUsed,
+			Reasons:  []string{},
+			Archived: isArchived,
+		}
+		if u.lastUsed < staleBefore {
+			entry.Reasons = append(entry.Reasons, tagReasonStale)
+		}
+		if u.count == 1 {
+			entry.Reasons = append(entry.Reasons, tagReasonSingleUse)
+		}
+		if len(u.authors) == 1 {
+			for userID := range u.authors {
+				if user := p.cachedUser(users, userID); user != nil {
+					entry.Author = user.Username
+					if user.DeleteAt != 0 {
+						entry.Reasons = append(entry.Reasons, tagReasonOrphaned)
+					}
+				}
+			}
+		}
+		if len(entry.Reasons) > 0 {
+			report.Tags = append(report.Tags, entry)
+		}
+	}
+	sort.Slice(report.Tags, func(i, j int) bool {
+		if report.Tags[i].LastUsed != report.Tags[j].LastUsed {
+			return report.Tags[i].LastUsed < report.Tags[j].LastUsed
+		}
+		return report.Tags[i].Tag < report.Tags[j].Tag
+	})
+	return report, nil
+}
+
+// writeTagReportCSV writes the report as CSV, one row per tag, with times in
+// RFC 3339 UTC.
+func writeTagReportCSV(w *csv.Writer, report *TagReport) error {
+	formatTime := func(millis int64) string {
+		return time.UnixMilli(millis).UTC().Format(time.RFC3339)
+	}
+	if err := w.Write([]string{"tag", "count", "channels", "authors", "author", "first_used", "last_used", "reasons", "archived"}); err != nil {
+		return err
+	}
+	for _, t := range report.Tags {
+		err := w.Write([]string{
+			t.Tag,
+			strconv.Itoa(t.Count),
+			strconv.Itoa(t.Channels),
+			strconv.Itoa(t.Authors),
+			t.Author,
+			formatTime(t.CreateAt),
+			formatTime(t.LastUsed),
+			strings.Join(t.Reasons, ";"),
+			strconv.FormatBool(t.Archived),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// GET /api/admin/tag_report?stale_days=90&team_id=XXX&include_archived=true
+// GET /api/admin/tag_report?format=csv
+func (p *Plugin) handleTagReport(w http.ResponseWriter, r *http.Request) {
+	if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	staleDays := defaultStaleDays
+	if s := query.Get("stale_days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid stale_days", http.StatusBadRequest)
+			return
+		}
+		staleDays = n
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	report, err := p.tagReport(query.Get("team_id"), staleDays, query.Get("include_archived") == "true", time.Now())
+	if err != nil {
+		p.API.LogError("Failed to compute tag report", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="hashtag-report.csv"`)
+		if err := writeTagReportCSV(csv.NewWriter(w), report); err != nil {
+			p.API.LogError("Failed to write response", "error", err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
+
+// GET /api/admin/archived_tags
+// POST /api/admin/archived_tags {"tags": ["old-project", "q3-planning"]}
+// DELETE /api/admin/archived_tags?tag=old-project
+func (p *Plugin) handleArchivedTags(w http.ResponseWriter, r *http.Request) {
+	if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var archived map[string]int64
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		archived, err = p.getArchivedTags()
+	case http.MethodPost:
+		var request ArchivedTagsRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Tags) == 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		now := model.GetMillis()
+		archived, err = p.updateArchivedTags(func(archived map[string]int64) {
+			for _, tag := range request.Tags {
+				if tag = normalizeTagQuery(tag); tag != "" {
+					archived[tag] = now
+				}
+			}
+		})
+	case http.MethodDelete:
+		archived, err = p.updateArchivedTags(func(archived map[string]int64) {
+			delete(archived, normalizeTagQuery(r.URL.Query().Get("tag")))
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ArchivedTagsResponse{Archived: archived}); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
+
+// POST /api/admin/merge_tag {"tag": "k8s-cluster", "into": "kubernetes"}
+func (p *Plugin) handleMergeTag(w http.ResponseWriter, r *http.Request) {
+	if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request MergeTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var invalid error
+	aliases, err := p.updateTagAliases(func(aliases map[string]string) error {
+		invalid = mergeTagInto(normalizeTagQuery(request.Tag), normalizeTagQuery(request.Into), aliases)
+		return invalid
+	})
+	if invalid != nil {
+		http.Error(w, invalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(TagAliasesResponse{Aliases: aliases}); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
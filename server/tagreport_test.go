@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagReport(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) int64 { return now.AddDate(0, 0, -n).UnixMilli() }
+
+	api := newTestAPI()
+	api.deactivated["gone"] = true
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	entries := []indexedPost{
+		{ID: "p6", UserID: "bob", CreateAt: daysAgo(1), Tags: []string{"active"}},
+		{ID: "p5", UserID: "alice", CreateAt: daysAgo(2), Tags: []string{"Active", "once"}},
+		{ID: "p4", UserID: "gone", CreateAt: daysAgo(10), Tags: []string{"ghost"}},
+		{ID: "p3", UserID: "gone", CreateAt: daysAgo(12), Tags: []string{"ghost"}},
+		{ID: "p2", UserID: "alice", CreateAt: daysAgo(200), Tags: []string{"legacy", "kube"}},
+		{ID: "p1", UserID: "bob", CreateAt: daysAgo(300), Tags: []string{"kubernetes"}},
+	}
+	months := map[string][]indexedPost{}
+	for _, e := range entries {
+		e.ChannelID = "channel1"
+		months[indexMonth(e.CreateAt)] = append(months[indexMonth(e.CreateAt)], e)
+	}
+	idx := &channelIndex{ChannelID: "channel1", TeamID: "team1", Indexed: true}
+	for month, log := range months {
+		idx.Months = append(idx.Months, month)
+		require.NoError(t, p.kvSetJSON(indexLogKey("channel1", month), log))
+	}
+	require.NoError(t, p.kvSetJSON(indexChannelKeyPrefix+"channel1", idx))
+	require.NoError(t, p.kvSetJSON(indexChannelKeyPrefix+"channel2", &channelIndex{ChannelID: "channel2", TeamID: "team1"}))
+	// channel3 is known to the backfill but has no index yet
+	_, err := p.registerBackfillChannels("channel1", "channel2", "channel3")
+	require.NoError(t, err)
+	require.NoError(t, p.kvSetJSON(tagAliasesKey, map[string]string{"kube": "kubernetes"}))
+
+	report, err := p.tagReport("", 90, false, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Channels)
+	assert.Equal(t, 1, report.Unindexed)
+
+	reasons := map[string][]string{}
+	var order []string
+	for _, tag := range report.Tags {
+		reasons[tag.Tag] = tag.Reasons
+		order = append(order, tag.Tag)
+	}
+	// #kubernetes has two authors through its alias, so it is only stale
+	assert.Equal(t, []string{"kubernetes", "legacy", "ghost", "once"}, order)
+	assert.Equal(t, []string{tagReasonStale}, reasons["kubernetes"])
+	assert.Equal(t, []string{tagReasonStale, tagReasonSingleUse}, reasons["legacy"])
+	assert.Equal(t, []string{tagReasonOrphaned}, reasons["ghost"])
+	assert.Equal(t, []string{tagReasonSingleUse}, reasons["once"])
+	assert.Equal(t, "gone", report.Tags[2].Author)
+	assert.Equal(t, 2, report.Tags[2].Count)
+
+	report, err = p.tagReport("team2", 90, false, now)
+	require.NoError(t, err)
+	assert.Empty(t, report.Tags)
+
+	require.NoError(t, p.kvSetJSON(archivedTagsKey, map[string]int64{"legacy": daysAgo(100)}))
+	report, err = p.tagReport("", 90, false, now)
+	require.NoError(t, err)
+	assert.Len(t, report.Tags, 3)
+	report, err = p.tagReport("", 90, true, now)
+	require.NoError(t, err)
+	require.Len(t, report.Tags, 4)
+	assert.True(t, report.Tags[1].Archived)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeTagReportCSV(csv.NewWriter(&buf), report))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "tag,count,channels,authors,author,first_used,last_used,reasons,archived", lines[0])
+	assert.Equal(t, "legacy,1,1,1,alice,2023-11-14T00:00:00Z,2023-11-14T00:00:00Z,stale;single_use,true", lines[2])
+}
+
+func TestHideArchivedTags(t *testing.T) {
+	counts := map[string]*hashtagInfo{}
+	hashtagInfoFor(counts, "dormant").add(3, 100, 200)
+	hashtagInfoFor(counts, "revived").add(3, 100, 900)
+	hashtagInfoFor(counts, "kept").add(1, 100, 100)
+
+	hideArchivedTags(counts, map[string]int64{"dormant": 500, "revived": 500, "unused": 500})
+	assert.NotContains(t, counts, "dormant")
+	assert.Contains(t, counts, "revived")
+	assert.Contains(t, counts, "kept")
+}
+
+func TestMergeTagInto(t *testing.T) {
+	aliases := map[string]string{"k8": "k8s", "kube": "kubernetes"}
+
+	require.NoError(t, mergeTagInto("k8s", "kube", aliases))
+	assert.Equal(t, map[string]string{"k8": "kubernetes", "k8s": "kubernetes", "kube": "kubernetes"}, aliases)
+
+	assert.Error(t, mergeTagInto("k8s", "other", aliases))
+	assert.Error(t, mergeTagInto("kubernetes", "kube", aliases))
+	assert.Error(t, mergeTagInto("", "kubernetes", aliases))
+	assert.Error(t, mergeTagInto("k8s-cluster", "k8s cluster", aliases))
+	assert.Error(t, mergeTagInto("k8s-cluster", "db", aliases))
+	assert.Error(t, mergeTagInto("k8s cluster", "kubernetes", aliases))
+	assert.NotContains(t, aliases, "k8s-cluster")
+}
+
+func TestHandleMergeTag(t *testing.T) {
+	api := &authAPI{testAPI: newTestAPI(), system: true}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	merge := func(body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/merge_tag", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin")
+		w := httptest.NewRecorder()
+		p.handleMergeTag(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, merge(`{"tag": "k8s", "into": "not a tag"}`))
+	aliases, err := p.getTagAliases()
+	require.NoError(t, err)
+	assert.Empty(t, aliases)
+
+	// Merges made at the same time are all kept
+	tags := []string{"k8s", "kube", "k8s-cluster", "kubes"}
+	var wg sync.WaitGroup
+	for _, tag := range tags {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, merge(`{"tag": "`+tag+`", "into": "kubernetes"}`))
+		}(tag)
+	}
+	wg.Wait()
+
+	aliases, err = p.getTagAliases()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"k8s": "kubernetes", "kube": "kubernetes", "k8s-cluster": "kubernetes", "kubes": "kubernetes"}, aliases)
+}
+
+func TestHandleArchivedTags(t *testing.T) {
+	api := &authAPI{testAPI: newTestAPI(), system: true}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// Tags archived at the same time are all kept
+	tags := []string{"old-project", "q3-planning", "retro", "offsite"}
+	var wg sync.WaitGroup
+	for _, tag := range tags {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/archived_tags", strings.NewReader(`{"tags": ["`+tag+`"]}`))
+			r.Header.Set("Mattermost-User-ID", "admin")
+			w := httptest.NewRecorder()
+			p.handleArchivedTags(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}(tag)
+	}
+	wg.Wait()
+
+	archived, err := p.getArchivedTags()
+	require.NoError(t, err)
+	assert.Len(t, archived, len(tags))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeRange limits counts and searches to posts created within [Since, Until]
+// in epoch milliseconds. A zero bound is open.
+type timeRange struct {
+	Since int64
+	Until int64
+}
+
+// bounded reports whether the range excludes anything.
+func (t timeRange) bounded() bool {
+	return t.Since > 0 || t.Until > 0
+}
+
+func (t timeRange) contains(createAt int64) bool {
+	return (t.Since == 0 || createAt >= t.Since) && (t.Until == 0 || createAt <= t.Until)
+}
+
+// containsMonth reports whether a month log may hold posts within the range.
+func (t timeRange) containsMonth(month string) bool {
+	return (t.Since == 0 || month >= indexMonth(t.Since)) && (t.Until == 0 || month <= indexMonth(t.Until))
+}
+
+// relativeTimeUnits are the units of relative times such as 7d.
+var relativeTimeUnits = map[string]time.Duration{
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseTimeParam reads a bound given as epoch milliseconds or as a time
+// relative to now such as 12h, 7d or 4w.
+func parseTimeParam(s string, now time.Time) (int64, error) {
+	if millis, err := strconv.ParseInt(s, 10, 64); err == nil && millis >= 0 {
+		return millis, nil
+	}
+	for suffix, unit := range relativeTimeUnits {
+		n, ok := strings.CutSuffix(s, suffix)
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(n)
+		if err != nil || count < 0 {
+			break
+		}
+		return now.Add(-time.Duration(count) * unit).UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid time %q, expected epoch milliseconds or a relative time such as 7d", s)
+}
+
+// parseTimeRange reads the since and until parameters of a request.
+func parseTimeRange(values url.Values, now time.Time) (timeRange, error) {
+	var t timeRange
+	var err error
+	if s := values.Get("since"); s != "" {
+		if t.Since, err = parseTimeParam(s, now); err != nil {
+			return timeRange{}, err
+		}
+	}
+	if s := values.Get("until"); s != "" {
+		if t.Until, err = parseTimeParam(s, now); err != nil {
+			return timeRange{}, err
+		}
+	}
+	if t.Since > 0 && t.Until > 0 && t.Since > t.Until {
+		return timeRange{}, fmt.Errorf("since must not be after until")
+	}
+	return t, nil
+}
+
+// tagInRange restricts a matcher to posts created within a time range.
+type tagInRange struct {
+	term   tagMatcher
+	window timeRange
+}
+
+func (m tagInRange) match(e indexedPost) (string, bool) {
+	if !m.window.contains(e.CreateAt) {
+		return "", false
+	}
+	return m.term.match(e)
+}
+
+func (m tagInRange) months(idx *channelIndex) map[string]bool {
+	months := m.term.months(idx)
+	for month := range months {
+		if !m.window.containsMonth(month) {
+			delete(months, month)
+		}
+	}
+	return months
+}
+
+func (m tagInRange) count(idx *channelIndex) (int, bool) {
+	if m.window.bounded() {
+		return 0, false
+	}
+	return m.term.count(idx)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	window, err := parseTimeRange(url.Values{"since": {"7d"}, "until": {"1699999999000"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-7*24*time.Hour).UnixMilli(), window.Since)
+	assert.Equal(t, int64(1_699_999_999_000), window.Until)
+
+	window, err = parseTimeRange(url.Values{"since": {"12h"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-12*time.Hour).UnixMilli(), window.Since)
+	assert.Zero(t, window.Until)
+
+	window, err = parseTimeRange(url.Values{}, now)
+	require.NoError(t, err)
+	assert.False(t, window.bounded())
+
+	_, err = parseTimeRange(url.Values{"since": {"7x"}}, now)
+	assert.Error(t, err)
+	_, err = parseTimeRange(url.Values{"since": {"1d"}, "until": {"2d"}}, now)
+	assert.Error(t, err)
+}
+
+func TestTimeRangeMonths(t *testing.T) {
+	since := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC).UnixMilli()
+	until := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	window := timeRange{Since: since, Until: until}
+
+	assert.False(t, window.containsMonth("202312"))
+	assert.True(t, window.containsMonth("202401"))
+	assert.True(t, window.containsMonth("202403"))
+	assert.False(t, window.containsMonth("202404"))
+	assert.True(t, timeRange{}.containsMonth("199001"))
+}
+
+// TestHashtagsInRange checks that every path honours since and until on a
+// channel that is not indexed.
+func TestHashtagsInRange(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(newMessagesAPI([]string{
+		"#alpha",        // 1000
+		"#alpha #beta",  // 1001
+		"#beta #gamma",  // 1002
+		"#alpha #gamma", // 1003
+		"#delta",        // 1004
+	}))
+
+	toMap := func(counts []HashtagCount) map[string]int {
+		m := map[string]int{}
+		for _, c := range counts {
+			m[c.Tag] = c.Count
+		}
+		return m
+	}
+
+	tags, err := p.computeHashtags("channel1", 0, timeRange{Since: 1001, Until: 1003})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"alpha": 2, "beta": 2, "gamma": 2}, toMap(tags))
+
+	tags, err = p.computeTeamHashtags("team1", 0, timeRange{Until: 1001})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"alpha": 2, "beta": 1}, toMap(tags))
+
+	page, err := p.pageTagPosts(tagAnyOf{"alpha"}, []string{"channel1"}, timeRange{Since: 1001}, nil, 0, 0, map[string]*model.User{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, int64(1003), page.Entries[0].CreateAt)
+	assert.Equal(t, int64(1001), page.Entries[1].CreateAt)
+}
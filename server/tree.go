@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// defaultTagTreeSeparators are used when the TagTreeSeparators setting is empty.
+const defaultTagTreeSeparators = "-/"
+
+// HashtagTreeNode is a node of the tag tree. Path is the tag prefix the node
+// stands for, and Tag is set when that prefix is itself a tag. Count is the
+// number of posts using the node's tag or any tag below it, and TagCount the
+// number of those tags.
+type HashtagTreeNode struct {
+	Name     string             `json:"name"`
+	Path     string             `json:"path"`
+	Tag      *HashtagCount      `json:"tag,omitempty"`
+	Count    int                `json:"count"`
+	TagCount int                `json:"tag_count"`
+	LastUsed int64              `json:"lastUsed"`
+	Children []*HashtagTreeNode `json:"children,omitempty"`
+}
+
+// buildHashtagTree arranges tags into a tree by splitting them on any of the
+// separator characters, at any depth. #proj/web/auth becomes proj > web > auth
+// with proj and proj/web counting every tag below them. Tags without a
+// separator are roots of their own. Counts are summed up the tree, so a post
+// using several tags below a node counts once per tag; hashtagTree recounts
+// those nodes.
+func buildHashtagTree(tags []HashtagCount, separators string) []*HashtagTreeNode {
+	root := &HashtagTreeNode{}
+	nodes := map[string]*HashtagTreeNode{}
+	for i := range tags {
+		tag := &tags[i]
+		parent := root
+		for _, seg := range splitTagPath(tag.Tag, separators) {
+			node, ok := nodes[seg.path]
+			if !ok {
+				node = &HashtagTreeNode{Name: seg.name, Path: seg.path}
+				nodes[seg.path] = node
+				parent.Children = append(parent.Children, node)
+			}
+			node.Count += tag.Count
+			node.TagCount++
+			if tag.LastUsed > node.LastUsed {
+				node.LastUsed = tag.LastUsed
+			}
+			parent = node
+		}
+		parent.Tag = tag
+	}
+
+	sortHashtagTree(root.Children)
+	return root.Children
+}
+
+// hashtagTree builds the tree of counted tags. A post tagged #team/a and
+// #team/b counts once at team, so the nodes gathering several tags are
+// recounted from the posts using those tags in the channels listed by
+// channelIDs, within a time range.
+func (p *Plugin) hashtagTree(hashtags []HashtagCount, channelIDs func() ([]string, error), window timeRange) ([]*HashtagTreeNode, error) {
+	separators := p.getConfiguration().tagTreeSeparators()
+	tree := buildHashtagTree(hashtags, separators)
+
+	shared := map[string]*HashtagTreeNode{}
+	var collect func(nodes []*HashtagTreeNode)
+	collect = func(nodes []*HashtagTreeNode) {
+		for _, node := range nodes {
+			if node.TagCount > 1 {
+				shared[node.Path] = node
+				collect(node.Children)
+			}
+		}
+	}
+	collect(tree)
+	if len(shared) == 0 {
+		return tree, nil
+	}
+
+	// The tags below a shared node, and the keys posts may spell them with
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]bool{}
+	var keys []string
+	for _, tag := range hashtags {
+		for _, seg := range splitTagPath(tag.Tag, separators) {
+			if shared[seg.path] != nil {
+				tags[tag.Tag] = true
+				keys = append(keys, expandTag(tag.Tag, aliases)...)
+				break
+			}
+		}
+	}
+
+	ids, err := channelIDs()
+	if err != nil {
+		return nil, err
+	}
+	page, err := p.pageTagPosts(tagAnyOf(keys), ids, window, nil, 0, 0, map[string]*model.User{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range shared {
+		node.Count = 0
+	}
+	for _, e := range page.Entries {
+		paths := map[string]bool{}
+		for _, t := range e.Tags {
+			tag := resolveTag(canonicalTag(t), aliases)
+			if !tags[tag] {
+				continue
+			}
+			for _, seg := range splitTagPath(tag, separators) {
+				if shared[seg.path] != nil {
+					paths[seg.path] = true
+				}
+			}
+		}
+		for path := range paths {
+			shared[path].Count++
+		}
+	}
+
+	sortHashtagTree(tree)
+	return tree, nil
+}
+
+type tagPathSegment struct {
+	name string
+	path string
+}
+
+// splitTagPath returns the segments of a tag with the prefix each one ends.
+// Empty segments, as in #a--b, are skipped.
+func splitTagPath(tag, separators string) []tagPathSegment {
+	var segments []tagPathSegment
+	start := 0
+	for i, r := range tag {
+		if !strings.ContainsRune(separators, r) {
+			continue
+		}
+		if i > start {
+			segments = append(segments, tagPathSegment{name: tag[start:i], path: tag[:i]})
+		}
+		start = i + len(string(r))
+	}
+	if start < len(tag) {
+		segments = append(segments, tagPathSegment{name: tag[start:], path: tag})
+	}
+	return segments
+}
+
+func sortHashtagTree(nodes []*HashtagTreeNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Count != nodes[j].Count {
+			return nodes[i].Count > nodes[j].Count
+		}
+		return nodes[i].Path < nodes[j].Path
+	})
+	for _, node := range nodes {
+		sortHashtagTree(node.Children)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildHashtagTree(t *testing.T) {
+	tags := []HashtagCount{
+		{Tag: "proj/web/auth", Count: 4, LastUsed: 100},
+		{Tag: "proj/web/login", Count: 1, LastUsed: 300},
+		{Tag: "proj-api", Count: 2, LastUsed: 200},
+		{Tag: "proj", Count: 1, LastUsed: 50},
+		{Tag: "release", Count: 3, LastUsed: 10},
+		{Tag: "v1.2", Count: 1, LastUsed: 10},
+	}
+
+	tree := buildHashtagTree(tags, "-/")
+	require.Len(t, tree, 3)
+
+	proj := tree[0]
+	assert.Equal(t, "proj", proj.Path)
+	assert.Equal(t, 8, proj.Count)
+	assert.Equal(t, 4, proj.TagCount)
+	assert.Equal(t, int64(300), proj.LastUsed)
+	require.NotNil(t, proj.Tag)
+	assert.Equal(t, 1, proj.Tag.Count)
+
+	require.Len(t, proj.Children, 2)
+	web := proj.Children[0]
+	assert.Equal(t, "web", web.Name)
+	assert.Equal(t, "proj/web", web.Path)
+	assert.Equal(t, 5, web.Count)
+	assert.Nil(t, web.Tag)
+	require.Len(t, web.Children, 2)
+	assert.Equal(t, "proj/web/auth", web.Children[0].Path)
+	assert.Equal(t, "proj-api", proj.Children[1].Path)
+
+	assert.Equal(t, "release", tree[1].Path)
+	assert.Equal(t, "v1.2", tree[2].Path)
+	assert.Empty(t, tree[2].Children)
+}
+
+func TestHashtagTreeCountsPosts(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(newMessagesAPI([]string{
+		"#team/a #team/b",
+		"#team/a",
+		"#team/c #other",
+		"#other",
+	}))
+
+	hashtags, err := p.computeHashtags("channel1", 0, timeRange{})
+	require.NoError(t, err)
+	channelIDs := func() ([]string, error) { return []string{"channel1"}, nil }
+	tree, err := p.hashtagTree(hashtags, channelIDs, timeRange{})
+	require.NoError(t, err)
+	require.Len(t, tree, 2)
+
+	team := tree[0]
+	assert.Equal(t, "team", team.Path)
+	assert.Equal(t, 3, team.Count)
+	assert.Equal(t, 3, team.TagCount)
+	require.Len(t, team.Children, 3)
+	assert.Equal(t, "team/a", team.Children[0].Path)
+	assert.Equal(t, 2, team.Children[0].Count)
+
+	assert.Equal(t, "other", tree[1].Path)
+	assert.Equal(t, 2, tree[1].Count)
+}
+
+func TestSplitTagPath(t *testing.T) {
+	assert.Equal(t, []tagPathSegment{
+		{name: "a", path: "a"},
+		{name: "b", path: "a--b"},
+		{name: "c", path: "a--b.c"},
+	}, splitTagPath("a--b.c", "-."))
+	assert.Equal(t, []tagPathSegment{{name: "solo", path: "solo"}}, splitTagPath("solo", "-"))
+}
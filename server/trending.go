@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultTrendingWindowDays = 7
+	defaultTrendingMinPosts   = 3
+	defaultTrendingLimit      = 20
+	maxTrendingLimit          = 100
+
+	// trendingBaselineWindows is the number of windows before the current one
+	// that a tag's usage is compared with.
+	trendingBaselineWindows = 4
+)
+
+// TrendingTag is a tag whose use in the latest window stands out against its
+// own history. Recent is the number of posts in the latest window and
+// Baseline the mean of the windows before it. Score is the z-score of Recent
+// against those windows.
+type TrendingTag struct {
+	Tag      string  `json:"tag"`
+	Display  string  `json:"display"`
+	Recent   int     `json:"recent"`
+	Baseline float64 `json:"baseline"`
+	Score    float64 `json:"score"`
+}
+
+type TrendingResponse struct {
+	Window   int64         `json:"window"`
+	Since    int64         `json:"since"`
+	Until    int64         `json:"until"`
+	MinPosts int           `json:"min_posts"`
+	Tags     []TrendingTag `json:"tags"`
+}
+
+// trendingOptions are the knobs of a trending computation.
+type trendingOptions struct {
+	window   time.Duration
+	minPosts int
+}
+
+// trendingOptions returns the configured trending window and support threshold.
+func (c *configuration) trendingOptions() trendingOptions {
+	opts := trendingOptions{
+		window:   defaultTrendingWindowDays * 24 * time.Hour,
+		minPosts: defaultTrendingMinPosts,
+	}
+	if c.TrendingWindowDays > 0 {
+		opts.window = time.Duration(c.TrendingWindowDays) * 24 * time.Hour
+	}
+	if c.TrendingMinPosts > 0 {
+		opts.minPosts = c.TrendingMinPosts
+	}
+	return opts
+}
+
+// trendCounts holds the posts per tag in each window, index 0 being the latest.
+type trendCounts struct {
+	windows  map[string][]int
+	variants map[string]map[string]int
+}
+
+// trendScore is the z-score of the latest window against the ones before it.
+// The standard deviation is floored at 1 so a tag with a flat history does not
+// get an infinite score from a single extra post.
+func trendScore(windows []int) (float64, float64) {
+	past := windows[1:]
+	mean := 0.0
+	for _, n := range past {
+		mean += float64(n)
+	}
+	mean /= float64(len(past))
+
+	variance := 0.0
+	for _, n := range past {
+		variance += (float64(n) - mean) * (float64(n) - mean)
+	}
+	stddev := math.Max(math.Sqrt(variance/float64(len(past))), 1)
+
+	return (float64(windows[0]) - mean) / stddev, mean
+}
+
+// trendingTags scores the tags of a set of channels, keeping those used at
+// least opts.minPosts times in the latest window.
+func (p *Plugin) trendingTags(channelIDs []string, now time.Time, opts trendingOptions) ([]TrendingTag, error) {
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	windowMillis := opts.window.Milliseconds()
+	until := now.UnixMilli()
+	window := timeRange{Since: until - windowMillis*(trendingBaselineWindows+1) + 1, Until: until}
+
+	counts := trendCounts{windows: map[string][]int{}, variants: map[string]map[string]int{}}
+	users := map[string]*model.User{}
+	for _, channelID := range channelIDs {
+		err := p.walkChannelEntries(channelID, window, users, func(e indexedPost) bool {
+			slot := int((until - e.CreateAt) / windowMillis)
+			seen := map[string]bool{}
+			for _, tag := range e.Tags {
+				key := resolveTag(canonicalTag(tag), aliases)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if counts.windows[key] == nil {
+					counts.windows[key] = make([]int, trendingBaselineWindows+1)
+					counts.variants[key] = map[string]int{}
+				}
+				counts.windows[key][slot]++
+				if canonicalTag(tag) == key {
+					counts.variants[key][tag]++
+				}
+			}
+			return true
+		})
+		if err != nil {
+			if len(channelIDs) == 1 {
+				return nil, err
+			}
+			p.API.LogError("Failed to count trending tags for channel", "error", err.Error(), "channel_id", channelID)
+		}
+	}
+
+	trending := []TrendingTag{}
+	for key, windows := range counts.windows {
+		if windows[0] < opts.minPosts {
+			continue
+		}
+		score, baseline := trendScore(windows)
+		info := &hashtagInfo{variants: counts.variants[key]}
+		display := key
+		if variants := info.sortedVariants(); len(variants) > 0 {
+			display = variants[0]
+		}
+		trending = append(trending, TrendingTag{
+			Tag:      key,
+			Display:  display,
+			Recent:   windows[0],
+			Baseline: baseline,
+			Score:    score,
+		})
+	}
+	sort.Slice(trending, func(i, j int) bool {
+		if trending[i].Score != trending[j].Score {
+			return trending[i].Score > trending[j].Score
+		}
+		if trending[i].Recent != trending[j].Recent {
+			return trending[i].Recent > trending[j].Recent
+		}
+		return trending[i].Tag < trending[j].Tag
+	})
+	return trending, nil
+}
+
+// sortHashtagsByTrend orders tags by trending score. Tags below the support
+// threshold keep their count order after the trending ones.
+func sortHashtagsByTrend(tags []HashtagCount, trending []TrendingTag) {
+	scores := map[string]float64{}
+	for _, t := range trending {
+		scores[t.Tag] = t.Score
+	}
+	for i := range tags {
+		if score, ok := scores[tags[i].Tag]; ok {
+			tags[i].Trend = &score
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := tags[i].Trend, tags[j].Trend
+		switch {
+		case a != nil && b != nil:
+			return *a > *b
+		case a != nil || b != nil:
+			return a != nil
+		default:
+			return tags[i].Count > tags[j].Count
+		}
+	})
+}
+
+// parseTrendingOptions reads the window and min_posts parameters of a
+// request over the configured defaults.
+func (p *Plugin) parseTrendingOptions(r *http.Request) (trendingOptions, error) {
+	opts := p.getConfiguration().trendingOptions()
+	if s := r.URL.Query().Get("window"); s != "" {
+		now := time.Now()
+		since, err := parseTimeParam(s, now)
+		if err != nil || since >= now.UnixMilli() {
+			return opts, errors.New("invalid window, expected a duration such as 7d")
+		}
+		opts.window = time.Duration(now.UnixMilli()-since) * time.Millisecond
+	}
+	if s := r.URL.Query().Get("min_posts"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return opts, errors.New("invalid min_posts")
+		}
+		opts.minPosts = n
+	}
+	return opts, nil
+}
+
+// GET /api/trending?scope=channel&channel_id=XXX&window=7d&min_posts=3&limit=20
+// GET /api/trending?scope=team&team_id=XXX
+func (p *Plugin) handleTrending(w http.ResponseWriter, r *http.Request) {
+	opts, err := p.parseTrendingOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultTrendingLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= maxTrendingLimit {
+			limit = n
+		}
+	}
+
+	channelIDs, status, err := p.scopeChannelIDs(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	now := time.Now()
+	tags, err := p.trendingTags(channelIDs, now, opts)
+	if err != nil {
+		p.API.LogError("Failed to compute trending tags", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	response := TrendingResponse{
+		Window:   opts.window.Milliseconds(),
+		Since:    now.UnixMilli() - opts.window.Milliseconds(),
+		Until:    now.UnixMilli(),
+		MinPosts: opts.minPosts,
+		Tags:     tags,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
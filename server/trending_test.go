@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrendScore(t *testing.T) {
+	score, baseline := trendScore([]int{10, 2, 2, 2, 2})
+	assert.InDelta(t, 2.0, baseline, 1e-9)
+	assert.InDelta(t, 8.0, score, 1e-9)
+
+	score, baseline = trendScore([]int{5, 4, 6, 4, 6})
+	assert.InDelta(t, 5.0, baseline, 1e-9)
+	assert.InDelta(t, 0.0, score, 1e-9)
+}
+
+func TestTrendingTags(t *testing.T) {
+	now := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	api := newMessagesAPI(nil)
+	add := func(message string, age time.Duration) {
+		post := &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "user1", CreateAt: now.Add(-age).UnixMilli(), Message: message}
+		api.posts[post.Id] = post
+		api.order = append(api.order, post.Id)
+	}
+
+	// #outage is new this week; #standup is steady every week
+	for week := 0; week < 5; week++ {
+		for i := 0; i < 3; i++ {
+			add("#standup", time.Duration(week)*7*day+time.Duration(i)*day+time.Hour)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		add("#Outage", time.Duration(i)*time.Hour)
+	}
+	add("#rare", time.Hour)
+	add("#outage", 60*day)
+	// The API serves posts newest first
+	sort.Slice(api.order, func(i, j int) bool {
+		return api.posts[api.order[i]].CreateAt > api.posts[api.order[j]].CreateAt
+	})
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	trending, err := p.trendingTags([]string{"channel1"}, now, trendingOptions{window: 7 * day, minPosts: 3})
+	require.NoError(t, err)
+
+	require.Len(t, trending, 2)
+	assert.Equal(t, TrendingTag{Tag: "outage", Display: "Outage", Recent: 4, Baseline: 0, Score: 4}, trending[0])
+	assert.Equal(t, "standup", trending[1].Tag)
+	assert.InDelta(t, 0.0, trending[1].Score, 1e-9)
+
+	tags := []HashtagCount{{Tag: "standup", Count: 15}, {Tag: "rare", Count: 1}, {Tag: "outage", Count: 5}}
+	sortHashtagsByTrend(tags, trending)
+	assert.Equal(t, []string{"outage", "standup", "rare"}, []string{tags[0].Tag, tags[1].Tag, tags[2].Tag})
+	require.NotNil(t, tags[0].Trend)
+	assert.Nil(t, tags[2].Trend)
+}
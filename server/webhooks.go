@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Outgoing webhooks live in the plugin KV store under these keys:
+//
+//	webhooks                 the TagWebhooks, in the order they were added
+//	webhook_queue            the webhookDeliveries waiting to be sent or retried
+//	webhook_payload_<id>     the body of one queued delivery
+//	webhook_queue_lock       cluster mutex guarding the queue
+//
+// The queue only holds the state of each delivery, so retrying one rewrites
+// a small value whatever the size of the bodies waiting.
+const (
+	webhooksKey             = "webhooks"
+	webhooksLockKey         = "webhooks_lock"
+	webhookQueueKey         = "webhook_queue"
+	webhookPayloadKeyPrefix = "webhook_payload_"
+	webhookQueueLockKey     = "webhook_queue_lock"
+
+	webhookJobKey      = "HashtagWebhooks"
+	webhookJobInterval = 30 * time.Second
+
+	webhookEventCreated = "post_created"
+	webhookEventEdited  = "post_edited"
+	webhookEventDeleted = "post_deleted"
+
+	// webhookSignatureHeader carries the hex HMAC-SHA256 of the body, keyed
+	// with the webhook's secret.
+	webhookSignatureHeader = "X-Hashtags-Signature"
+	webhookEventHeader     = "X-Hashtags-Event"
+	webhookDeliveryHeader  = "X-Hashtags-Delivery"
+
+	webhookTimeout = 10 * time.Second
+
+	// A delivery taken from the queue is hidden from other passes for
+	// webhookLease, so two nodes never send it at the same time.
+	webhookLease = 2 * time.Minute
+
+	// Failed deliveries are retried after webhookRetryDelay, doubling up to
+	// webhookMaxRetryDelay, and dropped after webhookMaxAttempts attempts.
+	webhookRetryDelay    = 30 * time.Second
+	webhookMaxRetryDelay = time.Hour
+	webhookMaxAttempts   = 8
+
+	maxWebhookQueue      = 1000
+	maxWebhookDeliveries = 50
+)
+
+var webhookEvents = []string{webhookEventCreated, webhookEventEdited, webhookEventDeleted}
+
+// TagWebhook sends the events of the posts using a tag matching Pattern to
+// URL. Pattern is a tag in which * stands for any run of characters, such as
+// incident or team:*. Events lists the events it wants, all of them if empty.
+type TagWebhook struct {
+	ID        string   `json:"id"`
+	Pattern   string   `json:"pattern"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret"`
+	Events    []string `json:"events,omitempty"`
+	CreatorID string   `json:"creator_id"`
+	CreateAt  int64    `json:"create_at"`
+}
+
+type TagWebhooksResponse struct {
+	Webhooks []TagWebhook `json:"webhooks"`
+	Pending  int          `json:"pending"`
+}
+
+// WebhookPayload is the JSON body POSTed to a webhook. Tags are the tags of
+// the post that matched the webhook: every one for created and deleted posts,
+// only those the edit added for edited ones.
+type WebhookPayload struct {
+	ID        string         `json:"id"`
+	Event     string         `json:"event"`
+	Timestamp int64          `json:"timestamp"`
+	Tags      []string       `json:"tags"`
+	Post      WebhookPost    `json:"post"`
+	Channel   WebhookChannel `json:"channel"`
+	Author    WebhookAuthor  `json:"author"`
+}
+
+type WebhookPost struct {
+	ID        string `json:"id"`
+	RootID    string `json:"root_id,omitempty"`
+	Message   string `json:"message"`
+	CreateAt  int64  `json:"create_at"`
+	UpdateAt  int64  `json:"update_at"`
+	Permalink string `json:"permalink"`
+}
+
+type WebhookChannel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+type WebhookAuthor struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// webhookDelivery is one payload waiting to be sent to one webhook. Payload
+// is saved under its own key and left out of the queue, except in deliveries
+// queued by versions that kept it inline.
+type webhookDelivery struct {
+	ID          string          `json:"id"`
+	WebhookID   string          `json:"webhook_id"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+func (p *Plugin) getWebhooks() ([]TagWebhook, error) {
+	var hooks []TagWebhook
+	if _, err := p.kvGetJSON(webhooksKey, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// updateWebhooks replaces the webhooks with what fn returns for them, holding
+// a lock so that concurrent edits by several admins are not lost.
+func (p *Plugin) updateWebhooks(fn func(hooks []TagWebhook) []TagWebhook) ([]TagWebhook, error) {
+	var hooks []TagWebhook
+	err := p.withLock(webhooksLockKey, func() error {
+		current, err := p.getWebhooks()
+		if err != nil {
+			return err
+		}
+		hooks = fn(current)
+		return p.kvSetJSON(webhooksKey, hooks)
+	})
+	return hooks, err
+}
+
+func (p *Plugin) getWebhookQueue() ([]webhookDelivery, error) {
+	var queue []webhookDelivery
+	if _, err := p.kvGetJSON(webhookQueueKey, &queue); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// tagPatternRegexp compiles a tag pattern in which * matches anything.
+func tagPatternRegexp(pattern string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(canonicalTag(pattern))
+	return regexp.Compile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+}
+
+// matchingTags returns the tags a webhook is interested in.
+func (h TagWebhook) matchingTags(tags []string) []string {
+	re, err := tagPatternRegexp(h.Pattern)
+	if err != nil {
+		return nil
+	}
+	var matched []string
+	for _, tag := range tags {
+		if re.MatchString(tag) {
+			matched = append(matched, tag)
+		}
+	}
+	return matched
+}
+
+func (h TagWebhook) wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// signWebhookPayload returns the hex HMAC-SHA256 of a body.
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelayAfter is how long to wait after a number of failed attempts.
+func webhookRetryDelayAfter(attempts int) time.Duration {
+	delay := webhookRetryDelay
+	for i := 1; i < attempts && delay < webhookMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxRetryDelay)
+}
+
+// queueWebhookEvent queues a delivery of an event to every webhook matching
+// one of the given tags and reports whether it queued any. Tags are canonical
+// keys with aliases resolved. Posts in direct and group messages are never
+// sent.
+func (p *Plugin) queueWebhookEvent(event string, post *model.Post, tags []string) (bool, error) {
+	if len(tags) == 0 {
+		return false, nil
+	}
+	hooks, err := p.getWebhooks()
+	if err != nil || len(hooks) == 0 {
+		return false, err
+	}
+
+	var deliveries []webhookDelivery
+	var payload *WebhookPayload
+	for _, hook := range hooks {
+		matched := hook.matchingTags(tags)
+		if len(matched) == 0 || !hook.wants(event) {
+			continue
+		}
+		if payload == nil {
+			channel, appErr := p.API.GetChannel(post.ChannelId)
+			if appErr != nil {
+				return false, fmt.Errorf("failed to get channel: %w", appErr)
+			}
+			if channel.IsGroupOrDirect() {
+				return false, nil
+			}
+			payload = &WebhookPayload{
+				Event:     event,
+				Timestamp: model.GetMillis(),
+				Post: WebhookPost{
+					ID:        post.Id,
+					RootID:    post.RootId,
+					Message:   post.Message,
+					CreateAt:  post.CreateAt,
+					UpdateAt:  post.UpdateAt,
+					Permalink: p.permalink(post.Id),
+				},
+				Channel: WebhookChannel{
+					ID:          channel.Id,
+					TeamID:      channel.TeamId,
+					Name:        channel.Name,
+					DisplayName: channel.DisplayName,
+				},
+				Author: WebhookAuthor{ID: post.UserId},
+			}
+			if user, appErr := p.API.GetUser(post.UserId); appErr == nil {
+				payload.Author.Username = user.Username
+			}
+		}
+
+		payload.ID = model.NewId()
+		payload.Tags = matched
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode webhook payload: %w", err)
+		}
+		deliveries = append(deliveries, webhookDelivery{
+			ID:        payload.ID,
+			WebhookID: hook.ID,
+			Event:     event,
+			Payload:   body,
+		})
+	}
+	if len(deliveries) == 0 {
+		return false, nil
+	}
+	for i := range deliveries {
+		if appErr := p.API.KVSet(webhookPayloadKeyPrefix+deliveries[i].ID, deliveries[i].Payload); appErr != nil {
+			return false, fmt.Errorf("failed to save webhook payload: %w", appErr)
+		}
+		deliveries[i].Payload = nil
+	}
+
+	var dropped []webhookDelivery
+	err = p.withLock(webhookQueueLockKey, func() error {
+		queue, err := p.getWebhookQueue()
+		if err != nil {
+			return err
+		}
+		queue = append(queue, deliveries...)
+		if n := len(queue) - maxWebhookQueue; n > 0 {
+			p.API.LogWarn("Webhook queue is full, dropping the oldest deliveries", "dropped", n)
+			dropped = queue[:n]
+			queue = queue[n:]
+		}
+		return p.kvSetJSON(webhookQueueKey, queue)
+	})
+	if err != nil {
+		p.deleteWebhookPayloads(deliveries)
+		return false, err
+	}
+	p.deleteWebhookPayloads(dropped)
+	return true, nil
+}
+
+// loadWebhookPayload reads the body of a delivery from its key, unless the
+// delivery still carries it. It returns false if the body is gone.
+func (p *Plugin) loadWebhookPayload(d *webhookDelivery) (bool, error) {
+	if len(d.Payload) > 0 {
+		return true, nil
+	}
+	data, appErr := p.API.KVGet(webhookPayloadKeyPrefix + d.ID)
+	if appErr != nil {
+		return false, fmt.Errorf("failed to get webhook payload: %w", appErr)
+	}
+	d.Payload = data
+	return data != nil, nil
+}
+
+// deleteWebhookPayloads deletes the bodies of deliveries that left the queue.
+func (p *Plugin) deleteWebhookPayloads(deliveries []webhookDelivery) {
+	for _, d := range deliveries {
+		if appErr := p.API.KVDelete(webhookPayloadKeyPrefix + d.ID); appErr != nil {
+			p.API.LogError("Failed to delete webhook payload", "error", appErr.Error(), "delivery_id", d.ID)
+		}
+	}
+}
+
+// deliverWebhook POSTs a delivery's payload, signed with the webhook's secret.
+func (p *Plugin) deliverWebhook(hook TagWebhook, d webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(hook.Secret, d.Payload))
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// processWebhookQueue sends the deliveries that are due at now. Deliveries
+// are leased while they are sent, then removed once delivered, retried with
+// backoff when they fail, and dropped after webhookMaxAttempts attempts or
+// when their webhook was deleted.
+func (p *Plugin) processWebhookQueue(now int64) error {
+	var due []webhookDelivery
+	err := p.withLock(webhookQueueLockKey, func() error {
+		queue, err := p.getWebhookQueue()
+		if err != nil {
+			return err
+		}
+		for i := range queue {
+			if queue[i].NextAttempt <= now && len(due) < maxWebhookDeliveries {
+				due = append(due, queue[i])
+				queue[i].NextAttempt = now + webhookLease.Milliseconds()
+			}
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		return p.kvSetJSON(webhookQueueKey, queue)
+	})
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	hooks, err := p.getWebhooks()
+	if err != nil {
+		return err
+	}
+	byID := map[string]TagWebhook{}
+	for _, hook := range hooks {
+		byID[hook.ID] = hook
+	}
+	results := map[string]error{}
+	for _, d := range due {
+		hook, ok := byID[d.WebhookID]
+		if !ok {
+			results[d.ID] = nil
+			continue
+		}
+		found, err := p.loadWebhookPayload(&d)
+		if err != nil {
+			return err
+		}
+		if !found {
+			p.API.LogWarn("Dropping webhook delivery without a payload", "webhook_id", d.WebhookID, "delivery_id", d.ID)
+			results[d.ID] = nil
+			continue
+		}
+		results[d.ID] = p.deliverWebhook(hook, d)
+	}
+
+	var removed []webhookDelivery
+	err = p.withLock(webhookQueueLockKey, func() error {
+		queue, err := p.getWebhookQueue()
+		if err != nil {
+			return err
+		}
+		kept := queue[:0]
+		for _, d := range queue {
+			result, ok := results[d.ID]
+			if !ok {
+				kept = append(kept, d)
+				continue
+			}
+			if result == nil {
+				removed = append(removed, d)
+				continue
+			}
+			d.Attempts++
+			d.LastError = result.Error()
+			if d.Attempts >= webhookMaxAttempts {
+				p.API.LogError("Dropping webhook delivery after too many attempts", "error", d.LastError, "webhook_id", d.WebhookID, "delivery_id", d.ID)
+				removed = append(removed, d)
+				continue
+			}
+			d.NextAttempt = now + webhookRetryDelayAfter(d.Attempts).Milliseconds()
+			kept = append(kept, d)
+		}
+		return p.kvSetJSON(webhookQueueKey, kept)
+	})
+	if err != nil {
+		return err
+	}
+	p.deleteWebhookPayloads(removed)
+	return nil
+}
+
+// runWebhookJob sends the due webhook deliveries.
+func (p *Plugin) runWebhookJob() {
+	if err := p.processWebhookQueue(model.GetMillis()); err != nil {
+		p.API.LogError("Failed to process webhook queue", "error", err.Error())
+	}
+}
+
+// postTagKeys returns the distinct canonical keys of tags with aliases
+// resolved, in the order the post used them.
+func postTagKeys(tags []string, aliases map[string]string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		key := resolveTag(canonicalTag(t), aliases)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// webhookPostEvent queues the event of a post using the given tags and, if
+// any webhook wants it, sends it right away rather than on the next run of
+// the webhook job.
+func (p *Plugin) webhookPostEvent(event string, post *model.Post, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	aliases, err := p.getTagAliases()
+	if err != nil {
+		p.API.LogError("Failed to queue webhook event", "error", err.Error(), "event", event, "post_id", post.Id)
+		return
+	}
+	queued, err := p.queueWebhookEvent(event, post, postTagKeys(tags, aliases))
+	if err != nil {
+		p.API.LogError("Failed to queue webhook event", "error", err.Error(), "event", event, "post_id", post.Id)
+		return
+	}
+	if queued {
+		p.webhookSends.Add(1)
+		go func() {
+			defer p.webhookSends.Done()
+			p.runWebhookJob()
+		}()
+	}
+}
+
+// addedTags returns the tags of after that before did not use.
+func addedTags(before, after []string) []string {
+	had := map[string]bool{}
+	for _, t := range before {
+		had[canonicalTag(t)] = true
+	}
+	var added []string
+	for _, t := range after {
+		if !had[canonicalTag(t)] {
+			added = append(added, t)
+		}
+	}
+	return added
+}
+
+// validateTagWebhook checks a webhook before it is saved.
+func validateTagWebhook(hook TagWebhook) error {
+	if hook.Pattern == "" || hook.URL == "" {
+		return errors.New("pattern and url are required")
+	}
+	if _, err := tagPatternRegexp(hook.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	for _, event := range hook.Events {
+		valid := false
+		for _, e := range webhookEvents {
+			valid = valid || e == event
+		}
+		if !valid {
+			return fmt.Errorf("invalid event %q, expected one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// GET /api/admin/webhooks
+// POST /api/admin/webhooks {"pattern": "incident*", "url": "https://example.com/hook", "events": ["post_created"]}
+// DELETE /api/admin/webhooks?id=XXX
+func (p *Plugin) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var hooks []TagWebhook
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err = p.getWebhooks()
+	case http.MethodPost:
+		var hook TagWebhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		hook.Pattern = strings.TrimPrefix(strings.TrimSpace(hook.Pattern), "#")
+		if err := validateTagWebhook(hook); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook.ID = model.NewId()
+		hook.CreatorID = userID
+		hook.CreateAt = model.GetMillis()
+		if hook.Secret == "" {
+			hook.Secret = model.NewRandomString(32)
+		}
+		hooks, err = p.updateWebhooks(func(hooks []TagWebhook) []TagWebhook {
+			return append(hooks, hook)
+		})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		hooks, err = p.updateWebhooks(func(hooks []TagWebhook) []TagWebhook {
+			kept := hooks[:0]
+			for _, hook := range hooks {
+				if hook.ID != id {
+					kept = append(kept, hook)
+				}
+			}
+			return kept
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	queue, err := p.getWebhookQueue()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hooks == nil {
+		hooks = []TagWebhook{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(TagWebhooksResponse{Webhooks: hooks, Pending: len(queue)}); err != nil {
+		p.API.LogError("Failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagWebhookMatchingTags(t *testing.T) {
+	hook := TagWebhook{Pattern: "Team:*"}
+	assert.Equal(t, []string{"team:web", "team:"}, hook.matchingTags([]string{"team:web", "teams", "team:", "incident"}))
+	hook = TagWebhook{Pattern: "incident"}
+	assert.Equal(t, []string{"incident"}, hook.matchingTags([]string{"incident", "incidents"}))
+	hook = TagWebhook{Pattern: "a.b"}
+	assert.Empty(t, hook.matchingTags([]string{"axb"}))
+
+	assert.True(t, TagWebhook{}.wants(webhookEventDeleted))
+	assert.False(t, TagWebhook{Events: []string{webhookEventCreated}}.wants(webhookEventEdited))
+}
+
+func TestWebhookRetryDelayAfter(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookRetryDelayAfter(1))
+	assert.Equal(t, time.Minute, webhookRetryDelayAfter(2))
+	assert.Equal(t, 4*time.Minute, webhookRetryDelayAfter(4))
+	assert.Equal(t, time.Hour, webhookRetryDelayAfter(webhookMaxAttempts))
+}
+
+func TestAddedTags(t *testing.T) {
+	assert.Equal(t, []string{"outage"}, addedTags([]string{"Incident"}, []string{"incident", "outage"}))
+	assert.Empty(t, addedTags([]string{"incident", "outage"}, []string{"incident"}))
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var mu sync.Mutex
+	fail := true
+	var received []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	api := newTestAPI()
+	api.channelTypes["dm1"] = model.ChannelTypeDirect
+	p := &Plugin{}
+	p.SetAPI(api)
+	require.NoError(t, p.kvSetJSON(webhooksKey, []TagWebhook{
+		{ID: "h1", Pattern: "incident*", URL: server.URL, Secret: "s3cret"},
+		{ID: "h2", Pattern: "outage", URL: server.URL, Secret: "other", Events: []string{webhookEventDeleted}},
+	}))
+
+	post := &model.Post{Id: "post1", ChannelId: "channel1", UserId: "user1", Message: "#incident-db down #outage"}
+	queued, err := p.queueWebhookEvent(webhookEventCreated, post, []string{"incident-db", "outage"})
+	require.NoError(t, err)
+	assert.True(t, queued)
+
+	queued, err = p.queueWebhookEvent(webhookEventCreated, &model.Post{Id: "post2", ChannelId: "dm1", UserId: "user1"}, []string{"incident"})
+	require.NoError(t, err)
+	assert.False(t, queued)
+
+	queue, err := p.getWebhookQueue()
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "h1", queue[0].WebhookID)
+	// The body waits under its own key
+	assert.Empty(t, queue[0].Payload)
+	assert.Contains(t, api.kv, webhookPayloadKeyPrefix+queue[0].ID)
+	deliveryID := queue[0].ID
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	require.NoError(t, p.processWebhookQueue(now))
+	queue, err = p.getWebhookQueue()
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, 1, queue[0].Attempts)
+	assert.Equal(t, now+webhookRetryDelay.Milliseconds(), queue[0].NextAttempt)
+	assert.Contains(t, queue[0].LastError, "503")
+
+	// Not due yet
+	require.NoError(t, p.processWebhookQueue(now+time.Second.Milliseconds()))
+	require.Len(t, received, 1)
+
+	fail = false
+	require.NoError(t, p.processWebhookQueue(now+31*time.Second.Milliseconds()))
+	queue, err = p.getWebhookQueue()
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+	assert.NotContains(t, api.kv, webhookPayloadKeyPrefix+deliveryID)
+
+	require.Len(t, received, 2)
+	req, body := received[1], bodies[1]
+	assert.Equal(t, webhookEventCreated, req.Header.Get(webhookEventHeader))
+	assert.Equal(t, "sha256="+signWebhookPayload("s3cret", body), req.Header.Get(webhookSignatureHeader))
+	var payload WebhookPayload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, req.Header.Get(webhookDeliveryHeader), payload.ID)
+	assert.Equal(t, []string{"incident-db"}, payload.Tags)
+	assert.Equal(t, "post1", payload.Post.ID)
+	assert.Equal(t, "https://chat.example.com/_redirect/pl/post1", payload.Post.Permalink)
+	assert.Equal(t, "team1", payload.Channel.TeamID)
+
+	// Deliveries of a deleted webhook are dropped without being sent
+	queued, err = p.queueWebhookEvent(webhookEventDeleted, post, []string{"outage"})
+	require.NoError(t, err)
+	assert.True(t, queued)
+	require.NoError(t, p.kvSetJSON(webhooksKey, []TagWebhook{{ID: "h1", Pattern: "incident*", URL: server.URL}}))
+	require.NoError(t, p.processWebhookQueue(now+time.Minute.Milliseconds()))
+	queue, err = p.getWebhookQueue()
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+	assert.Len(t, received, 2)
+
+	// Deliveries queued with their body inline are still sent
+	require.NoError(t, p.kvSetJSON(webhookQueueKey, []webhookDelivery{
+		{ID: "d1", WebhookID: "h1", Event: webhookEventCreated, Payload: json.RawMessage(`{"id":"d1"}`)},
+	}))
+	require.NoError(t, p.processWebhookQueue(now+2*time.Minute.Milliseconds()))
+	require.Len(t, received, 3)
+	assert.JSONEq(t, `{"id":"d1"}`, string(bodies[2]))
+	queue, err = p.getWebhookQueue()
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+	for key := range api.kv {
+		assert.NotContains(t, key, webhookPayloadKeyPrefix)
+	}
+}
+
+// TestWebhookSendsWaited checks that OnDeactivate waits for the deliveries
+// the hooks started.
+func TestWebhookSendsWaited(t *testing.T) {
+	release := make(chan struct{})
+	var delivered atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		delivered.Store(true)
+	}))
+	defer server.Close()
+
+	api := newTestAPI()
+	p := &Plugin{}
+	p.SetAPI(api)
+	require.NoError(t, p.kvSetJSON(webhooksKey, []TagWebhook{{ID: "h1", Pattern: "incident", URL: server.URL, Secret: "s3cret"}}))
+
+	p.webhookPostEvent(webhookEventCreated, &model.Post{Id: "post1", ChannelId: "channel1", UserId: "user1"}, []string{"incident"})
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	require.NoError(t, p.OnDeactivate())
+	assert.True(t, delivered.Load())
+}
+
+func TestValidateTagWebhook(t *testing.T) {
+	assert.NoError(t, validateTagWebhook(TagWebhook{Pattern: "team:*", URL: "https://example.com/hook"}))
+	assert.Error(t, validateTagWebhook(TagWebhook{Pattern: "team:*"}))
+	assert.Error(t, validateTagWebhook(TagWebhook{Pattern: "team:*", URL: "ftp://example.com"}))
+	assert.Error(t, validateTagWebhook(TagWebhook{Pattern: "team:*", URL: "https://example.com", Events: []string{"post_liked"}}))
+}
+
+func TestHandleWebhooks(t *testing.T) {
+	api := &authAPI{testAPI: newTestAPI(), system: true}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	request := func(method, url, body string) TagWebhooksResponse {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin")
+		w := httptest.NewRecorder()
+		p.handleWebhooks(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TagWebhooksResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+
+	// Webhooks added at the same time are all kept
+	patterns := []string{"incident", "outage", "team:*", "release*"}
+	var wg sync.WaitGroup
+	for _, pattern := range patterns {
+		wg.Add(1)
+		go func(pattern string) {
+			defer wg.Done()
+			request(http.MethodPost, "/api/admin/webhooks", `{"pattern": "`+pattern+`", "url": "https://example.com/hook"}`)
+		}(pattern)
+	}
+	wg.Wait()
+
+	hooks, err := p.getWebhooks()
+	require.NoError(t, err)
+	require.Len(t, hooks, len(patterns))
+
+	response := request(http.MethodDelete, "/api/admin/webhooks?id="+hooks[0].ID, "")
+	assert.Len(t, response.Webhooks, len(patterns)-1)
+	assert.NotContains(t, response.Webhooks, hooks[0])
+}
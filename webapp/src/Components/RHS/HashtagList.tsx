@@ -22,6 +22,8 @@ type Tab = 'channel' | 'team';
 
 interface HashtagData {
     tag: string;
+    display?: string;
+    variants?: string[];
     count: number;
     lastUsed?: number;
 }
@@ -241,7 +243,7 @@ export default function HashtagList({channelId, onSelect}: {channelId: string; o
         }
     };
 
-    const sortHashtags = (tags: {tag: string; count: number; lastUsed?: number}[]) => {
+    const sortHashtags = (tags: HashtagData[]) => {
         if (!tags || tags.length === 0) return [];
         
         return [...tags].sort((a, b) => {
@@ -488,7 +490,7 @@ export default function HashtagList({channelId, onSelect}: {channelId: string; o
                                 </button>
                                 {expandedGroups.has(group.prefix) && (
                                     <div style={styles.accordionPanel}>
-                                        {sortedGroupTags.map(({tag, count}) => (
+                                        {sortedGroupTags.map(({tag, display, count}) => (
                                             <div key={tag} style={styles.listItem}>
                                                 <button
                                                     onClick={() => {
@@ -498,7 +500,7 @@ export default function HashtagList({channelId, onSelect}: {channelId: string; o
                                                     style={styles.hashtagButton}
                                                     className="hashtag-button"
                                                 >
-                                                    <span style={styles.tag}>#{tag}</span>
+                                                    <span style={styles.tag}>#{display || tag}</span>
                                                     <span style={styles.count}>
                                                         {count} {count === 1 ? 'post' : 'posts'}
                                                     </span>
@@ -513,14 +515,14 @@ export default function HashtagList({channelId, onSelect}: {channelId: string; o
                         {/* Show ungrouped tags (those without prefixes) */}
                         {sortHashtags(
                             data.hashtags.filter(tag => !data.groups.some(g => g.tags.some(t => t.tag === tag.tag)))
-                        ).map(({tag, count}) => (
+                        ).map(({tag, display, count}) => (
                                 <div key={tag} style={styles.listItem}>
                                     <button
                                         onClick={() => onSelect(tag, activeTab === 'channel' ? channelId : undefined)}
                                         style={styles.hashtagButton}
                                         className="hashtag-button"
                                     >
-                                        <span style={styles.tag}>#{tag}</span>
+                                        <span style={styles.tag}>#{display || tag}</span>
                                         <span style={styles.count}>
                                             {count} {count === 1 ? 'post' : 'posts'}
                                         </span>
@@ -534,14 +536,14 @@ export default function HashtagList({channelId, onSelect}: {channelId: string; o
                         {sortHashtags([
                             ...data.groups.flatMap(group => group.tags),
                             ...data.hashtags.filter(tag => !data.groups.some(g => g.tags.some(t => t.tag === tag.tag)))
-                        ]).map(({tag, count}) => (
+                        ]).map(({tag, display, count}) => (
                             <div key={tag} style={styles.listItem}>
                                 <button
                                     onClick={() => onSelect(tag, activeTab === 'channel' ? channelId : undefined)}
                                     style={styles.hashtagButton}
                                     className="hashtag-button"
                                 >
-                                    <span style={styles.tag}>#{tag}</span>
+                                    <span style={styles.tag}>#{display || tag}</span>
                                     <span style={styles.count}>
                                         {count} {count === 1 ? 'post' : 'posts'}
                                     </span>
@@ -113,19 +113,21 @@ export default function TagResults({teamId, tag, onBack, channelId}: {teamId: st
   const [perPage, setPerPage] = useState(20);
   const [totalCount, setTotalCount] = useState(0);
   const [hasMore, setHasMore] = useState(false);
+  // cursors[i] is the cursor that fetches page i + 1; page 1 needs none
+  const [cursors, setCursors] = useState<(string | undefined)[]>([undefined]);
   
   // Get team name from Redux store
   const team = useSelector((state: any) => state.entities.teams.teams[teamId]);
   const teamName = team ? team.name : '';
 
-  const fetchPosts = async (page: number, pageSize: number) => {
+  const fetchPosts = async (page: number, pageSize: number, cursor?: string) => {
     try {
       setLoading(true);
       setError(null);
       
       console.log('TagResults: Fetching posts for tag:', tag, 'channelId:', channelId, 'page:', page, 'perPage:', pageSize);
       
-      const response: PaginatedHashtagResponse = await fetchHashtagPosts(tag, channelId, page, pageSize);
+      const response: PaginatedHashtagResponse = await fetchHashtagPosts(tag, channelId, page, pageSize, cursor);
       console.log('TagResults: Received paginated response:', response);
       
       if (!response) {
@@ -145,6 +147,11 @@ export default function TagResults({teamId, tag, onBack, channelId}: {teamId: st
       setPosts(response.posts);
       setTotalCount(response.total_count);
       setHasMore(response.has_more);
+      setCursors((prev) => {
+        const next = prev.slice(0, page);
+        next[page] = response.next_cursor;
+        return next;
+      });
       setCurrentPage(page);
     } catch (e) {
       console.error('TagResults: Error fetching posts:', e);
@@ -156,18 +163,19 @@ export default function TagResults({teamId, tag, onBack, channelId}: {teamId: st
   };
 
   useEffect(() => {
+    setCursors([undefined]);
     fetchPosts(1, perPage);
   }, [tag, channelId, perPage]);
 
   const handleNextPage = () => {
     if (hasMore) {
-      fetchPosts(currentPage + 1, perPage);
+      fetchPosts(currentPage + 1, perPage, cursors[currentPage]);
     }
   };
 
   const handlePreviousPage = () => {
     if (currentPage > 1) {
-      fetchPosts(currentPage - 1, perPage);
+      fetchPosts(currentPage - 1, perPage, cursors[currentPage - 2]);
     }
   };
 
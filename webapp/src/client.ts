@@ -1,11 +1,170 @@
+export interface HashtagCount {
+    tag: string;
+    display?: string;
+    variants?: string[];
+    aliases?: string[];
+    facet?: TagFacet;
+    count: number;
+    lastUsed?: number;
+    trend?: number;
+}
+
+export interface TagFacet {
+    key: string;
+    value: string;
+}
+
+export interface FacetValueCount {
+    value: string;
+    display: string;
+    count: number;
+    lastUsed: number;
+}
+
+export interface FacetCount {
+    key: string;
+    count: number;
+    values: FacetValueCount[];
+}
+
+export interface FacetsResponse {
+    facets: FacetCount[];
+}
+
+export interface RelatedTag {
+    tag: string;
+    count: number;
+    tag_count: number;
+    lift: number;
+    pmi: number;
+}
+
+export interface RelatedTagsResponse {
+    tag: string;
+    count: number;
+    total: number;
+    related: RelatedTag[];
+}
+
+export interface TimeseriesPoint {
+    start: number;
+    label: string;
+    posts: number;
+    authors: number;
+}
+
+export interface TagTimeseriesResponse {
+    tag: string;
+    bucket: 'day' | 'week' | 'month';
+    timezone: string;
+    since: number;
+    until: number;
+    points: TimeseriesPoint[];
+}
+
+export interface TrendingTag {
+    tag: string;
+    display: string;
+    recent: number;
+    baseline: number;
+    score: number;
+}
+
+export interface TrendingResponse {
+    window: number;
+    since: number;
+    until: number;
+    min_posts: number;
+    tags: TrendingTag[];
+}
+
+export interface TagContributor {
+    user_id: string;
+    username: string;
+    posts: number;
+    first_used: number;
+    last_used: number;
+    share: number;
+}
+
+export interface TagContributorsResponse {
+    tag: string;
+    total: number;
+    authors: number;
+    contributors: TagContributor[];
+}
+
+export interface TagExpert {
+    user_id: string;
+    username: string;
+    score: number;
+    posts: number;
+    last_used: number;
+}
+
+export interface TagExpertsResponse {
+    tag: string;
+    experts: TagExpert[];
+}
+
+export interface ExpertiseTag {
+    tag: string;
+    score: number;
+    posts: number;
+    last_used: number;
+}
+
+export interface UserExpertiseResponse {
+    user_id: string;
+    username: string;
+    tags: ExpertiseTag[];
+}
+
+export interface TagFollow {
+    user_id: string;
+    tag: string;
+    scope: 'channel' | 'team' | 'global';
+    scope_id?: string;
+    create_at: number;
+}
+
+export interface FollowsResponse {
+    follows: TagFollow[];
+}
+
+export interface FollowRequest {
+    tag: string;
+    scope?: 'channel' | 'team' | 'global';
+    channel_id?: string;
+    team_id?: string;
+}
+
+// TagSuggestion is a model.AutocompleteListItem, which has no JSON tags.
+export interface TagSuggestion {
+    Item: string;
+    Hint: string;
+    HelpText: string;
+}
+
 export interface HashtagGroup {
     prefix: string;
-    tags: Array<{tag: string; count: number}>;
+    tags: HashtagCount[];
+}
+
+export interface HashtagTreeNode {
+    name: string;
+    path: string;
+    tag?: HashtagCount;
+    count: number;
+    tag_count: number;
+    lastUsed: number;
+    children?: HashtagTreeNode[];
 }
 
 export interface HashtagResponse {
-    hashtags: Array<{tag: string; count: number; lastUsed?: number}>;
+    hashtags: HashtagCount[];
     groups: HashtagGroup[];
+    tree?: HashtagTreeNode[];
 }
 
 export interface PaginatedHashtagResponse {
@@ -14,6 +173,17 @@ export interface PaginatedHashtagResponse {
     page: number;
     per_page: number;
     has_more: boolean;
+    next_cursor?: string;
+}
+
+export interface QueryError {
+    message: string;
+    offset: number;
+    length: number;
+}
+
+export interface QueryErrorResponse {
+    error: QueryError;
 }
 
 export interface HashtagPost {
@@ -32,6 +202,7 @@ export interface HashtagPost {
     props: Record<string, any>;
     type: string;
     hashtags: string;
+    matched_tag?: string;
     pending_post_id: string;
     reply_count: number;
     metadata: {
@@ -53,7 +224,7 @@ export async function fetchHashtags(channelId: string) {
     return resp.json() as Promise<HashtagResponse>;
 }
 
-export async function fetchHashtagPosts(tag: string, channelId?: string, page = 1, perPage = 20): Promise<PaginatedHashtagResponse> {
+export async function fetchHashtagPosts(tag: string, channelId?: string, page = 1, perPage = 20, cursor?: string): Promise<PaginatedHashtagResponse> {
     const url = new URL('/plugins/com.ecf.hashtags/api/posts', window.location.origin);
     url.searchParams.set('tag', tag);
     url.searchParams.set('page', page.toString());
@@ -61,6 +232,9 @@ export async function fetchHashtagPosts(tag: string, channelId?: string, page =
     if (channelId) {
         url.searchParams.set('channel_id', channelId);
     }
+    if (cursor) {
+        url.searchParams.set('cursor', cursor);
+    }
     const resp = await fetch(url.toString(), {
         method: 'GET',
         headers: {'X-Requested-With': 'XMLHttpRequest'},
@@ -79,3 +253,194 @@ export async function fetchTeamHashtags(teamId: string) {
     if (!resp.ok) throw new Error(await resp.text());
     return resp.json() as Promise<HashtagResponse>;
 }
+
+export async function fetchFacets(scope: {channelId?: string; teamId?: string}) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/facets', window.location.origin);
+    if (scope.channelId) {
+        url.searchParams.set('channel_id', scope.channelId);
+    }
+    if (scope.teamId) {
+        url.searchParams.set('team_id', scope.teamId);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<FacetsResponse>;
+}
+
+export async function fetchRelatedTags(tag: string, scope: {channelId?: string; teamId?: string}) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/related', window.location.origin);
+    url.searchParams.set('tag', tag);
+    if (scope.channelId) {
+        url.searchParams.set('scope', 'channel');
+        url.searchParams.set('channel_id', scope.channelId);
+    } else if (scope.teamId) {
+        url.searchParams.set('scope', 'team');
+        url.searchParams.set('team_id', scope.teamId);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<RelatedTagsResponse>;
+}
+
+export async function fetchTagTimeseries(tag: string, bucket: 'day' | 'week' | 'month', scope: {channelId?: string; teamId?: string}, since?: string) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/tag_timeseries', window.location.origin);
+    url.searchParams.set('tag', tag);
+    url.searchParams.set('bucket', bucket);
+    if (scope.channelId) {
+        url.searchParams.set('scope', 'channel');
+        url.searchParams.set('channel_id', scope.channelId);
+    } else if (scope.teamId) {
+        url.searchParams.set('scope', 'team');
+        url.searchParams.set('team_id', scope.teamId);
+    }
+    if (since) {
+        url.searchParams.set('since', since);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<TagTimeseriesResponse>;
+}
+
+export async function fetchTrending(scope: {channelId?: string; teamId?: string}, period?: string) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/trending', window.location.origin);
+    if (scope.channelId) {
+        url.searchParams.set('scope', 'channel');
+        url.searchParams.set('channel_id', scope.channelId);
+    } else if (scope.teamId) {
+        url.searchParams.set('scope', 'team');
+        url.searchParams.set('team_id', scope.teamId);
+    }
+    if (period) {
+        url.searchParams.set('window', period);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<TrendingResponse>;
+}
+
+export async function fetchTagContributors(tag: string, scope: {channelId?: string; teamId?: string}) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/contributors', window.location.origin);
+    url.searchParams.set('tag', tag);
+    if (scope.channelId) {
+        url.searchParams.set('scope', 'channel');
+        url.searchParams.set('channel_id', scope.channelId);
+    } else if (scope.teamId) {
+        url.searchParams.set('scope', 'team');
+        url.searchParams.set('team_id', scope.teamId);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<TagContributorsResponse>;
+}
+
+export async function fetchTagExperts(tag: string, teamId?: string) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/experts', window.location.origin);
+    url.searchParams.set('tag', tag);
+    if (teamId) {
+        url.searchParams.set('team_id', teamId);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<TagExpertsResponse>;
+}
+
+export async function fetchUserExpertise(user: {userId?: string; username?: string}, teamId?: string) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/expertise', window.location.origin);
+    if (user.userId) {
+        url.searchParams.set('user_id', user.userId);
+    }
+    if (user.username) {
+        url.searchParams.set('username', user.username);
+    }
+    if (teamId) {
+        url.searchParams.set('team_id', teamId);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<UserExpertiseResponse>;
+}
+
+export async function fetchFollows() {
+    const resp = await fetch('/plugins/com.ecf.hashtags/api/follows', {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<FollowsResponse>;
+}
+
+export async function followTag(request: FollowRequest) {
+    const resp = await fetch('/plugins/com.ecf.hashtags/api/follows', {
+        method: 'POST',
+        headers: {'X-Requested-With': 'XMLHttpRequest', 'Content-Type': 'application/json'},
+        credentials: 'same-origin',
+        body: JSON.stringify(request),
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<FollowsResponse>;
+}
+
+export async function unfollowTag(request: FollowRequest) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/follows', window.location.origin);
+    url.searchParams.set('tag', request.tag);
+    if (request.scope) {
+        url.searchParams.set('scope', request.scope);
+    }
+    if (request.channel_id) {
+        url.searchParams.set('channel_id', request.channel_id);
+    }
+    if (request.team_id) {
+        url.searchParams.set('team_id', request.team_id);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'DELETE',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<FollowsResponse>;
+}
+
+export async function fetchTagSuggestions(prefix: string, teamId?: string) {
+    const url = new URL('/plugins/com.ecf.hashtags/api/autocomplete', window.location.origin);
+    url.searchParams.set('prefix', prefix);
+    if (teamId) {
+        url.searchParams.set('team_id', teamId);
+    }
+    const resp = await fetch(url.toString(), {
+        method: 'GET',
+        headers: {'X-Requested-With': 'XMLHttpRequest'},
+        credentials: 'same-origin',
+    });
+    if (!resp.ok) throw new Error(await resp.text());
+    return resp.json() as Promise<TagSuggestion[]>;
+}